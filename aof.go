@@ -0,0 +1,531 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The append-only file logs every write command in RESP, the way clients send
+// them, and is replayed through the dispatcher at startup. Commands whose
+// effect depends on the time or on chance are logged in a form that replays
+// the same, see rewriteCommand. BGREWRITEAOF compacts the log into the
+// commands that recreate the current keyspace.
+
+// Rewrites put at most this many elements in one command, like Redis.
+const aofRewriteItemsPerCmd = 64
+
+// appendOnlyFile is the append-only file state of a server, guarded by
+// srv.mu.
+type appendOnlyFile struct {
+	// Nil while the append-only file is disabled.
+	file *os.File
+	// Logged commands not written to file yet.
+	buf bytes.Buffer
+	// The database the logged commands run on, -1 when the next one needs a
+	// SELECT first.
+	db int
+	// What blocked clients served by the running command did, logged after
+	// the command itself.
+	served []loggedCommand
+	// Set while EXEC runs. Its commands are logged between MULTI and EXEC,
+	// MULTI goes out with the first one.
+	inExec      bool
+	multiLogged bool
+	// The last error writing to file, nil when the last write succeeded.
+	writeErr error
+
+	rewriteInProgress bool
+	// Set by BGREWRITEAOF while a rewrite couldn't start yet.
+	rewriteScheduled bool
+	// Commands logged while the rewrite runs, appended to the rewritten file.
+	rewriteBuf    bytes.Buffer
+	lastRewriteOK bool
+}
+
+type loggedCommand struct {
+	db   int
+	args []string
+}
+
+func (srv *server) appendOnlyPath() string {
+	return filepath.Join(srv.cfg.dir, srv.cfg.appendFilename)
+}
+
+// logging reports whether writes are logged, to the file or for a rewrite.
+func (a *appendOnlyFile) logging() bool {
+	return a.file != nil || a.rewriteInProgress
+}
+
+// feed logs a command that ran on database db.
+func (a *appendOnlyFile) feed(db int, args []string) {
+	if !a.logging() {
+		return
+	}
+
+	var cmd bytes.Buffer
+	if a.inExec && !a.multiLogged {
+		cmd.WriteString(serializeCommand("multi"))
+		a.multiLogged = true
+	}
+	if db != a.db {
+		cmd.WriteString(serializeCommand("select", strconv.Itoa(db)))
+		a.db = db
+	}
+	cmd.WriteString(serializeCommand(args...))
+
+	if a.file != nil {
+		a.buf.Write(cmd.Bytes())
+	}
+	if a.rewriteInProgress {
+		a.rewriteBuf.Write(cmd.Bytes())
+	}
+}
+
+func serializeCommand(args ...string) string {
+	msg, _, _ := serializeStringArray(args)
+	return msg
+}
+
+// propagate logs args as a command that ran on store, for writes that don't
+// come from a command of their own, like deleting expired keys.
+// Must be called with store.mu held.
+func propagate(store *dictionary, args ...string) {
+	if store.srv == nil {
+		return
+	}
+	store.srv.aof.feed(store.id, args)
+}
+
+// alsoPropagate logs args once the running command is logged. Blocked clients
+// are served while the command that pushed to their list runs, their pops
+// have to replay after it.
+// Must be called with store.mu held.
+func alsoPropagate(store *dictionary, args ...string) {
+	a := &store.srv.aof
+	if !a.logging() {
+		return
+	}
+	a.served = append(a.served, loggedCommand{db: store.id, args: args})
+}
+
+// rewriteCommand makes call log cmds instead of the running command, or
+// nothing when there are none.
+func rewriteCommand(conn *client, cmds ...[]string) {
+	conn.rewritten = true
+	conn.rewrites = cmds
+}
+
+// logCommand logs a write that succeeded, followed by what the blocked
+// clients it served did. Blocking commands only log what they pop, since
+// whether they would block again depends on when they are replayed.
+// Must be called with store.mu held.
+func logCommand(store *dictionary, conn *client, spec *commandSpec, arr []interface{}) {
+	a := &store.srv.aof
+	if !a.logging() {
+		return
+	}
+	switch {
+	case conn.rewritten:
+		for _, args := range conn.rewrites {
+			a.feed(store.id, args)
+		}
+	case spec.flags&flagBlocking == 0:
+		args := make([]string, len(arr))
+		for i, arg := range arr {
+			args[i] = arg.(string)
+		}
+		a.feed(store.id, args)
+	}
+	for _, cmd := range a.served {
+		a.feed(cmd.db, cmd.args)
+	}
+	a.served = nil
+}
+
+// beginLoggedExec and endLoggedExec wrap the commands EXEC runs in a
+// transaction of their own, so a replay never stops halfway through it.
+// Must be called with srv.mu held.
+func beginLoggedExec(srv *server) {
+	srv.aof.inExec = true
+}
+
+func endLoggedExec(srv *server) {
+	a := &srv.aof
+	if a.multiLogged {
+		a.feed(a.db, []string{"exec"})
+	}
+	a.inExec, a.multiLogged = false, false
+}
+
+// writeAppendOnlyFile writes the logged commands to the file, syncing it
+// right away with the always policy. Data that couldn't be written stays
+// buffered and is retried by the next write.
+// Must be called with srv.mu held.
+func writeAppendOnlyFile(srv *server) {
+	a := &srv.aof
+	if a.file == nil || a.buf.Len() == 0 {
+		return
+	}
+	_, err := a.buf.WriteTo(a.file)
+	if err == nil && srv.cfg.appendFsync == fsyncAlways {
+		err = a.file.Sync()
+	}
+	if err != nil && srv.cfg.appendFsync == fsyncAlways {
+		// Replying to a write that isn't on disk would break the promise.
+		log.Fatal("Can't recover from an append-only file write error with the always fsync policy: ", err)
+	}
+	if err != nil && a.writeErr == nil {
+		log.Println("Error writing to the append-only file:", err)
+	}
+	a.writeErr = err
+}
+
+// appendOnlyFileCron writes buffered commands and syncs the file once a
+// second, and starts scheduled rewrites.
+func appendOnlyFileCron(srv *server) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		srv.mu.Lock()
+		a := &srv.aof
+		writeAppendOnlyFile(srv)
+		if a.rewriteScheduled && !a.rewriteInProgress {
+			startAppendOnlyRewrite(srv)
+		}
+		file := a.file
+		srv.mu.Unlock()
+
+		// Syncing may take a while, it doesn't need the lock. A rewrite may
+		// close the file meanwhile, the new one was synced when written.
+		if file != nil && srv.cfg.appendFsync == fsyncEverySec {
+			if err := file.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+				log.Println("Error syncing the append-only file:", err)
+			}
+		}
+	}
+}
+
+// openAppendOnlyFile starts logging writes. Without an append-only file yet,
+// it writes one from the keyspace loaded from the snapshot first.
+// Must be called before clients are served.
+func openAppendOnlyFile(srv *server) error {
+	path := srv.appendOnlyPath()
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		tmp := path + ".tmp"
+		if err := writeAppendOnlyRewrite(tmp, snapshotDatabases(srv, false)); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	srv.aof.file = f
+	srv.aof.db = -1
+	return nil
+}
+
+// startAppendOnlyRewrite copies the databases and writes the commands that
+// recreate them from another goroutine. Writes made in the meantime are logged
+// both to the current file and to rewriteBuf, which is appended to the new
+// file before it replaces the current one.
+// Must be called with srv.mu held.
+func startAppendOnlyRewrite(srv *server) {
+	a := &srv.aof
+	start := time.Now()
+	dbs := snapshotDatabases(srv, true)
+	a.rewriteInProgress = true
+	a.rewriteScheduled = false
+	a.rewriteBuf.Reset()
+	// The rewritten file starts without a selected database.
+	a.db = -1
+	log.Printf("Background append-only file rewriting started, copying took %v.", time.Since(start))
+
+	go func() {
+		tmp := filepath.Join(srv.cfg.dir, fmt.Sprintf("temp-rewriteaof-bg-%d.aof", os.Getpid()))
+		err := writeAppendOnlyRewrite(tmp, dbs)
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		if err == nil {
+			err = replaceAppendOnlyFile(srv, tmp)
+		}
+
+		a.rewriteInProgress = false
+		a.rewriteBuf.Reset()
+		a.lastRewriteOK = err == nil
+		if err != nil {
+			os.Remove(tmp)
+			log.Println("Error rewriting the append-only file:", err)
+			return
+		}
+		log.Println("Background append-only file rewriting finished.")
+	}()
+}
+
+// replaceAppendOnlyFile appends what was logged since the rewrite started to
+// the rewritten file, renames it over the current one and appends to it from
+// now on. Nothing is logged meanwhile, srv.mu is held all along.
+// Must be called with srv.mu held.
+func replaceAppendOnlyFile(srv *server, tmp string) error {
+	a := &srv.aof
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = a.rewriteBuf.WriteTo(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, srv.appendOnlyPath()); err != nil {
+		return err
+	}
+	if a.file == nil {
+		return nil
+	}
+	f, err = os.OpenFile(srv.appendOnlyPath(), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	a.file.Close()
+	a.file = f
+	a.buf.Reset()
+	a.writeErr = nil
+	return nil
+}
+
+// writeAppendOnlyRewrite writes the commands that recreate dbs to path and
+// syncs the file.
+func writeAppendOnlyRewrite(path string, dbs []snapshotDB) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	w := bufio.NewWriter(f)
+	for _, db := range dbs {
+		if len(db.dict) == 0 {
+			continue
+		}
+		w.WriteString(serializeCommand("select", strconv.Itoa(db.id)))
+		for key, rec := range db.dict {
+			if !recordExpired(rec.expiryTimestamp) {
+				rewriteRecord(w, key, rec)
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// rewriteRecord writes the commands that recreate rec at key.
+func rewriteRecord(w io.StringWriter, key string, rec record) {
+	var cmd string
+	var items []string
+	switch v := rec.value.(type) {
+	case string:
+		if rec.expiryTimestamp != -1 {
+			w.WriteString(serializeCommand("set", key, v, "pxat", strconv.FormatInt(rec.expiryTimestamp, 10)))
+			return
+		}
+		w.WriteString(serializeCommand("set", key, v))
+		return
+	case linkedList:
+		cmd = "rpush"
+		for n := v.head; n != nil; n = n.next {
+			items = append(items, n.value)
+		}
+	case *set:
+		cmd = "sadd"
+		items = v.list()
+	case *sortedSet:
+		cmd = "zadd"
+		for x := v.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+			items = append(items, formatDouble(x.score), x.member)
+		}
+	case *hash:
+		cmd = "hset"
+		for field, val := range v.fields {
+			items = append(items, field, val)
+		}
+	}
+
+	// Scores and hash values go with their member or field.
+	per := aofRewriteItemsPerCmd
+	if cmd == "zadd" || cmd == "hset" {
+		per *= 2
+	}
+	for len(items) > 0 {
+		n := min(len(items), per)
+		w.WriteString(serializeCommand(append([]string{cmd, key}, items[:n]...)...))
+		items = items[n:]
+	}
+	if rec.expiryTimestamp != -1 {
+		w.WriteString(serializeCommand("pexpireat", key, strconv.FormatInt(rec.expiryTimestamp, 10)))
+	}
+}
+
+// aofError reports where an append-only file stops being readable.
+type aofError struct {
+	// Offset of the command that couldn't be read.
+	offset int64
+	err    error
+}
+
+func (e *aofError) Error() string {
+	return fmt.Sprintf("bad append-only file at offset %d: %v", e.offset, e.err)
+}
+
+func (e *aofError) Unwrap() error {
+	return e.err
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// loadAppendOnlyFile replays the append-only file at path. Like Redis with
+// aof-load-truncated, a last command cut short, or a transaction missing its
+// EXEC, is dropped and the file truncated to the commands before it.
+func loadAppendOnlyFile(srv *server, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	valid, end, err := replayAppendOnlyFile(srv, f)
+	if err != nil {
+		return err
+	}
+	if end != valid {
+		log.Printf("The append-only file ends in the middle of a command or transaction, truncating it from %d to %d bytes.", end, valid)
+		if err := os.Truncate(path, valid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayAppendOnlyFile runs the commands of an append-only file. It returns
+// the end of the last command that replayed outside a transaction and the
+// number of bytes read, which differ when the file ends early. A command that
+// can't be read or run fails the replay with an *aofError.
+func replayAppendOnlyFile(srv *server, r io.Reader) (valid, end int64, err error) {
+	// Keys expire as the log says, not as the clock does while replaying.
+	srv.loading = true
+	defer func() { srv.loading = false }()
+
+	counter := &countingReader{r: r}
+	reader := newRespReader(counter)
+	conn := newClient(nil, srv.dbs[0])
+	conn.denyBlocking = true
+
+	for {
+		start := counter.n - int64(reader.rd.Buffered())
+		arr, err := reader.readCommand()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return valid, counter.n, &aofError{offset: start, err: err}
+		}
+		if len(arr) == 0 {
+			continue
+		}
+		if _, ok := commandTable[strings.ToLower(arr[0].(string))]; !ok {
+			return valid, counter.n, &aofError{offset: start, err: fmt.Errorf("unknown command '%.128s'", arr[0])}
+		}
+
+		dispatchCommand(arr, conn)
+		// Replies go nowhere.
+		conn.out.Reset()
+		if conn.multi == nil {
+			valid = counter.n - int64(reader.rd.Buffered())
+		}
+	}
+	srv.persistence.dirty = 0
+	return valid, counter.n, nil
+}
+
+// handleBgrewriteaof starts a rewrite of the append-only file. During EXEC
+// it is scheduled instead, so the transaction is logged to a single file.
+func handleBgrewriteaof(arr []interface{}, conn *client, store *dictionary) {
+	a := &store.srv.aof
+	if a.rewriteInProgress {
+		sendErrorToClient(conn, "ERR Background append only file rewriting already in progress")
+		return
+	}
+	if a.inExec {
+		a.rewriteScheduled = true
+		msg, _ := serializeSimpleString("Background append only file rewriting scheduled")
+		sendMsgToClient(conn, msg)
+		return
+	}
+	startAppendOnlyRewrite(store.srv)
+
+	msg, _ := serializeSimpleString("Background append only file rewriting started")
+	sendMsgToClient(conn, msg)
+}
+
+func infoAppendOnly(srv *server) []string {
+	a := &srv.aof
+	enabled, inProgress, scheduled := 0, 0, 0
+	if a.file != nil {
+		enabled = 1
+	}
+	if a.rewriteInProgress {
+		inProgress = 1
+	}
+	if a.rewriteScheduled {
+		scheduled = 1
+	}
+	rewriteStatus, writeStatus := "ok", "ok"
+	if !a.lastRewriteOK {
+		rewriteStatus = "err"
+	}
+	if a.writeErr != nil {
+		writeStatus = "err"
+	}
+	return []string{
+		fmt.Sprintf("aof_enabled:%d", enabled),
+		fmt.Sprintf("aof_rewrite_in_progress:%d", inProgress),
+		fmt.Sprintf("aof_rewrite_scheduled:%d", scheduled),
+		"aof_last_bgrewrite_status:" + rewriteStatus,
+		"aof_last_write_status:" + writeStatus,
+	}
+}
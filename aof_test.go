@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func serializeCommands(cmds ...[]string) string {
+	var buf strings.Builder
+	for _, args := range cmds {
+		buf.WriteString(serializeCommand(args...))
+	}
+	return buf.String()
+}
+
+func TestLoadAppendOnlyFile(t *testing.T) {
+	complete := serializeCommands(
+		[]string{"select", "0"},
+		[]string{"set", "key", "value"},
+		[]string{"rpush", "list", "a", "b"},
+		[]string{"select", "1"},
+		[]string{"sadd", "set", "x"},
+	)
+	tx := serializeCommands([]string{"multi"}, []string{"incr", "counter"}, []string{"exec"})
+	unknown := serializeCommands([]string{"nosuchcommand", "key"})
+
+	var tests = []struct {
+		name string
+		data string
+		// Length of the file after loading, -1 when loading fails.
+		wantLen int
+		wantKey string
+	}{
+		// the table itself
+		{"Should load a complete file", complete + tx, len(complete + tx), "counter"},
+		{"Should drop a truncated command", complete + tx + "*2\r\n$3\r\nde", len(complete + tx), "counter"},
+		{"Should drop a transaction without EXEC", complete + tx[:len(tx)-len("*1\r\n$4\r\nexec\r\n")], len(complete), "set"},
+		{"Should reject an unknown command", complete + unknown + tx, -1, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "appendonly.aof")
+			if err := os.WriteFile(path, []byte(test.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			srv := newServer(defaultConfig())
+			err := loadAppendOnlyFile(srv, path)
+			if test.wantLen == -1 {
+				var aofErr *aofError
+				if !errors.As(err, &aofErr) || aofErr.offset != int64(len(complete)) {
+					t.Fatalf("Expected an error at offset '%d' but got '%v'.", len(complete), err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info, _ := os.Stat(path)
+			if info.Size() != int64(test.wantLen) {
+				t.Errorf("Got length '%d' but expected '%d'.", info.Size(), test.wantLen)
+			}
+			if _, ok := srv.dbs[0].dict["key"]; !ok {
+				t.Errorf("Expected 'key' in db 0.")
+			}
+			if _, ok := srv.dbs[1].dict[test.wantKey]; !ok {
+				t.Errorf("Expected '%s' in db 1.", test.wantKey)
+			}
+			if _, ok := srv.dbs[1].dict["counter"]; ok != (test.wantKey == "counter") {
+				t.Errorf("Got 'counter' replayed '%v'.", ok)
+			}
+		})
+	}
+}
+
+func TestAppendOnlyRewrite(t *testing.T) {
+	dbs := testDatabases()
+	// A long list takes several commands.
+	var long linkedList
+	for i := 0; i < 3*aofRewriteItemsPerCmd+1; i++ {
+		long.pushBack(strings.Repeat("x", i))
+	}
+	dbs[0].dict["long"] = record{value: long, expiryTimestamp: -1}
+	// Expired keys are left out.
+	delete(dbs[2].dict, "set")
+
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	if err := writeAppendOnlyRewrite(path, dbs); err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer(defaultConfig())
+	if err := loadAppendOnlyFile(srv, path); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, db := range dbs {
+		got := srv.dbs[db.id].dict
+		if len(got) != len(db.dict) {
+			t.Errorf("Got '%d' keys in db %d but expected '%d'.", len(got), db.id, len(db.dict))
+		}
+		for key, want := range db.dict {
+			rec := got[key]
+			if rec.expiryTimestamp != want.expiryTimestamp {
+				t.Errorf("Got expiry '%d' for '%s' but expected '%d'.", rec.expiryTimestamp, key, want.expiryTimestamp)
+			}
+			if dumpValue(rec.value) != dumpValue(want.value) {
+				t.Errorf("Got '%v' for '%s' but expected '%v'.", dumpValue(rec.value), key, dumpValue(want.value))
+			}
+		}
+	}
+
+	data, _ := os.ReadFile(path)
+	// Four for the long list, one for the short one.
+	if n := bytes.Count(data, []byte("rpush")); n != 5 {
+		t.Errorf("Got '%d' RPUSH commands but expected '5'.", n)
+	}
+}
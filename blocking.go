@@ -0,0 +1,345 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listWaiter is a client parked in a blocking list command until one of its
+// keys receives data or it times out.
+type listWaiter struct {
+	keys []string
+	// serve pops on behalf of the client once key has data and returns the
+	// reply. It runs on the goroutine of the pushing client with store.mu held.
+	serve func(key string) string
+	reply chan string
+}
+
+// blockOnKeys queues a waiter on every key. Waiters on the same key are
+// served first come, first served.
+// Must be called with store.mu held.
+func blockOnKeys(store *dictionary, keys []string, serve func(key string) string) *listWaiter {
+	w := &listWaiter{keys: keys, serve: serve, reply: make(chan string, 1)}
+	for _, key := range keys {
+		store.blocked[key] = append(store.blocked[key], w)
+	}
+	return w
+}
+
+// unblockWaiter removes the waiter from the queues of all of its keys.
+// Must be called with store.mu held.
+func unblockWaiter(store *dictionary, w *listWaiter) {
+	for _, key := range w.keys {
+		queue := store.blocked[key]
+		for i, other := range queue {
+			if other == w {
+				queue = append(queue[:i], queue[i+1:]...)
+				break
+			}
+		}
+		if len(queue) == 0 {
+			delete(store.blocked, key)
+		} else {
+			store.blocked[key] = queue
+		}
+	}
+}
+
+// signalListReady serves clients blocked on key for as long as the list has
+// elements. Every command that pushes to a list calls it after the push.
+// Must be called with store.mu held.
+func signalListReady(store *dictionary, key string) {
+	for len(store.blocked[key]) > 0 {
+		ll, _, err := getList(store, key)
+		if err != nil || ll.length == 0 {
+			return
+		}
+		w := store.blocked[key][0]
+		// Unblock first, serving may push to another list and signal again.
+		unblockWaiter(store, w)
+		w.reply <- w.serve(key)
+	}
+}
+
+// waitUnblocked parks the connection until the waiter is served, the timeout
+// passes or the client disconnects. A zero timeout waits forever. ok is false
+// when the waiter wasn't served.
+// Must be called with store.mu held, it is released while waiting.
+func (c *client) waitUnblocked(store *dictionary, w *listWaiter, timeout time.Duration) (reply string, ok bool) {
+	store.mu.Unlock()
+	// Replies to commands pipelined before this one must not wait with it.
+	if err := c.flush(); err != nil {
+		log.Println("Error writing to connection:", err)
+	}
+	reply, ok = c.awaitReply(w, timeout)
+	store.mu.Lock()
+	if ok {
+		return reply, true
+	}
+
+	// The waiter may have been served while we were waking up.
+	select {
+	case reply := <-w.reply:
+		return reply, true
+	default:
+	}
+	unblockWaiter(store, w)
+	return "", false
+}
+
+// awaitReply waits for the reply to w without holding store.mu.
+func (c *client) awaitReply(w *listWaiter, timeout time.Duration) (string, bool) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	// Watch the connection so an element is never handed to a client that is
+	// gone. Peek doesn't consume anything pipelined after the command.
+	closed := make(chan struct{})
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		_, err := c.reader.rd.Peek(1)
+		var netErr net.Error
+		if err != nil && !(errors.As(err, &netErr) && netErr.Timeout()) {
+			close(closed)
+		}
+	}()
+	defer func() {
+		// Interrupt the peek, the reader must not be shared with the next read.
+		c.SetReadDeadline(time.Now())
+		<-watcherDone
+		c.SetReadDeadline(time.Time{})
+	}()
+
+	select {
+	case reply := <-w.reply:
+		return reply, true
+	case <-expired:
+	case <-closed:
+	}
+	return "", false
+}
+
+// parseTimeout parses a blocking timeout given in (fractional) seconds.
+func parseTimeout(arg interface{}) (time.Duration, error) {
+	secs, err := strconv.ParseFloat(arg.(string), 64)
+	if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) {
+		return 0, errors.New("ERR timeout is not a float or out of range")
+	}
+	if secs < 0 {
+		return 0, errors.New("ERR timeout is negative")
+	}
+	// Like Redis, a timeout has to fit in a Duration, which is about 292
+	// years.
+	if secs*float64(time.Second) >= math.MaxInt64 {
+		return 0, errors.New("ERR timeout is out of range")
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+func handleBLPop(arr []interface{}, conn *client, store *dictionary) {
+	blockingPopGeneric(arr, conn, store, true)
+}
+
+func handleBRPop(arr []interface{}, conn *client, store *dictionary) {
+	blockingPopGeneric(arr, conn, store, false)
+}
+
+// blockingPopGeneric implements BLPOP and BRPOP, replying with the key and the
+// popped element.
+func blockingPopGeneric(arr []interface{}, conn *client, store *dictionary, front bool) {
+	timeout, err := parseTimeout(arr[len(arr)-1])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	keys := make([]string, 0, len(arr)-2)
+	for _, key := range arr[1 : len(arr)-1] {
+		keys = append(keys, key.(string))
+	}
+
+	// Must be called with store.mu held and a non-empty list at key.
+	pop := func(key string) string {
+		ll, _, _ := getList(store, key)
+		val := popElements(store, key, ll, front, 1)[0]
+		if front {
+			alsoPropagate(store, "lpop", key)
+		} else {
+			alsoPropagate(store, "rpop", key)
+		}
+		msg, _, _ := serializeStringArray([]string{key, val})
+		return msg
+	}
+
+	blockingGeneric(conn, store, keys, timeout, pop)
+}
+
+func handleBLMove(arr []interface{}, conn *client, store *dictionary) {
+	from := strings.ToLower(arr[3].(string))
+	to := strings.ToLower(arr[4].(string))
+	if (from != "left" && from != "right") || (to != "left" && to != "right") {
+		sendErrorToClient(conn, "ERR syntax error")
+		return
+	}
+	timeout, err := parseTimeout(arr[5])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	blockingMoveGeneric(conn, store, arr[1].(string), arr[2].(string), from == "left", to == "left", timeout)
+}
+
+func handleBRPopLPush(arr []interface{}, conn *client, store *dictionary) {
+	timeout, err := parseTimeout(arr[3])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	blockingMoveGeneric(conn, store, arr[1].(string), arr[2].(string), false, true, timeout)
+}
+
+func blockingMoveGeneric(conn *client, store *dictionary, src string, dst string, fromFront bool, toFront bool, timeout time.Duration) {
+	move := func(key string) string {
+		// Logged before moving, which may serve clients blocked on dst whose
+		// pops replay after this. Only a wrong type at dst fails the move.
+		if _, _, err := getList(store, dst); err == nil {
+			alsoPropagate(store, "lmove", src, dst, listSide(fromFront), listSide(toFront))
+		}
+		val, _, err := moveElement(store, src, dst, fromFront, toFront)
+		if err != nil {
+			msg, _ := serializeSimpleError(err.Error())
+			return msg
+		}
+		return serializeBulkString(val)
+	}
+
+	blockingGeneric(conn, store, []string{src}, timeout, move)
+}
+
+func handleLMPop(arr []interface{}, conn *client, store *dictionary) {
+	keys, front, count, err := parseMPopArgs(arr[1:])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	for _, key := range keys {
+		ll, _, err := getList(store, key)
+		if err != nil {
+			sendErrorToClient(conn, err.Error())
+			return
+		}
+		if ll.length > 0 {
+			sendMsgToClient(conn, serializeMPopReply(key, popElements(store, key, ll, front, count)))
+			return
+		}
+	}
+	sendMsgToClient(conn, conn.serializeNullArray())
+}
+
+func handleBLMPop(arr []interface{}, conn *client, store *dictionary) {
+	timeout, err := parseTimeout(arr[1])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	keys, front, count, err := parseMPopArgs(arr[2:])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	pop := func(key string) string {
+		ll, _, _ := getList(store, key)
+		alsoPropagate(store, "lmpop", "1", key, listSide(front), "count", strconv.FormatInt(count, 10))
+		return serializeMPopReply(key, popElements(store, key, ll, front, count))
+	}
+
+	blockingGeneric(conn, store, keys, timeout, pop)
+}
+
+// listSide names the end of a list the way LMOVE and LMPOP take it.
+func listSide(front bool) string {
+	if front {
+		return "left"
+	}
+	return "right"
+}
+
+// parseMPopArgs parses "numkeys key [key ...] LEFT|RIGHT [COUNT count]".
+func parseMPopArgs(args []interface{}) (keys []string, front bool, count int64, err error) {
+	numKeys, err := parseInteger(args[0])
+	if err != nil || numKeys <= 0 {
+		return nil, false, 0, errors.New("ERR numkeys should be greater than 0")
+	}
+	if numKeys > int64(len(args)-2) {
+		return nil, false, 0, errors.New("ERR syntax error")
+	}
+	for _, key := range args[1 : numKeys+1] {
+		keys = append(keys, key.(string))
+	}
+	rest := args[numKeys+1:]
+
+	switch strings.ToLower(rest[0].(string)) {
+	case "left":
+		front = true
+	case "right":
+		front = false
+	default:
+		return nil, false, 0, errors.New("ERR syntax error")
+	}
+
+	count = 1
+	switch {
+	case len(rest) == 1:
+	case len(rest) == 3 && strings.ToLower(rest[1].(string)) == "count":
+		count, err = parseInteger(rest[2])
+		if err != nil || count <= 0 {
+			return nil, false, 0, errors.New("ERR count should be greater than 0")
+		}
+	default:
+		return nil, false, 0, errors.New("ERR syntax error")
+	}
+	return keys, front, count, nil
+}
+
+func serializeMPopReply(key string, vals []string) string {
+	elements, _, _ := serializeStringArray(vals)
+	return serializeArray([]string{serializeBulkString(key), elements})
+}
+
+// blockingGeneric serves the first key that holds a non-empty list right
+// away, otherwise it blocks the client on all keys.
+func blockingGeneric(conn *client, store *dictionary, keys []string, timeout time.Duration, serve func(key string) string) {
+	for _, key := range keys {
+		ll, _, err := getList(store, key)
+		if err != nil {
+			sendErrorToClient(conn, err.Error())
+			return
+		}
+		if ll.length > 0 {
+			sendMsgToClient(conn, serve(key))
+			return
+		}
+	}
+	if conn.denyBlocking {
+		sendMsgToClient(conn, conn.serializeNullArray())
+		return
+	}
+	w := blockOnKeys(store, keys, serve)
+
+	reply, ok := conn.waitUnblocked(store, w, timeout)
+	if !ok {
+		reply = conn.serializeNullArray()
+	}
+	sendMsgToClient(conn, reply)
+}
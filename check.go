@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// checkMain is redis-lite-check, the same binary installed under another
+// name, the way redis-check-aof is redis-server. "make build" links it next to
+// the server:
+//
+//	redis-lite-check [--fix] <file> [server options]
+//
+// It tells snapshots and append-only files apart by their header, reports
+// the offset where the file stops being readable and counts the keys by type.
+// With --fix an append-only file is truncated to its last complete command,
+// which recovers from a write torn by a crash. Server options like
+// --databases are needed to replay an append-only file the way the server
+// would. Returns the exit status, 0 when the file is valid or was repaired.
+func checkMain(args []string, stdout io.Writer) int {
+	fix := false
+	if len(args) > 0 && args[0] == "--fix" {
+		fix = true
+		args = args[1:]
+	}
+	if len(args) == 0 || strings.HasPrefix(args[0], "--") {
+		fmt.Fprintln(stdout, "Usage: redis-lite-check [--fix] <file> [server options]")
+		return 2
+	}
+	path := args[0]
+	cfg, err := parseConfig(args[1:])
+	if err != nil {
+		fmt.Fprintln(stdout, err)
+		return 2
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(stdout, err)
+		return 1
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		fmt.Fprintln(stdout, err)
+		return 1
+	}
+
+	r := bufio.NewReader(f)
+	header, _ := r.Peek(len(rdbMagic) + 4)
+	if bytes.HasPrefix(header, []byte(snapshotMagic)) || isRDBHeader(header) {
+		if fix {
+			fmt.Fprintln(stdout, "Only append-only files can be fixed, a snapshot can't be repaired.")
+			return 2
+		}
+		return checkSnapshot(r, info.Size(), stdout)
+	}
+	return checkAppendOnlyFile(r, path, info.Size(), cfg, fix, stdout)
+}
+
+// keyCounts counts keys by type.
+type keyCounts struct {
+	types    map[string]int
+	keys     int
+	volatile int
+}
+
+func (c *keyCounts) add(rec record) {
+	if c.types == nil {
+		c.types = map[string]int{}
+	}
+	c.types[typeName(rec.value)]++
+	c.keys++
+	if rec.expiryTimestamp != -1 {
+		c.volatile++
+	}
+}
+
+func (c *keyCounts) print(w io.Writer) {
+	fmt.Fprintf(w, "Keys: %d (%d with an expiry)\n", c.keys, c.volatile)
+	for _, name := range []string{"string", "list", "hash", "set", "zset"} {
+		fmt.Fprintf(w, "  %s: %d\n", name, c.types[name])
+	}
+}
+
+func checkSnapshot(r io.Reader, size int64, stdout io.Writer) int {
+	var counts keyCounts
+	err := decodeSnapshot(r, func(db int, key string, rec record) error {
+		counts.add(rec)
+		return nil
+	})
+
+	okUpTo := size
+	var snapErr *snapshotError
+	if errors.As(err, &snapErr) {
+		okUpTo = snapErr.offset
+	}
+	fmt.Fprintf(stdout, "Snapshot analyzed: size=%d, ok_up_to=%d, diff=%d\n", size, okUpTo, size-okUpTo)
+	counts.print(stdout)
+	if err != nil {
+		fmt.Fprintf(stdout, "Snapshot is not valid: %v\n", err)
+		return 1
+	}
+	fmt.Fprintln(stdout, "Snapshot is valid")
+	return 0
+}
+
+func checkAppendOnlyFile(r io.Reader, path string, size int64, cfg config, fix bool, stdout io.Writer) int {
+	// Replaying gives the keys the server would load.
+	srv := newServer(cfg)
+	valid, end, err := replayAppendOnlyFile(srv, r)
+	var aofErr *aofError
+	if err != nil && !errors.As(err, &aofErr) {
+		fmt.Fprintln(stdout, err)
+		return 1
+	}
+
+	fmt.Fprintf(stdout, "AOF analyzed: size=%d, ok_up_to=%d, diff=%d\n", size, valid, size-valid)
+	var counts keyCounts
+	for _, store := range srv.dbs {
+		for _, rec := range store.dict {
+			counts.add(rec)
+		}
+	}
+	counts.print(stdout)
+
+	switch {
+	case aofErr != nil:
+		fmt.Fprintf(stdout, "AOF is not valid: %v\n", aofErr)
+	case end != valid:
+		fmt.Fprintf(stdout, "AOF ends in the middle of a command or transaction at offset %d\n", valid)
+	default:
+		fmt.Fprintln(stdout, "AOF is valid")
+		return 0
+	}
+	if !fix {
+		fmt.Fprintln(stdout, "Use the --fix option to truncate it to the last valid command.")
+		return 1
+	}
+
+	// Keys counted above are the ones left after truncating, the commands
+	// from valid on never replayed or, in a transaction, never ran.
+	if err := os.Truncate(path, valid); err != nil {
+		fmt.Fprintln(stdout, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "Successfully truncated AOF to %d bytes\n", valid)
+	return 0
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	aof := serializeCommands(
+		[]string{"set", "key", "value"},
+		[]string{"rpush", "list", "a"},
+		[]string{"pexpireat", "key", "4102444800000"},
+	)
+	torn := aof + "*3\r\n$4\r\nsadd\r\n$3\r\nse"
+	unknown := aof + serializeCommands([]string{"nosuchcommand"}, []string{"set", "other", "x"})
+	var lite, rdb bytes.Buffer
+	if err := newSnapshotEncoder(&lite).encode(testDatabases()); err != nil {
+		t.Fatal(err)
+	}
+	if err := newRDBEncoder(&rdb).encode(testDatabases()); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := bytes.Clone(rdb.Bytes())
+	corrupt[len(corrupt)-20] ^= 0xff
+
+	var tests = []struct {
+		name string
+		data string
+		args []string
+		want int
+		// Expected in the output.
+		output []string
+		// Length of the file afterwards, -1 for unchanged.
+		wantLen int
+	}{
+		// the table itself
+		{"Should accept a valid AOF", aof, nil, 0, []string{"AOF is valid", "Keys: 2 (1 with an expiry)", "string: 1", "list: 1"}, -1},
+		{"Should report a torn AOF", torn, nil, 1, []string{"ok_up_to=" + strconv.Itoa(len(aof)), "--fix"}, -1},
+		{"Should truncate a torn AOF", torn, []string{"--fix"}, 0, []string{"Successfully truncated AOF to " + strconv.Itoa(len(aof))}, len(aof)},
+		{"Should report an unknown command", unknown, nil, 1, []string{"at offset " + strconv.Itoa(len(aof)), "nosuchcommand"}, -1},
+		{"Should truncate at an unknown command", unknown, []string{"--fix"}, 0, []string{"Keys: 2"}, len(aof)},
+		{"Should accept an empty AOF", "", nil, 0, []string{"AOF is valid", "Keys: 0"}, -1},
+		{"Should accept a snapshot", lite.String(), nil, 0, []string{"Snapshot is valid", "Keys: 7 (2 with an expiry)", "zset: 1"}, -1},
+		{"Should accept an RDB file", rdb.String(), nil, 0, []string{"Snapshot is valid", "hash: 1"}, -1},
+		{"Should report a corrupted snapshot", string(corrupt), nil, 1, []string{"Snapshot is not valid"}, -1},
+		{"Should refuse to fix a snapshot", string(corrupt), []string{"--fix"}, 2, []string{"can't be repaired"}, -1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file")
+			if err := os.WriteFile(path, []byte(test.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			if got := checkMain(append(test.args, path), &out); got != test.want {
+				t.Errorf("Got exit status '%d' but expected '%d': %s", got, test.want, out.String())
+			}
+			for _, want := range test.output {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("Expected '%s' in the output but got '%s'", want, out.String())
+				}
+			}
+
+			info, _ := os.Stat(path)
+			wantLen := test.wantLen
+			if wantLen == -1 {
+				wantLen = len(test.data)
+			}
+			if info.Size() != int64(wantLen) {
+				t.Errorf("Got length '%d' but expected '%d'.", info.Size(), wantLen)
+			}
+		})
+	}
+
+	var out bytes.Buffer
+	if got := checkMain(nil, &out); got != 2 || !strings.Contains(out.String(), "Usage") {
+		t.Errorf("Expected the usage but got '%d' '%s'", got, out.String())
+	}
+}
+
+// TestCheckInstalled runs the checker the way it is installed, through the
+// link "make build" creates.
+func TestCheckInstalled(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the binary")
+	}
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make isn't installed")
+	}
+	dir := t.TempDir()
+	if out, err := exec.Command("make", "build", "BINDIR="+dir).CombinedOutput(); err != nil {
+		t.Fatalf("Building failed: %v\n%s", err, out)
+	}
+
+	path := filepath.Join(dir, "appendonly.aof")
+	if err := os.WriteFile(path, []byte(serializeCommands([]string{"set", "key", "value"})), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(filepath.Join(dir, "redis-lite-check"), path).CombinedOutput()
+	if err != nil || !strings.Contains(string(out), "AOF is valid") {
+		t.Errorf("Expected the AOF to be valid but got '%v'\n%s", err, out)
+	}
+}
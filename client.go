@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var lastClientID atomic.Int64
+
+// client holds the state of a single connection.
+type client struct {
+	net.Conn
+	reader *respReader
+	// Replies are buffered and flushed once the client has no more pipelined
+	// commands, so nothing is written to the network with store.mu held.
+	// Other clients append pub/sub messages too, so out is guarded by outMu.
+	outMu sync.Mutex
+	out   bytes.Buffer
+	// Serializes flushes, so output leaves in the order it was buffered.
+	writeMu sync.Mutex
+	// The output being written by the current flush.
+	sending bytes.Buffer
+	// When out went above the soft output limit, zero while below.
+	softLimitSince time.Time
+	// Set once the client exceeded its output limit and is being dropped.
+	closing bool
+	// Wakes the pusher, which flushes messages published to the client.
+	wake          chan struct{}
+	pusherStarted bool
+	id            int64
+	// Either 2 or 3, switched by HELLO.
+	protocol int
+	name     string
+	// The database selected with SELECT.
+	db *dictionary
+	// Set between MULTI and EXEC.
+	multi *transaction
+	// Keys watched with WATCH, guarded by store.mu.
+	watching []watch
+	// Set while EXEC runs, blocking commands then time out right away.
+	denyBlocking bool
+	// Pub/sub subscriptions, guarded by store.mu.
+	channels      map[string]struct{}
+	patterns      map[string]struct{}
+	shardChannels map[string]struct{}
+	// Error replies sent so far, call doesn't log commands that failed.
+	errorReplies int
+	// Set by commands that log something else than their arguments to the
+	// append-only file, see rewriteCommand.
+	rewritten bool
+	rewrites  [][]string
+}
+
+func newClient(conn net.Conn, db *dictionary) *client {
+	return &client{
+		Conn:          conn,
+		db:            db,
+		reader:        newRespReader(conn),
+		wake:          make(chan struct{}, 1),
+		id:            lastClientID.Add(1),
+		protocol:      2,
+		channels:      map[string]struct{}{},
+		patterns:      map[string]struct{}{},
+		shardChannels: map[string]struct{}{},
+	}
+}
+
+// Write buffers a reply until the next flush.
+func (c *client) Write(p []byte) (int, error) {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	if len(p) > 0 && p[0] == '-' {
+		c.errorReplies++
+	}
+	return c.out.Write(p)
+}
+
+// flush sends all buffered output.
+func (c *client) flush() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.outMu.Lock()
+	c.out, c.sending = c.sending, c.out
+	c.softLimitSince = time.Time{}
+	c.outMu.Unlock()
+
+	if c.sending.Len() == 0 {
+		return nil
+	}
+	_, err := c.Conn.Write(c.sending.Bytes())
+	c.sending.Reset()
+	return err
+}
+
+// push queues output that doesn't answer a command of the client, like a
+// pub/sub message, and has the pusher send it. A client that doesn't keep up
+// with its output is disconnected, so publishers never wait for it.
+// Must be called with store.mu held.
+func (c *client) push(msg string, limit outputLimit) {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	if c.closing {
+		return
+	}
+
+	size := int64(c.out.Len() + len(msg))
+	overSoft := limit.soft > 0 && size > limit.soft
+	switch {
+	case !overSoft:
+		c.softLimitSince = time.Time{}
+	case c.softLimitSince.IsZero():
+		c.softLimitSince = time.Now()
+	}
+	if (limit.hard > 0 && size > limit.hard) ||
+		(overSoft && time.Since(c.softLimitSince) > limit.softDuration) {
+		log.Printf("Client %d exceeded its output buffer limit, closing the connection.", c.id)
+		c.closing = true
+		c.out.Reset()
+		c.Conn.Close()
+		return
+	}
+	c.out.WriteString(msg)
+
+	if !c.pusherStarted {
+		c.pusherStarted = true
+		go c.runPusher()
+	}
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runPusher flushes pushed output while the client is idle, waiting for its
+// next command. It stops when the client disconnects and wake is closed.
+func (c *client) runPusher() {
+	for range c.wake {
+		if err := c.flush(); err != nil {
+			return
+		}
+	}
+}
+
+// The helpers below pick the native RESP3 type when the client negotiated it
+// and the equivalent RESP2 encoding otherwise.
+
+func (c *client) serializeMap(pairs []string) string {
+	if c.protocol == 3 {
+		return serializeMap(pairs)
+	}
+	return serializeArray(pairs)
+}
+
+func (c *client) serializeSet(items []string) string {
+	if c.protocol == 3 {
+		return serializeSet(items)
+	}
+	return serializeArray(items)
+}
+
+func (c *client) serializeDouble(num float64) string {
+	if c.protocol == 3 {
+		return serializeDouble(num)
+	}
+	return serializeBulkString(formatDouble(num))
+}
+
+func (c *client) serializeBoolean(b bool) string {
+	if c.protocol == 3 {
+		return serializeBoolean(b)
+	}
+	if b {
+		msg, _, _ := serializeInteger(1)
+		return msg
+	}
+	msg, _, _ := serializeInteger(0)
+	return msg
+}
+
+// serializePush is for output that doesn't answer a command, like pub/sub
+// messages.
+func (c *client) serializePush(items []string) string {
+	if c.protocol == 3 {
+		return serializePush(items)
+	}
+	return serializeArray(items)
+}
+
+// serializeNull is the reply for a missing value.
+func (c *client) serializeNull() string {
+	if c.protocol == 3 {
+		return serializeNull()
+	}
+	msg, _, _ := serializeNullBulkString()
+	return msg
+}
+
+// serializeNullArray is the reply for a missing aggregate.
+func (c *client) serializeNullArray() string {
+	if c.protocol == 3 {
+		return serializeNull()
+	}
+	return serializeNullArray()
+}
@@ -0,0 +1,560 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+type commandHandler func(arr []interface{}, conn *client, store *dictionary)
+
+type commandFlag uint
+
+const (
+	flagWrite commandFlag = 1 << iota
+	flagReadOnly
+	flagDenyOOM
+	flagFast
+	flagBlocking
+)
+
+var commandFlagNames = []struct {
+	flag commandFlag
+	name string
+}{
+	{flagWrite, "write"},
+	{flagReadOnly, "readonly"},
+	{flagDenyOOM, "denyoom"},
+	{flagFast, "fast"},
+	{flagBlocking, "blocking"},
+}
+
+// commandSpec describes a command the same way Redis's command table does.
+// Arity counts the command name itself, a negative arity means at least
+// -arity arguments. Key positions are 0 when the command takes no keys and a
+// negative lastKey counts from the end of the arguments.
+type commandSpec struct {
+	name     string
+	arity    int
+	flags    commandFlag
+	firstKey int
+	lastKey  int
+	step     int
+	group    string
+	summary  string
+	handler  commandHandler
+}
+
+var commandTable = map[string]*commandSpec{}
+
+func registerCommand(spec *commandSpec) {
+	commandTable[spec.name] = spec
+}
+
+func init() {
+	registerCommand(&commandSpec{name: "command", arity: -1, group: "server",
+		summary: "Returns detailed information about all commands.", handler: handleCommand})
+	registerCommand(&commandSpec{name: "ping", arity: -1, flags: flagFast, group: "connection",
+		summary: "Returns the server's liveliness response.", handler: handlePing})
+	registerCommand(&commandSpec{name: "hello", arity: -1, flags: flagFast, group: "connection",
+		summary: "Handshakes with the Redis server.", handler: handleHello})
+	registerCommand(&commandSpec{name: "info", arity: -1, group: "server",
+		summary: "Returns information and statistics about the server.", handler: handleInfo})
+	registerCommand(&commandSpec{name: "echo", arity: 2, flags: flagFast, group: "connection",
+		summary: "Returns the given string.", handler: handleEcho})
+
+	registerCommand(&commandSpec{name: "set", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1,
+		group: "string", summary: "Sets the string value of a key, ignoring its type.", handler: handleSet})
+	registerCommand(&commandSpec{name: "get", arity: 2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "string", summary: "Returns the string value of a key.", handler: handleGet})
+	registerCommand(&commandSpec{name: "incr", arity: 2, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "string", summary: "Increments the integer value of a key by one.", handler: handleIncr})
+	registerCommand(&commandSpec{name: "decr", arity: 2, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "string", summary: "Decrements the integer value of a key by one.", handler: handleDecr})
+	registerCommand(&commandSpec{name: "append", arity: 3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1,
+		group: "string", summary: "Appends a string to the value of a key. Creates the key if it doesn't exist.", handler: handleAppend})
+	registerCommand(&commandSpec{name: "strlen", arity: 2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "string", summary: "Returns the length of a string value.", handler: handleStrLen})
+	registerCommand(&commandSpec{name: "getrange", arity: 4, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1,
+		group: "string", summary: "Returns a substring of the string stored at a key.", handler: handleGetRange})
+	registerCommand(&commandSpec{name: "substr", arity: 4, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1,
+		group: "string", summary: "Returns a substring from a string value.", handler: handleGetRange})
+	registerCommand(&commandSpec{name: "setrange", arity: 4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1,
+		group: "string", summary: "Overwrites a part of a string value with another by an offset. Creates the key if it doesn't exist.", handler: handleSetRange})
+	registerCommand(&commandSpec{name: "mget", arity: -2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: -1, step: 1,
+		group: "string", summary: "Atomically returns the string values of one or more keys.", handler: handleMGet})
+	registerCommand(&commandSpec{name: "mset", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: -1, step: 2,
+		group: "string", summary: "Atomically creates or modifies the string values of one or more keys.", handler: handleMSet})
+	registerCommand(&commandSpec{name: "msetnx", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: -1, step: 2,
+		group: "string", summary: "Atomically modifies the string values of one or more keys only when all keys don't exist.", handler: handleMSetNX})
+	registerCommand(&commandSpec{name: "incrby", arity: 3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "string", summary: "Increments the integer value of a key by a number. Uses 0 as initial value if the key doesn't exist.", handler: handleIncrBy})
+	registerCommand(&commandSpec{name: "decrby", arity: 3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "string", summary: "Decrements a number from the integer value of a key. Uses 0 as initial value if the key doesn't exist.", handler: handleDecrBy})
+	registerCommand(&commandSpec{name: "incrbyfloat", arity: 3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "string", summary: "Increment the floating point value of a key by a number. Uses 0 as initial value if the key doesn't exist.", handler: handleIncrByFloat})
+	registerCommand(&commandSpec{name: "getset", arity: 3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "string", summary: "Returns the previous string value of a key after setting it to a new value.", handler: handleGetSet})
+	registerCommand(&commandSpec{name: "getdel", arity: 2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "string", summary: "Returns the string value of a key after deleting the key.", handler: handleGetDel})
+	registerCommand(&commandSpec{name: "getex", arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "string", summary: "Returns the string value of a key after setting its expiration time.", handler: handleGetEx})
+	registerCommand(&commandSpec{name: "setnx", arity: 3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "string", summary: "Set the string value of a key only when the key doesn't exist.", handler: handleSetNX})
+	registerCommand(&commandSpec{name: "setex", arity: 4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1,
+		group: "string", summary: "Sets the string value and expiration time of a key. Creates the key if it doesn't exist.", handler: handleSetEx})
+	registerCommand(&commandSpec{name: "psetex", arity: 4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1,
+		group: "string", summary: "Sets both string value and expiration time in milliseconds of a key. The key is created if it doesn't exist.", handler: handlePSetEx})
+	registerCommand(&commandSpec{name: "lcs", arity: -3, flags: flagReadOnly, firstKey: 1, lastKey: 2, step: 1,
+		group: "string", summary: "Finds the longest common substring.", handler: handleLCS})
+
+	registerCommand(&commandSpec{name: "exists", arity: -2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: -1, step: 1,
+		group: "generic", summary: "Determines whether one or more keys exist.", handler: handleExists})
+	registerCommand(&commandSpec{name: "del", arity: -2, flags: flagWrite, firstKey: 1, lastKey: -1, step: 1,
+		group: "generic", summary: "Deletes one or more keys.", handler: handleDel})
+	registerCommand(&commandSpec{name: "expire", arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "generic", summary: "Sets the expiration time of a key in seconds.", handler: handleExpire})
+	registerCommand(&commandSpec{name: "pexpire", arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "generic", summary: "Sets the expiration time of a key in milliseconds.", handler: handlePExpire})
+	registerCommand(&commandSpec{name: "expireat", arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "generic", summary: "Sets the expiration time of a key to a Unix timestamp.", handler: handleExpireAt})
+	registerCommand(&commandSpec{name: "pexpireat", arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "generic", summary: "Sets the expiration time of a key to a Unix milliseconds timestamp.", handler: handlePExpireAt})
+	registerCommand(&commandSpec{name: "ttl", arity: 2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "generic", summary: "Returns the expiration time in seconds of a key.", handler: handleTTL})
+	registerCommand(&commandSpec{name: "pttl", arity: 2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "generic", summary: "Returns the expiration time in milliseconds of a key.", handler: handlePTTL})
+	registerCommand(&commandSpec{name: "expiretime", arity: 2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "generic", summary: "Returns the expiration time of a key as a Unix timestamp.", handler: handleExpireTime})
+	registerCommand(&commandSpec{name: "pexpiretime", arity: 2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "generic", summary: "Returns the expiration time of a key as a Unix milliseconds timestamp.", handler: handlePExpireTime})
+	registerCommand(&commandSpec{name: "persist", arity: 2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "generic", summary: "Removes the expiration time of a key.", handler: handlePersist})
+	registerCommand(&commandSpec{name: "keys", arity: 2, flags: flagReadOnly,
+		group: "generic", summary: "Returns all key names that match a pattern.", handler: handleKeys})
+	registerCommand(&commandSpec{name: "scan", arity: -2, flags: flagReadOnly,
+		group: "generic", summary: "Iterates over the key names in the database.", handler: handleScan})
+	registerCommand(&commandSpec{name: "type", arity: 2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "generic", summary: "Determines the type of value stored at a key.", handler: handleType})
+	registerCommand(&commandSpec{name: "dbsize", arity: 1, flags: flagReadOnly | flagFast,
+		group: "server", summary: "Returns the number of keys in the database.", handler: handleDBSize})
+	registerCommand(&commandSpec{name: "randomkey", arity: 1, flags: flagReadOnly,
+		group: "generic", summary: "Returns a random key name from the database.", handler: handleRandomKey})
+	registerCommand(&commandSpec{name: "rename", arity: 3, flags: flagWrite, firstKey: 1, lastKey: 2, step: 1,
+		group: "generic", summary: "Renames a key and overwrites the destination.", handler: handleRename})
+	registerCommand(&commandSpec{name: "renamenx", arity: 3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 2, step: 1,
+		group: "generic", summary: "Renames a key only when the target key name doesn't exist.", handler: handleRenameNX})
+	registerCommand(&commandSpec{name: "copy", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 2, step: 1,
+		group: "generic", summary: "Copies the value of a key to a new key.", handler: handleCopy})
+	registerCommand(&commandSpec{name: "unlink", arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: -1, step: 1,
+		group: "generic", summary: "Asynchronously deletes one or more keys.", handler: handleUnlink})
+	registerCommand(&commandSpec{name: "touch", arity: -2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: -1, step: 1,
+		group: "generic", summary: "Returns the number of existing keys out of those specified after updating the time they were last accessed.", handler: handleTouch})
+	registerCommand(&commandSpec{name: "move", arity: 3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "generic", summary: "Moves a key to another database.", handler: handleMove})
+	registerCommand(&commandSpec{name: "select", arity: 2, flags: flagFast, group: "connection",
+		summary: "Changes the selected database.", handler: handleSelect})
+	registerCommand(&commandSpec{name: "swapdb", arity: 3, flags: flagWrite | flagFast, group: "server",
+		summary: "Swaps two Redis databases.", handler: handleSwapDB})
+	registerCommand(&commandSpec{name: "flushdb", arity: -1, flags: flagWrite, group: "server",
+		summary: "Removes all keys from the current database.", handler: handleFlushDB})
+	registerCommand(&commandSpec{name: "flushall", arity: -1, flags: flagWrite, group: "server",
+		summary: "Removes all keys from all databases.", handler: handleFlushAll})
+
+	registerCommand(&commandSpec{name: "lpush", arity: -3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "list", summary: "Prepends one or more elements to a list.", handler: handleLPush})
+	registerCommand(&commandSpec{name: "rpush", arity: -3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "list", summary: "Appends one or more elements to a list.", handler: handleRPush})
+	registerCommand(&commandSpec{name: "lpushx", arity: -3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "list", summary: "Prepends one or more elements to a list only when the list exists.", handler: handleLPushX})
+	registerCommand(&commandSpec{name: "rpushx", arity: -3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "list", summary: "Appends one or more elements to a list only when the list exists.", handler: handleRPushX})
+	registerCommand(&commandSpec{name: "lpop", arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "list", summary: "Returns the first elements in a list after removing it.", handler: handleLPop})
+	registerCommand(&commandSpec{name: "rpop", arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "list", summary: "Returns and removes the last elements of a list.", handler: handleRPop})
+	registerCommand(&commandSpec{name: "llen", arity: 2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "list", summary: "Returns the length of a list.", handler: handleLLen})
+	registerCommand(&commandSpec{name: "lrange", arity: 4, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1,
+		group: "list", summary: "Returns a range of elements from a list.", handler: handleLRange})
+	registerCommand(&commandSpec{name: "lindex", arity: 3, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1,
+		group: "list", summary: "Returns an element from a list by its index.", handler: handleLIndex})
+	registerCommand(&commandSpec{name: "lset", arity: 4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1,
+		group: "list", summary: "Sets the value of an element in a list by its index.", handler: handleLSet})
+	registerCommand(&commandSpec{name: "linsert", arity: 5, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1,
+		group: "list", summary: "Inserts an element before or after another element in a list.", handler: handleLInsert})
+	registerCommand(&commandSpec{name: "lrem", arity: 4, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+		group: "list", summary: "Removes elements from a list.", handler: handleLRem})
+	registerCommand(&commandSpec{name: "ltrim", arity: 4, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+		group: "list", summary: "Removes elements from both ends a list.", handler: handleLTrim})
+	registerCommand(&commandSpec{name: "lpos", arity: -3, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1,
+		group: "list", summary: "Returns the index of matching elements in a list.", handler: handleLPos})
+	registerCommand(&commandSpec{name: "lmove", arity: 5, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 2, step: 1,
+		group: "list", summary: "Returns an element after popping it from one list and pushing it to another.", handler: handleLMove})
+	registerCommand(&commandSpec{name: "lmpop", arity: -4, flags: flagWrite, firstKey: 0, lastKey: 0, step: 0,
+		group: "list", summary: "Returns multiple elements from a list after removing them.", handler: handleLMPop})
+	registerCommand(&commandSpec{name: "blpop", arity: -3, flags: flagWrite | flagBlocking, firstKey: 1, lastKey: -2, step: 1,
+		group: "list", summary: "Removes and returns the first element in a list. Blocks until an element is available otherwise.", handler: handleBLPop})
+	registerCommand(&commandSpec{name: "brpop", arity: -3, flags: flagWrite | flagBlocking, firstKey: 1, lastKey: -2, step: 1,
+		group: "list", summary: "Removes and returns the last element in a list. Blocks until an element is available otherwise.", handler: handleBRPop})
+	registerCommand(&commandSpec{name: "blmove", arity: 6, flags: flagWrite | flagDenyOOM | flagBlocking, firstKey: 1, lastKey: 2, step: 1,
+		group: "list", summary: "Pops an element from a list, pushes it to another list and returns it. Blocks until an element is available otherwise.", handler: handleBLMove})
+	registerCommand(&commandSpec{name: "brpoplpush", arity: 4, flags: flagWrite | flagDenyOOM | flagBlocking, firstKey: 1, lastKey: 2, step: 1,
+		group: "list", summary: "Pops an element from a list, pushes it to another list and returns it. Block until an element is available otherwise.", handler: handleBRPopLPush})
+	registerCommand(&commandSpec{name: "blmpop", arity: -5, flags: flagWrite | flagBlocking, firstKey: 0, lastKey: 0, step: 0,
+		group: "list", summary: "Pops the first element from one of multiple lists. Blocks until an element is available otherwise.", handler: handleBLMPop})
+	registerCommand(&commandSpec{name: "rpoplpush", arity: 3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 2, step: 1,
+		group: "list", summary: "Returns the last element of a list after removing and pushing it to another list.", handler: handleRPopLPush})
+
+	registerCommand(&commandSpec{name: "hset", arity: -4, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "hash", summary: "Creates or modifies the value of a field in a hash.", handler: handleHSet})
+	registerCommand(&commandSpec{name: "hmset", arity: -4, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "hash", summary: "Sets the values of multiple fields.", handler: handleHSet})
+	registerCommand(&commandSpec{name: "hsetnx", arity: 4, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "hash", summary: "Sets the value of a field in a hash only when the field doesn't exist.", handler: handleHSetNX})
+	registerCommand(&commandSpec{name: "hget", arity: 3, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "hash", summary: "Returns the value of a field in a hash.", handler: handleHGet})
+	registerCommand(&commandSpec{name: "hmget", arity: -3, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "hash", summary: "Returns the values of all fields in a hash.", handler: handleHMGet})
+	registerCommand(&commandSpec{name: "hdel", arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "hash", summary: "Deletes one or more fields and their values from a hash. Deletes the hash if no fields remain.", handler: handleHDel})
+	registerCommand(&commandSpec{name: "hexists", arity: 3, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "hash", summary: "Determines whether a field exists in a hash.", handler: handleHExists})
+	registerCommand(&commandSpec{name: "hlen", arity: 2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "hash", summary: "Returns the number of fields in a hash.", handler: handleHLen})
+	registerCommand(&commandSpec{name: "hstrlen", arity: 3, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "hash", summary: "Returns the length of the value of a field.", handler: handleHStrLen})
+	registerCommand(&commandSpec{name: "hkeys", arity: 2, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1,
+		group: "hash", summary: "Returns all fields in a hash.", handler: handleHKeys})
+	registerCommand(&commandSpec{name: "hvals", arity: 2, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1,
+		group: "hash", summary: "Returns all values in a hash.", handler: handleHVals})
+	registerCommand(&commandSpec{name: "hgetall", arity: 2, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1,
+		group: "hash", summary: "Returns all fields and values in a hash.", handler: handleHGetAll})
+	registerCommand(&commandSpec{name: "hincrby", arity: 4, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "hash", summary: "Increments the integer value of a field in a hash by a number. Uses 0 as initial value if the field doesn't exist.", handler: handleHIncrBy})
+	registerCommand(&commandSpec{name: "hincrbyfloat", arity: 4, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "hash", summary: "Increments the floating point value of a field by a number. Uses 0 as initial value if the field doesn't exist.", handler: handleHIncrByFloat})
+	registerCommand(&commandSpec{name: "hrandfield", arity: -2, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1,
+		group: "hash", summary: "Returns one or more random fields from a hash.", handler: handleHRandField})
+	registerCommand(&commandSpec{name: "hscan", arity: -3, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1,
+		group: "hash", summary: "Iterates over fields and values of a hash.", handler: handleHScan})
+
+	registerCommand(&commandSpec{name: "sadd", arity: -3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "set", summary: "Adds one or more members to a set. Creates the key if it doesn't exist.", handler: handleSAdd})
+	registerCommand(&commandSpec{name: "srem", arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "set", summary: "Removes one or more members from a set. Deletes the set if the last member was removed.", handler: handleSRem})
+	registerCommand(&commandSpec{name: "sismember", arity: 3, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "set", summary: "Determines whether a member belongs to a set.", handler: handleSIsMember})
+	registerCommand(&commandSpec{name: "smismember", arity: -3, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "set", summary: "Determines whether multiple members belong to a set.", handler: handleSMIsMember})
+	registerCommand(&commandSpec{name: "smembers", arity: 2, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1,
+		group: "set", summary: "Returns all members of a set.", handler: handleSMembers})
+	registerCommand(&commandSpec{name: "scard", arity: 2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "set", summary: "Returns the number of members in a set.", handler: handleSCard})
+	registerCommand(&commandSpec{name: "spop", arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "set", summary: "Returns one or more random members from a set after removing them. Deletes the set if the last member was popped.", handler: handleSPop})
+	registerCommand(&commandSpec{name: "srandmember", arity: -2, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1,
+		group: "set", summary: "Get one or multiple random members from a set", handler: handleSRandMember})
+	registerCommand(&commandSpec{name: "smove", arity: 4, flags: flagWrite | flagFast, firstKey: 1, lastKey: 2, step: 1,
+		group: "set", summary: "Moves a member from one set to another.", handler: handleSMove})
+	registerCommand(&commandSpec{name: "sinter", arity: -2, flags: flagReadOnly, firstKey: 1, lastKey: -1, step: 1,
+		group: "set", summary: "Returns the intersect of multiple sets.", handler: handleSInter})
+	registerCommand(&commandSpec{name: "sunion", arity: -2, flags: flagReadOnly, firstKey: 1, lastKey: -1, step: 1,
+		group: "set", summary: "Returns the union of multiple sets.", handler: handleSUnion})
+	registerCommand(&commandSpec{name: "sdiff", arity: -2, flags: flagReadOnly, firstKey: 1, lastKey: -1, step: 1,
+		group: "set", summary: "Returns the difference of multiple sets.", handler: handleSDiff})
+	registerCommand(&commandSpec{name: "sinterstore", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: -1, step: 1,
+		group: "set", summary: "Stores the intersect of multiple sets in a key.", handler: handleSInterStore})
+	registerCommand(&commandSpec{name: "sunionstore", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: -1, step: 1,
+		group: "set", summary: "Stores the union of multiple sets in a key.", handler: handleSUnionStore})
+	registerCommand(&commandSpec{name: "sdiffstore", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: -1, step: 1,
+		group: "set", summary: "Stores the difference of multiple sets in a key.", handler: handleSDiffStore})
+	registerCommand(&commandSpec{name: "sintercard", arity: -3, flags: flagReadOnly, firstKey: 0, lastKey: 0, step: 0,
+		group: "set", summary: "Returns the number of members of the intersect of multiple sets.", handler: handleSInterCard})
+	registerCommand(&commandSpec{name: "sscan", arity: -3, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1,
+		group: "set", summary: "Iterates over members of a set.", handler: handleSScan})
+
+	registerCommand(&commandSpec{name: "zadd", arity: -4, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Adds one or more members to a sorted set, or updates their scores. Creates the key if it doesn't exist.", handler: handleZAdd})
+	registerCommand(&commandSpec{name: "zincrby", arity: 4, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Increments the score of a member in a sorted set.", handler: handleZIncrBy})
+	registerCommand(&commandSpec{name: "zrem", arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Removes one or more members from a sorted set. Deletes the sorted set if all members were removed.", handler: handleZRem})
+	registerCommand(&commandSpec{name: "zscore", arity: 3, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Returns the score of a member in a sorted set.", handler: handleZScore})
+	registerCommand(&commandSpec{name: "zmscore", arity: -3, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Returns the score of one or more members in a sorted set.", handler: handleZMScore})
+	registerCommand(&commandSpec{name: "zcard", arity: 2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Returns the number of members in a sorted set.", handler: handleZCard})
+	registerCommand(&commandSpec{name: "zcount", arity: 4, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Returns the count of members in a sorted set that have scores within a range.", handler: handleZCount})
+	registerCommand(&commandSpec{name: "zrank", arity: -3, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Returns the index of a member in a sorted set ordered by ascending scores.", handler: handleZRank})
+	registerCommand(&commandSpec{name: "zrevrank", arity: -3, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Returns the index of a member in a sorted set ordered by descending scores.", handler: handleZRevRank})
+	registerCommand(&commandSpec{name: "zrange", arity: -4, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Returns members in a sorted set within a range of indexes.", handler: handleZRange})
+	registerCommand(&commandSpec{name: "zrangestore", arity: -5, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 2, step: 1,
+		group: "sorted-set", summary: "Stores a range of members from sorted set in a key.", handler: handleZRangeStore})
+	registerCommand(&commandSpec{name: "zrevrange", arity: -4, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Returns members in a sorted set within a range of indexes in reverse order.", handler: handleZRevRange})
+	registerCommand(&commandSpec{name: "zrangebyscore", arity: -4, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Returns members in a sorted set within a range of scores.", handler: handleZRangeByScore})
+	registerCommand(&commandSpec{name: "zrevrangebyscore", arity: -4, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Returns members in a sorted set within a range of scores in reverse order.", handler: handleZRevRangeByScore})
+	registerCommand(&commandSpec{name: "zrangebylex", arity: -4, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Returns members in a sorted set within a lexicographical range.", handler: handleZRangeByLex})
+	registerCommand(&commandSpec{name: "zrevrangebylex", arity: -4, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Returns members in a sorted set within a lexicographical range in reverse order.", handler: handleZRevRangeByLex})
+	registerCommand(&commandSpec{name: "zpopmin", arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Returns the lowest-scoring members from a sorted set after removing them. Deletes the sorted set if the last member was popped.", handler: handleZPopMin})
+	registerCommand(&commandSpec{name: "zpopmax", arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Returns the highest-scoring members from a sorted set after removing them. Deletes the sorted set if the last member was popped.", handler: handleZPopMax})
+	registerCommand(&commandSpec{name: "zremrangebyrank", arity: 4, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Removes members in a sorted set within a range of indexes. Deletes the sorted set if all members were removed.", handler: handleZRemRangeByRank})
+	registerCommand(&commandSpec{name: "zremrangebyscore", arity: 4, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Removes members in a sorted set within a range of scores. Deletes the sorted set if all members were removed.", handler: handleZRemRangeByScore})
+	registerCommand(&commandSpec{name: "zremrangebylex", arity: 4, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Removes members in a sorted set within a lexicographical range. Deletes the sorted set if all members were removed.", handler: handleZRemRangeByLex})
+	registerCommand(&commandSpec{name: "zunionstore", arity: -4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Stores the union of multiple sorted sets in a key.", handler: handleZUnionStore})
+	registerCommand(&commandSpec{name: "zinterstore", arity: -4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Stores the intersect of multiple sorted sets in a key.", handler: handleZInterStore})
+	registerCommand(&commandSpec{name: "zscan", arity: -3, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1,
+		group: "sorted-set", summary: "Iterates over members and scores of a sorted set.", handler: handleZScan})
+
+	registerCommand(&commandSpec{name: "multi", arity: 1, flags: flagFast, group: "transactions",
+		summary: "Starts a transaction.", handler: handleMulti})
+	registerCommand(&commandSpec{name: "exec", arity: 1, group: "transactions",
+		summary: "Executes all commands in a transaction.", handler: handleExec})
+	registerCommand(&commandSpec{name: "discard", arity: 1, flags: flagFast, group: "transactions",
+		summary: "Discards a transaction.", handler: handleDiscard})
+	registerCommand(&commandSpec{name: "watch", arity: -2, flags: flagFast, firstKey: 1, lastKey: -1, step: 1,
+		group: "transactions", summary: "Monitors changes to keys to determine the execution of a transaction.", handler: handleWatch})
+	registerCommand(&commandSpec{name: "unwatch", arity: 1, flags: flagFast, group: "transactions",
+		summary: "Forgets about watched keys of a transaction.", handler: handleUnwatch})
+
+	registerCommand(&commandSpec{name: "save", arity: 1, group: "server",
+		summary: "Synchronously saves the database(s) to disk.", handler: handleSave})
+	registerCommand(&commandSpec{name: "bgsave", arity: -1, group: "server",
+		summary: "Asynchronously saves the database(s) to disk.", handler: handleBgsave})
+	registerCommand(&commandSpec{name: "lastsave", arity: 1, flags: flagFast, group: "server",
+		summary: "Returns the Unix timestamp of the last successful save to disk.", handler: handleLastSave})
+	registerCommand(&commandSpec{name: "bgrewriteaof", arity: 1, group: "server",
+		summary: "Asynchronously rewrites the append-only file to disk.", handler: handleBgrewriteaof})
+
+	registerCommand(&commandSpec{name: "subscribe", arity: -2, group: "pubsub",
+		summary: "Listens for messages published to channels.", handler: handleSubscribe})
+	registerCommand(&commandSpec{name: "unsubscribe", arity: -1, group: "pubsub",
+		summary: "Stops listening to messages posted to channels.", handler: handleUnsubscribe})
+	registerCommand(&commandSpec{name: "psubscribe", arity: -2, group: "pubsub",
+		summary: "Listens for messages published to channels that match one or more patterns.", handler: handlePSubscribe})
+	registerCommand(&commandSpec{name: "punsubscribe", arity: -1, group: "pubsub",
+		summary: "Stops listening to messages published to channels that match one or more patterns.", handler: handlePUnsubscribe})
+	registerCommand(&commandSpec{name: "ssubscribe", arity: -2, firstKey: 1, lastKey: -1, step: 1,
+		group: "pubsub", summary: "Listens for messages published to shard channels.", handler: handleSSubscribe})
+	registerCommand(&commandSpec{name: "sunsubscribe", arity: -1, firstKey: 1, lastKey: -1, step: 1,
+		group: "pubsub", summary: "Stops listening to messages posted to shard channels.", handler: handleSUnsubscribe})
+	registerCommand(&commandSpec{name: "publish", arity: 3, flags: flagFast, group: "pubsub",
+		summary: "Posts a message to a channel.", handler: handlePublish})
+	registerCommand(&commandSpec{name: "spublish", arity: 3, flags: flagFast, firstKey: 1, lastKey: 1, step: 1,
+		group: "pubsub", summary: "Post a message to a shard channel", handler: handleSPublish})
+	registerCommand(&commandSpec{name: "pubsub", arity: -2, group: "pubsub",
+		summary: "A container for Pub/Sub commands.", handler: handlePubsub})
+}
+
+// dispatchCommand looks up the command in the command table, validates its
+// arity and runs its handler on the database the client selected.
+func dispatchCommand(arr []interface{}, conn *client) {
+	name := strings.ToLower(arr[0].(string))
+	spec, ok := commandTable[name]
+	if !ok {
+		var args strings.Builder
+		for _, arg := range arr[1:] {
+			fmt.Fprintf(&args, "'%.128s' ", arg)
+		}
+		conn.flagTransaction()
+		sendErrorToClient(conn, fmt.Sprintf("ERR unknown command '%.128s', with args beginning with: %s", arr[0], args.String()))
+		return
+	}
+
+	if (spec.arity > 0 && len(arr) != spec.arity) || len(arr) < -spec.arity {
+		conn.flagTransaction()
+		sendArityError(conn, spec.name)
+		return
+	}
+
+	if conn.protocol == 2 && conn.subscribed() && !subscribedCommands[spec.name] {
+		sendErrorToClient(conn, fmt.Sprintf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", spec.name))
+		return
+	}
+
+	// Commands run one at a time, which makes each of them atomic.
+	store := conn.db
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	// Make room before every command. Commands that add data are refused
+	// while there is no room, even queued ones, which fails the transaction.
+	if !performEvictions(store.srv) && deniedByOOM(spec, conn) {
+		conn.flagTransaction()
+		sendErrorToClient(conn, errOOM)
+		return
+	}
+
+	if conn.multi != nil && !multiImmediateCommands[spec.name] {
+		conn.multi.queued = append(conn.multi.queued, queuedCommand{spec: spec, arr: arr})
+		msg, _ := serializeSimpleString("QUEUED")
+		sendMsgToClient(conn, msg)
+		return
+	}
+
+	call(spec, arr, conn)
+	// Before the reply goes out, for the always fsync policy.
+	writeAppendOnlyFile(store.srv)
+}
+
+// call runs a command on the selected database and logs it to the
+// append-only file when it succeeded.
+// Must be called with store.mu held.
+func call(spec *commandSpec, arr []interface{}, conn *client) {
+	store := conn.db
+	errorReplies := conn.errorReplies
+	conn.rewritten = false
+	spec.handler(arr, conn, store)
+	if spec.flags&flagWrite != 0 {
+		// Hashes, sets and sorted sets change in place.
+		for _, key := range spec.keys(arr) {
+			updateRecordSize(store, key)
+		}
+		if conn.errorReplies == errorReplies {
+			logCommand(store, conn, spec, arr)
+		}
+	}
+}
+
+// keys returns the key arguments of a command according to its spec. Commands
+// whose keys don't sit at fixed positions, like LMPOP, have none.
+func (spec *commandSpec) keys(arr []interface{}) []string {
+	if spec.firstKey == 0 {
+		return nil
+	}
+	last := spec.lastKey
+	if last < 0 {
+		last += len(arr)
+	}
+	var keys []string
+	for i := spec.firstKey; i <= last && i < len(arr); i += spec.step {
+		keys = append(keys, arr[i].(string))
+	}
+	return keys
+}
+
+func sendArityError(conn net.Conn, name string) {
+	sendErrorToClient(conn, fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+}
+
+func handleCommand(arr []interface{}, conn *client, store *dictionary) {
+	if len(arr) == 1 {
+		sendMsgToClient(conn, serializeCommandInfos(conn, sortedCommandNames()))
+		return
+	}
+
+	switch sub := strings.ToLower(arr[1].(string)); sub {
+	case "count":
+		if len(arr) != 2 {
+			sendArityError(conn, "command|count")
+			return
+		}
+		msg, _, _ := serializeInteger(int64(len(commandTable)))
+		sendMsgToClient(conn, msg)
+	case "info":
+		names := sortedCommandNames()
+		if len(arr) > 2 {
+			names = names[:0]
+			for _, name := range arr[2:] {
+				names = append(names, strings.ToLower(name.(string)))
+			}
+		}
+		sendMsgToClient(conn, serializeCommandInfos(conn, names))
+	case "docs":
+		names := sortedCommandNames()
+		if len(arr) > 2 {
+			names = names[:0]
+			for _, name := range arr[2:] {
+				names = append(names, strings.ToLower(name.(string)))
+			}
+		}
+		sendMsgToClient(conn, serializeCommandDocs(conn, names))
+	default:
+		sendErrorToClient(conn, fmt.Sprintf("ERR unknown subcommand '%.128s'. Try COMMAND HELP.", arr[1]))
+	}
+}
+
+func sortedCommandNames() []string {
+	names := make([]string, 0, len(commandTable))
+	for name := range commandTable {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// serializeCommandInfos replies in the Redis 7 COMMAND INFO layout, unknown
+// commands get a null entry.
+func serializeCommandInfos(conn *client, names []string) string {
+	items := make([]string, len(names))
+	for i, name := range names {
+		spec, ok := commandTable[name]
+		if !ok {
+			items[i] = conn.serializeNullArray()
+			continue
+		}
+
+		var flags []string
+		for _, f := range commandFlagNames {
+			if spec.flags&f.flag != 0 {
+				flag, _ := serializeSimpleString(f.name)
+				flags = append(flags, flag)
+			}
+		}
+		arity, _, _ := serializeInteger(int64(spec.arity))
+		firstKey, _, _ := serializeInteger(int64(spec.firstKey))
+		lastKey, _, _ := serializeInteger(int64(spec.lastKey))
+		step, _, _ := serializeInteger(int64(spec.step))
+		category, _ := serializeSimpleString("@" + spec.group)
+
+		items[i] = serializeArray([]string{
+			serializeBulkString(spec.name),
+			arity,
+			serializeArray(flags),
+			firstKey,
+			lastKey,
+			step,
+			serializeArray([]string{category}),
+			serializeArray(nil), // tips
+			serializeArray(nil), // key specs
+			serializeArray(nil), // subcommands
+		})
+	}
+	return serializeArray(items)
+}
+
+// serializeCommandDocs replies with a name to doc map, unknown commands are
+// left out like Redis does.
+func serializeCommandDocs(conn *client, names []string) string {
+	var items []string
+	for _, name := range names {
+		spec, ok := commandTable[name]
+		if !ok {
+			continue
+		}
+		doc := conn.serializeMap([]string{
+			serializeBulkString("summary"), serializeBulkString(spec.summary),
+			serializeBulkString("group"), serializeBulkString(spec.group),
+		})
+		items = append(items, serializeBulkString(spec.name), doc)
+	}
+	return conn.serializeMap(items)
+}
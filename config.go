@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// config holds the server settings, given on the command line in the
+// "--name value" form redis-server accepts.
+type config struct {
+	databases int
+	// Output buffer limit of pub/sub clients.
+	pubsubOutputLimit outputLimit
+	// Snapshots are written to dbfilename in dir.
+	dir        string
+	dbfilename string
+	// A snapshot is taken when any rule is met, none disables them.
+	saveRules      []saveRule
+	snapshotFormat snapshotFormat
+	// The append-only file, appendFilename in dir, logs every write.
+	appendOnly     bool
+	appendFilename string
+	appendFsync    fsyncPolicy
+	// Once the estimated size of the data exceeds maxmemory, keys are
+	// evicted by policy, judged on samples of maxmemorySamples keys. Zero
+	// means no limit.
+	maxmemory        int64
+	maxmemoryPolicy  evictionPolicy
+	maxmemorySamples int
+}
+
+// snapshotFormat is the file format snapshots are written in. Either one is
+// read at startup.
+type snapshotFormat int
+
+const (
+	// Our own format, see snapshot.go.
+	snapshotFormatLite snapshotFormat = iota
+	// The RDB format of Redis, to move data to and from it. The default, so
+	// dump.rdb is a file Redis and its tools can read.
+	snapshotFormatRDB
+)
+
+var snapshotFormatNames = map[string]snapshotFormat{
+	"redis-lite": snapshotFormatLite,
+	"rdb":        snapshotFormatRDB,
+}
+
+// fsyncPolicy says how often the append-only file is flushed to disk.
+type fsyncPolicy int
+
+const (
+	// After every write, before the client gets its reply.
+	fsyncAlways fsyncPolicy = iota
+	// Once a second, so at most a second of writes is lost.
+	fsyncEverySec
+	// Whenever the operating system decides to.
+	fsyncNo
+)
+
+var fsyncPolicyNames = map[string]fsyncPolicy{
+	"always":   fsyncAlways,
+	"everysec": fsyncEverySec,
+	"no":       fsyncNo,
+}
+
+// evictionPolicy says which keys make room when maxmemory is reached.
+type evictionPolicy int
+
+const (
+	// Nothing is evicted, commands that add data fail instead.
+	evictNoEviction evictionPolicy = iota
+	// The least recently used keys, of all or only of the volatile ones.
+	evictAllKeysLRU
+	evictVolatileLRU
+	// The least frequently used keys.
+	evictAllKeysLFU
+	evictVolatileLFU
+	// Any key.
+	evictAllKeysRandom
+	evictVolatileRandom
+	// The volatile keys expiring first.
+	evictVolatileTTL
+)
+
+var evictionPolicyNames = map[string]evictionPolicy{
+	"noeviction":      evictNoEviction,
+	"allkeys-lru":     evictAllKeysLRU,
+	"volatile-lru":    evictVolatileLRU,
+	"allkeys-lfu":     evictAllKeysLFU,
+	"volatile-lfu":    evictVolatileLFU,
+	"allkeys-random":  evictAllKeysRandom,
+	"volatile-random": evictVolatileRandom,
+	"volatile-ttl":    evictVolatileTTL,
+}
+
+// saveRule asks for a snapshot once seconds passed since the last one, if
+// there were at least changes writes in the meantime.
+type saveRule struct {
+	seconds int64
+	changes int64
+}
+
+// outputLimit disconnects clients whose pending output exceeds hard, or stays
+// above soft for longer than softDuration. Zero disables a limit.
+type outputLimit struct {
+	hard         int64
+	soft         int64
+	softDuration time.Duration
+}
+
+func defaultConfig() config {
+	return config{
+		databases:         16,
+		pubsubOutputLimit: outputLimit{hard: 32 << 20, soft: 8 << 20, softDuration: 60 * time.Second},
+		dir:               ".",
+		dbfilename:        "dump.rdb",
+		saveRules:         []saveRule{{3600, 1}, {300, 100}, {60, 10000}},
+		snapshotFormat:    snapshotFormatRDB,
+		appendFilename:    "appendonly.aof",
+		appendFsync:       fsyncEverySec,
+		maxmemorySamples:  5,
+	}
+}
+
+func parseConfig(args []string) (config, error) {
+	cfg := defaultConfig()
+	for i := 0; i < len(args); i += 2 {
+		name, ok := strings.CutPrefix(args[i], "--")
+		if !ok || i+1 == len(args) {
+			return cfg, fmt.Errorf("bad option or missing value: '%s'", args[i])
+		}
+		value := args[i+1]
+
+		switch strings.ToLower(name) {
+		case "databases":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return cfg, fmt.Errorf("invalid number of databases: '%s'", value)
+			}
+			cfg.databases = n
+		case "client-output-buffer-limit":
+			limit, err := parseOutputLimit(value)
+			if err != nil {
+				return cfg, err
+			}
+			cfg.pubsubOutputLimit = limit
+		case "dir":
+			cfg.dir = value
+		case "dbfilename":
+			cfg.dbfilename = value
+		case "save":
+			rules, err := parseSaveRules(value)
+			if err != nil {
+				return cfg, err
+			}
+			cfg.saveRules = rules
+		case "snapshot-format":
+			format, ok := snapshotFormatNames[strings.ToLower(value)]
+			if !ok {
+				return cfg, fmt.Errorf("invalid snapshot format: '%s'", value)
+			}
+			cfg.snapshotFormat = format
+		case "appendonly":
+			switch strings.ToLower(value) {
+			case "yes":
+				cfg.appendOnly = true
+			case "no":
+				cfg.appendOnly = false
+			default:
+				return cfg, fmt.Errorf("argument must be 'yes' or 'no': '%s'", value)
+			}
+		case "appendfilename":
+			cfg.appendFilename = value
+		case "appendfsync":
+			policy, ok := fsyncPolicyNames[strings.ToLower(value)]
+			if !ok {
+				return cfg, fmt.Errorf("invalid fsync policy: '%s'", value)
+			}
+			cfg.appendFsync = policy
+		case "maxmemory":
+			limit, err := parseMemory(value)
+			if err != nil {
+				return cfg, err
+			}
+			cfg.maxmemory = limit
+		case "maxmemory-policy":
+			policy, ok := evictionPolicyNames[strings.ToLower(value)]
+			if !ok {
+				return cfg, fmt.Errorf("invalid maxmemory policy: '%s'", value)
+			}
+			cfg.maxmemoryPolicy = policy
+		case "maxmemory-samples":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > 64 {
+				return cfg, fmt.Errorf("invalid maxmemory samples: '%s'", value)
+			}
+			cfg.maxmemorySamples = n
+		default:
+			return cfg, fmt.Errorf("unknown option: '%s'", args[i])
+		}
+	}
+	return cfg, nil
+}
+
+// parseOutputLimit parses "<class> <hard> <soft> <soft seconds>". Only the
+// pubsub class is supported.
+func parseOutputLimit(value string) (outputLimit, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 4 {
+		return outputLimit{}, fmt.Errorf("wrong number of arguments in output buffer limit: '%s'", value)
+	}
+	if strings.ToLower(fields[0]) != "pubsub" {
+		return outputLimit{}, fmt.Errorf("unsupported client class: '%s'", fields[0])
+	}
+	hard, err := parseMemory(fields[1])
+	if err != nil {
+		return outputLimit{}, err
+	}
+	soft, err := parseMemory(fields[2])
+	if err != nil {
+		return outputLimit{}, err
+	}
+	seconds, err := strconv.Atoi(fields[3])
+	if err != nil || seconds < 0 {
+		return outputLimit{}, fmt.Errorf("invalid soft limit seconds: '%s'", fields[3])
+	}
+	return outputLimit{hard: hard, soft: soft, softDuration: time.Duration(seconds) * time.Second}, nil
+}
+
+// parseSaveRules parses "<seconds> <changes> ...", an empty string disables
+// snapshots.
+func parseSaveRules(value string) ([]saveRule, error) {
+	fields := strings.Fields(value)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("invalid save rules: '%s'", value)
+	}
+	rules := []saveRule{}
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err1 := strconv.ParseInt(fields[i], 10, 64)
+		changes, err2 := strconv.ParseInt(fields[i+1], 10, 64)
+		if err1 != nil || err2 != nil || seconds < 1 || changes < 0 {
+			return nil, fmt.Errorf("invalid save rules: '%s'", value)
+		}
+		rules = append(rules, saveRule{seconds: seconds, changes: changes})
+	}
+	return rules, nil
+}
+
+// Units of memory sizes, like in redis.conf.
+var memoryUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"kb", 1 << 10},
+	{"mb", 1 << 20},
+	{"gb", 1 << 30},
+	{"k", 1000},
+	{"m", 1000 * 1000},
+	{"g", 1000 * 1000 * 1000},
+	{"b", 1},
+}
+
+// parseMemory parses a size in bytes, optionally with a unit such as "32mb".
+func parseMemory(value string) (int64, error) {
+	num, unit := strings.ToLower(value), int64(1)
+	for _, u := range memoryUnits {
+		if rest, ok := strings.CutSuffix(num, u.suffix); ok {
+			num, unit = rest, u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid memory size: '%s'", value)
+	}
+	return n * unit, nil
+}
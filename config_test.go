@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	var tests = []struct {
+		args      []string
+		databases int
+		wantErr   bool
+	}{
+		// the table itself
+		{[]string{}, 16, false},
+		{[]string{"--databases", "4"}, 4, false},
+		{[]string{"--DATABASES", "1"}, 1, false},
+		{[]string{"--databases", "0"}, 0, true},
+		{[]string{"--databases", "x"}, 0, true},
+		{[]string{"--databases"}, 0, true},
+		{[]string{"databases", "4"}, 0, true},
+		{[]string{"--unknown", "4"}, 0, true},
+	}
+
+	for _, test := range tests {
+		cfg, err := parseConfig(test.args)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("Expected an error for '%v'.", test.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error '%v' for '%v'.", err, test.args)
+		} else if cfg.databases != test.databases {
+			t.Errorf("Got '%d' databases but expected '%d' for '%v'.", cfg.databases, test.databases, test.args)
+		}
+	}
+
+	cfg, err := parseConfig([]string{"--appendonly", "yes", "--appendfsync", "always", "--appendfilename", "log.aof"})
+	if err != nil || !cfg.appendOnly || cfg.appendFsync != fsyncAlways || cfg.appendFilename != "log.aof" {
+		t.Errorf("Got '%+v' (%v) for the append-only file options.", cfg, err)
+	}
+	if _, err := parseConfig([]string{"--appendfsync", "sometimes"}); err == nil {
+		t.Errorf("Expected an error for an unknown fsync policy.")
+	}
+
+	if cfg := defaultConfig(); cfg.snapshotFormat != snapshotFormatRDB || cfg.dbfilename != "dump.rdb" {
+		t.Errorf("Expected RDB snapshots in dump.rdb by default but got '%+v'.", cfg)
+	}
+	cfg, err = parseConfig([]string{"--snapshot-format", "Redis-Lite"})
+	if err != nil || cfg.snapshotFormat != snapshotFormatLite {
+		t.Errorf("Got '%+v' (%v) for the snapshot format.", cfg, err)
+	}
+	if _, err := parseConfig([]string{"--snapshot-format", "json"}); err == nil {
+		t.Errorf("Expected an error for an unknown snapshot format.")
+	}
+
+	cfg, err = parseConfig([]string{"--maxmemory", "100mb", "--maxmemory-policy", "allkeys-LFU", "--maxmemory-samples", "10"})
+	if err != nil || cfg.maxmemory != 100<<20 || cfg.maxmemoryPolicy != evictAllKeysLFU || cfg.maxmemorySamples != 10 {
+		t.Errorf("Got '%+v' (%v) for the maxmemory options.", cfg, err)
+	}
+	if _, err := parseConfig([]string{"--maxmemory-policy", "oldest-first"}); err == nil {
+		t.Errorf("Expected an error for an unknown maxmemory policy.")
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	var tests = []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		// the table itself
+		{"0", 0, false},
+		{"100", 100, false},
+		{"1k", 1000, false},
+		{"1kb", 1024, false},
+		{"32mb", 32 << 20, false},
+		{"2GB", 2 << 30, false},
+		{"1g", 1000 * 1000 * 1000, false},
+		{"mb", 0, true},
+		{"-1", 0, true},
+		{"1tb", 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := parseMemory(test.input)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("Expected an error for '%s'.", test.input)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("Got '%d' (%v) but expected '%d' for '%s'.", got, err, test.want, test.input)
+		}
+	}
+
+	cfg, err := parseConfig([]string{"--client-output-buffer-limit", "pubsub 1mb 512kb 10"})
+	if err != nil || cfg.pubsubOutputLimit.hard != 1<<20 || cfg.pubsubOutputLimit.soft != 512<<10 || cfg.pubsubOutputLimit.softDuration != 10*time.Second {
+		t.Errorf("Got '%+v' (%v) for the output buffer limit.", cfg.pubsubOutputLimit, err)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+func handleKeys(arr []interface{}, conn *client, store *dictionary) {
+	pattern := arr[1].(string)
+	keys := []string{}
+	for key := range store.dict {
+		if !globMatch(pattern, key) {
+			continue
+		}
+		if _, ok := lookupRecord(store, key); ok {
+			keys = append(keys, key)
+		}
+	}
+	msg, _, _ := serializeStringArray(keys)
+	sendMsgToClient(conn, msg)
+}
+
+// handleScan walks the key table a few buckets per call, see keyTable.scan.
+func handleScan(arr []interface{}, conn *client, store *dictionary) {
+	opts, err := parseScanOptions(arr[1:], "type")
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	cursor, candidates := scanTable(store.keys, opts)
+	keys := []string{}
+	for _, key := range candidates {
+		if !opts.matches(key) {
+			continue
+		}
+		rec, ok := lookupRecord(store, key)
+		if !ok || (opts.typeName != "" && typeName(rec.value) != opts.typeName) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sendMsgToClient(conn, serializeScanReply(cursor, keys))
+}
+
+func handleType(arr []interface{}, conn *client, store *dictionary) {
+	name := "none"
+	if rec, ok := lookupRecord(store, arr[1].(string)); ok {
+		name = typeName(rec.value)
+	}
+	msg, _ := serializeSimpleString(name)
+	sendMsgToClient(conn, msg)
+}
+
+// handleDBSize counts expired keys that weren't deleted yet as well, like
+// Redis.
+func handleDBSize(arr []interface{}, conn *client, store *dictionary) {
+	msg, _, _ := serializeInteger(int64(len(store.dict)))
+	sendMsgToClient(conn, msg)
+}
+
+func handleRandomKey(arr []interface{}, conn *client, store *dictionary) {
+	// Expired keys are deleted as they come up, so this ends either with a
+	// live key or an empty store.
+	for {
+		key, ok := store.keys.random()
+		if !ok {
+			sendMsgToClient(conn, conn.serializeNull())
+			return
+		}
+		if _, ok := lookupRecord(store, key); ok {
+			sendMsgToClient(conn, serializeBulkString(key))
+			return
+		}
+	}
+}
+
+func handleRename(arr []interface{}, conn *client, store *dictionary) {
+	renameGeneric(arr, conn, store, false)
+}
+
+func handleRenameNX(arr []interface{}, conn *client, store *dictionary) {
+	renameGeneric(arr, conn, store, true)
+}
+
+// renameGeneric moves the record at the source key to the destination,
+// expiry included, replacing the destination unless nx is set.
+func renameGeneric(arr []interface{}, conn *client, store *dictionary, nx bool) {
+	src, dst := arr[1].(string), arr[2].(string)
+	rec, ok := lookupRecord(store, src)
+	if !ok {
+		sendErrorToClient(conn, "ERR no such key")
+		return
+	}
+
+	renamed := true
+	if src == dst {
+		renamed = false
+	} else if _, exists := lookupRecord(store, dst); exists && nx {
+		renamed = false
+	}
+	if renamed {
+		deleteKey(store, src)
+		setRecord(store, dst, rec)
+		signalListReady(store, dst)
+	}
+
+	if !nx {
+		msg, _ := serializeSimpleString("OK")
+		sendMsgToClient(conn, msg)
+		return
+	}
+	reply := int64(0)
+	if renamed {
+		reply = 1
+	}
+	msg, _, _ := serializeInteger(reply)
+	sendMsgToClient(conn, msg)
+}
+
+// handleCopy copies a key, expiry included, to the same or another database.
+// The copy shares no memory with the source, so writing to either leaves the
+// other untouched.
+func handleCopy(arr []interface{}, conn *client, store *dictionary) {
+	src, dst := arr[1].(string), arr[2].(string)
+	dstStore := store
+	replace := false
+	for i := 3; i < len(arr); i++ {
+		switch opt := strings.ToLower(arr[i].(string)); {
+		case opt == "replace":
+			replace = true
+		case opt == "db" && i+1 < len(arr):
+			var err error
+			if dstStore, err = lookupDB(store.srv, arr[i+1]); err != nil {
+				sendErrorToClient(conn, err.Error())
+				return
+			}
+			i++
+		default:
+			sendErrorToClient(conn, "ERR syntax error")
+			return
+		}
+	}
+	if src == dst && dstStore == store {
+		sendErrorToClient(conn, "ERR source and destination objects are the same")
+		return
+	}
+
+	rec, ok := lookupRecord(store, src)
+	if !ok {
+		msg, _, _ := serializeInteger(0)
+		sendMsgToClient(conn, msg)
+		return
+	}
+	if _, exists := lookupRecord(dstStore, dst); exists && !replace {
+		msg, _, _ := serializeInteger(0)
+		sendMsgToClient(conn, msg)
+		return
+	}
+	setRecord(dstStore, dst, record{value: cloneValue(rec.value), expiryTimestamp: rec.expiryTimestamp})
+	signalListReady(dstStore, dst)
+
+	msg, _, _ := serializeInteger(1)
+	sendMsgToClient(conn, msg)
+}
+
+// handleUnlink is DEL, the garbage collector already frees values off the lock.
+func handleUnlink(arr []interface{}, conn *client, store *dictionary) {
+	handleDel(arr, conn, store)
+}
+
+// handleTouch counts the existing keys, reading them deletes the expired ones.
+func handleTouch(arr []interface{}, conn *client, store *dictionary) {
+	handleExists(arr, conn, store)
+}
+
+// lookupDB returns the database whose index is given in arg.
+func lookupDB(srv *server, arg interface{}) (*dictionary, error) {
+	id, err := parseInteger(arg)
+	if err != nil {
+		return nil, err
+	}
+	if id < 0 || id >= int64(len(srv.dbs)) {
+		return nil, errors.New("ERR DB index is out of range")
+	}
+	return srv.dbs[id], nil
+}
+
+func handleSelect(arr []interface{}, conn *client, store *dictionary) {
+	db, err := lookupDB(store.srv, arr[1])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	conn.db = db
+
+	msg, _ := serializeSimpleString("OK")
+	sendMsgToClient(conn, msg)
+}
+
+// handleMove moves a key, expiry included, to another database unless the key
+// exists there already.
+func handleMove(arr []interface{}, conn *client, store *dictionary) {
+	key := arr[1].(string)
+	dst, err := lookupDB(store.srv, arr[2])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if dst == store {
+		sendErrorToClient(conn, "ERR source and destination objects are the same")
+		return
+	}
+
+	moved := int64(0)
+	rec, ok := lookupRecord(store, key)
+	if _, exists := lookupRecord(dst, key); ok && !exists {
+		deleteKey(store, key)
+		setRecord(dst, key, rec)
+		signalListReady(dst, key)
+		moved = 1
+	}
+	msg, _, _ := serializeInteger(moved)
+	sendMsgToClient(conn, msg)
+}
+
+// handleSwapDB swaps the data of two databases. Clients that selected one of
+// them see the data of the other from now on.
+func handleSwapDB(arr []interface{}, conn *client, store *dictionary) {
+	a, err := lookupDB(store.srv, arr[1])
+	if err == errNotInteger {
+		err = errors.New("ERR invalid first DB index")
+	}
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	b, err := lookupDB(store.srv, arr[2])
+	if err == errNotInteger {
+		err = errors.New("ERR invalid second DB index")
+	}
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	if a != b {
+		a.dict, b.dict = b.dict, a.dict
+		a.keys, b.keys = b.keys, a.keys
+		a.expires, b.expires = b.expires, a.expires
+		a.expireStats, b.expireStats = b.expireStats, a.expireStats
+		a.usedMemory, b.usedMemory = b.usedMemory, a.usedMemory
+		touchAllWatchedKeys(a)
+		touchAllWatchedKeys(b)
+		store.srv.persistence.dirty++
+		// Blocked clients stay with their database, which may hold the
+		// lists they wait for now.
+		for key := range a.blocked {
+			signalListReady(a, key)
+		}
+		for key := range b.blocked {
+			signalListReady(b, key)
+		}
+	}
+
+	msg, _ := serializeSimpleString("OK")
+	sendMsgToClient(conn, msg)
+}
+
+// parseFlushMode checks the optional ASYNC or SYNC argument of FLUSHDB and
+// FLUSHALL, which flush the same way, see handleUnlink.
+func parseFlushMode(arr []interface{}) error {
+	if len(arr) > 2 {
+		return errors.New("ERR syntax error")
+	}
+	if len(arr) == 2 {
+		mode := strings.ToLower(arr[1].(string))
+		if mode != "async" && mode != "sync" {
+			return errors.New("ERR syntax error")
+		}
+	}
+	return nil
+}
+
+func handleFlushDB(arr []interface{}, conn *client, store *dictionary) {
+	if err := parseFlushMode(arr); err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	store.flush()
+
+	msg, _ := serializeSimpleString("OK")
+	sendMsgToClient(conn, msg)
+}
+
+func handleFlushAll(arr []interface{}, conn *client, store *dictionary) {
+	if err := parseFlushMode(arr); err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	for _, db := range store.srv.dbs {
+		db.flush()
+	}
+
+	msg, _ := serializeSimpleString("OK")
+	sendMsgToClient(conn, msg)
+}
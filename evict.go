@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Memory use is estimated from the data alone, the way MEMORY USAGE does in
+// Redis: a fixed overhead per key and per element, plus the bytes of the
+// strings. Large collections are estimated from a few sampled elements, so
+// keeping the estimate current costs constant time per write.
+const (
+	// The map entry, the key's string header and the record.
+	recordOverhead = 96
+	// A node of a linked list.
+	listNodeOverhead = 48
+	// An entry of the map of a hash or set.
+	mapEntryOverhead = 48
+	// A map entry plus a skiplist node of a sorted set.
+	zsetEntryOverhead = 96
+	// Elements sampled to estimate the size of a collection.
+	sizeSamples = 5
+)
+
+// LFU counters grow logarithmically and decay with time, like in Redis with
+// the default lfu-log-factor and lfu-decay-time.
+const (
+	// Counter of new keys, so they aren't evicted before their next access.
+	lfuInitValue = 5
+	lfuLogFactor = 10
+	// Seconds without access that take one off the counter.
+	lfuDecaySeconds = 60
+)
+
+const errOOM = "OOM command not allowed when used memory > 'maxmemory'."
+
+// lruClock is the time of access kept by records, in seconds.
+func lruClock() uint32 {
+	return uint32(time.Now().Unix())
+}
+
+// estimateRecordSize returns the estimated bytes key and its value take.
+func estimateRecordSize(key string, value interface{}) int64 {
+	size := int64(recordOverhead + len(key))
+	switch v := value.(type) {
+	case string:
+		size += int64(len(v))
+	case linkedList:
+		var sampled, bytes int
+		for n := v.head; n != nil && sampled < sizeSamples; n = n.next {
+			bytes += len(n.value)
+			sampled++
+		}
+		size += scaleSample(bytes, sampled, int(v.length), listNodeOverhead)
+	case *hash:
+		var sampled, bytes int
+		for field, val := range v.fields {
+			if sampled == sizeSamples {
+				break
+			}
+			bytes += len(field) + len(val)
+			sampled++
+		}
+		size += scaleSample(bytes, sampled, v.size(), mapEntryOverhead)
+	case *set:
+		if v.isIntset() {
+			size += int64(8 * len(v.ints))
+			break
+		}
+		var sampled, bytes int
+		for member := range v.members {
+			if sampled == sizeSamples {
+				break
+			}
+			bytes += len(member)
+			sampled++
+		}
+		size += scaleSample(bytes, sampled, len(v.members), mapEntryOverhead)
+	case *sortedSet:
+		var sampled, bytes int
+		for member := range v.dict {
+			if sampled == sizeSamples {
+				break
+			}
+			bytes += len(member)
+			sampled++
+		}
+		size += scaleSample(bytes, sampled, v.size(), zsetEntryOverhead)
+	}
+	return size
+}
+
+// scaleSample estimates the size of n elements from bytes of sampled ones.
+func scaleSample(bytes, sampled, n, overhead int) int64 {
+	if sampled == 0 {
+		return 0
+	}
+	return int64(n) * (int64(bytes)/int64(sampled) + int64(overhead))
+}
+
+// updateRecordSize estimates the size of key again, after a command changed
+// its value in place.
+// Must be called with store.mu held.
+func updateRecordSize(store *dictionary, key string) {
+	rec, ok := store.dict[key]
+	if !ok {
+		return
+	}
+	size := estimateRecordSize(key, rec.value)
+	store.usedMemory += size - rec.size
+	rec.size = size
+	store.dict[key] = rec
+}
+
+// touchRecord updates the access metadata of rec, which is stored at key.
+// Must be called with store.mu held.
+func touchRecord(store *dictionary, key string, rec *record) {
+	now := lruClock()
+	if store.srv != nil && isLFU(store.srv.cfg.maxmemoryPolicy) {
+		rec.lfu = lfuLogIncr(lfuDecay(*rec, now))
+	}
+	rec.lru = now
+	store.dict[key] = *rec
+}
+
+// lfuDecay returns the LFU counter of rec, lowered by one for every
+// lfuDecaySeconds since the last access.
+func lfuDecay(rec record, now uint32) uint8 {
+	if now < rec.lru {
+		return rec.lfu
+	}
+	periods := int64(now-rec.lru) / lfuDecaySeconds
+	return uint8(max(int64(rec.lfu)-periods, 0))
+}
+
+// lfuLogIncr increments counter with a probability that falls the higher it
+// is, so 8 bits count up to a million accesses.
+func lfuLogIncr(counter uint8) uint8 {
+	if counter == math.MaxUint8 {
+		return counter
+	}
+	base := max(float64(counter)-lfuInitValue, 0)
+	if rand.Float64() < 1/(base*lfuLogFactor+1) {
+		counter++
+	}
+	return counter
+}
+
+func isLFU(policy evictionPolicy) bool {
+	return policy == evictAllKeysLFU || policy == evictVolatileLFU
+}
+
+// usedMemory returns the estimated size of the data of every database.
+// Must be called with srv.mu held.
+func (srv *server) usedMemory() int64 {
+	var used int64
+	for _, store := range srv.dbs {
+		used += store.usedMemory
+	}
+	return used
+}
+
+// performEvictions evicts keys until the data fits in maxmemory again, and
+// reports whether it does. It doesn't under noeviction, or when the policy
+// finds no more keys to evict.
+// Must be called with srv.mu held.
+func performEvictions(srv *server) bool {
+	// The log replays as it was written, evicting happens afterwards.
+	if srv.cfg.maxmemory == 0 || srv.loading {
+		return true
+	}
+	for srv.usedMemory() > srv.cfg.maxmemory {
+		if srv.cfg.maxmemoryPolicy == evictNoEviction {
+			return false
+		}
+		store, key, ok := evictionCandidate(srv)
+		if !ok {
+			return false
+		}
+		deleteKey(store, key)
+		propagate(store, "del", key)
+		srv.evictedKeys++
+	}
+	return true
+}
+
+// evictionCandidate samples keys of every database and returns the best one
+// to evict by policy. Like Redis, this approximates the policy in constant
+// time instead of keeping every key ordered by it.
+// Must be called with srv.mu held.
+func evictionCandidate(srv *server) (*dictionary, string, bool) {
+	policy := srv.cfg.maxmemoryPolicy
+	volatile := policy == evictVolatileLRU || policy == evictVolatileLFU ||
+		policy == evictVolatileRandom || policy == evictVolatileTTL
+	now := lruClock()
+
+	var best *dictionary
+	var bestKey string
+	bestScore := math.Inf(-1)
+	for _, store := range srv.dbs {
+		for i := 0; i < srv.cfg.maxmemorySamples; i++ {
+			var key string
+			if volatile {
+				e := store.expires.random()
+				if e == nil {
+					break
+				}
+				key = e.key
+			} else {
+				var ok bool
+				if key, ok = store.keys.random(); !ok {
+					break
+				}
+			}
+
+			// The higher the score, the better the key to evict.
+			rec := store.dict[key]
+			var score float64
+			switch policy {
+			case evictAllKeysLRU, evictVolatileLRU:
+				score = float64(int64(now) - int64(rec.lru))
+			case evictAllKeysLFU, evictVolatileLFU:
+				score = float64(math.MaxUint8 - lfuDecay(rec, now))
+			case evictVolatileTTL:
+				score = -float64(rec.expiryTimestamp)
+			default:
+				score = rand.Float64()
+			}
+			if score > bestScore {
+				best, bestKey, bestScore = store, key, score
+			}
+		}
+	}
+	return best, bestKey, best != nil
+}
+
+// deniedByOOM reports whether the command must be refused while the data
+// doesn't fit in maxmemory: commands that add data, and EXEC when one of them
+// is queued.
+func deniedByOOM(spec *commandSpec, conn *client) bool {
+	if spec.flags&flagDenyOOM != 0 {
+		return true
+	}
+	if spec.name == "exec" && conn.multi != nil {
+		for _, queued := range conn.multi.queued {
+			if queued.spec.flags&flagDenyOOM != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bytesToHuman formats a size the way INFO does.
+func bytesToHuman(n int64) string {
+	switch {
+	case n < 1<<10:
+		return fmt.Sprintf("%dB", n)
+	case n < 1<<20:
+		return fmt.Sprintf("%.2fK", float64(n)/(1<<10))
+	case n < 1<<30:
+		return fmt.Sprintf("%.2fM", float64(n)/(1<<20))
+	}
+	return fmt.Sprintf("%.2fG", float64(n)/(1<<30))
+}
+
+func infoMemory(srv *server) []string {
+	policy := ""
+	for name, p := range evictionPolicyNames {
+		if p == srv.cfg.maxmemoryPolicy {
+			policy = name
+		}
+	}
+	used := srv.usedMemory()
+	return []string{
+		fmt.Sprintf("used_memory:%d", used),
+		"used_memory_human:" + bytesToHuman(used),
+		fmt.Sprintf("maxmemory:%d", srv.cfg.maxmemory),
+		"maxmemory_human:" + bytesToHuman(srv.cfg.maxmemory),
+		"maxmemory_policy:" + policy,
+	}
+}
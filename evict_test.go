@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// runCommand runs a command on a client without a connection and returns
+// its reply.
+func runCommand(conn *client, args ...string) string {
+	arr := make([]interface{}, len(args))
+	for i, arg := range args {
+		arr[i] = arg
+	}
+	dispatchCommand(arr, conn)
+	reply := conn.out.String()
+	conn.out.Reset()
+	return reply
+}
+
+// checkUsedMemory fails unless the memory of every database adds up the
+// sizes of its records.
+func checkUsedMemory(t *testing.T, srv *server) {
+	t.Helper()
+	for _, store := range srv.dbs {
+		var sum int64
+		for _, rec := range store.dict {
+			sum += rec.size
+		}
+		if sum != store.usedMemory {
+			t.Errorf("Got used memory '%d' in db %d but the records add up to '%d'.", store.usedMemory, store.id, sum)
+		}
+	}
+}
+
+func TestUsedMemory(t *testing.T) {
+	srv := newServer(defaultConfig())
+	conn := newClient(nil, srv.dbs[0])
+
+	runCommand(conn, "set", "string", strings.Repeat("x", 1000))
+	small := srv.usedMemory()
+	runCommand(conn, "append", "string", strings.Repeat("x", 1000))
+	if grown := srv.usedMemory(); grown < small+1000 {
+		t.Errorf("Expected appending 1000 bytes to grow '%d' but got '%d'.", small, grown)
+	}
+
+	var tests = []struct {
+		name string
+		args []string
+	}{
+		// the table itself
+		{"Should count list pushes", []string{"rpush", "list", "a", "b", "c"}},
+		{"Should count list pops", []string{"lpop", "list"}},
+		{"Should count hash fields set in place", []string{"hset", "hash", "f1", "v1", "f2", "v2"}},
+		{"Should count hash fields deleted in place", []string{"hdel", "hash", "f1"}},
+		{"Should count set members", []string{"sadd", "set", "a", "b", "1"}},
+		{"Should count sorted set members", []string{"zadd", "zset", "1", "a", "2", "b"}},
+		{"Should count a moved key", []string{"move", "set", "1"}},
+		{"Should count a renamed key", []string{"rename", "zset", "zset2"}},
+		{"Should count a copied key", []string{"copy", "hash", "hash2"}},
+		{"Should count deleted keys", []string{"del", "string", "list"}},
+		{"Should count unlinked keys", []string{"unlink", "hash2"}},
+		{"Should count swapped databases", []string{"swapdb", "0", "1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := srv.usedMemory()
+			if reply := runCommand(conn, test.args...); strings.HasPrefix(reply, "-") {
+				t.Fatalf("Got '%s'", reply)
+			}
+			checkUsedMemory(t, srv)
+			if test.args[0] != "swapdb" && test.args[0] != "move" && srv.usedMemory() == before {
+				t.Errorf("Expected the used memory '%d' to change.", before)
+			}
+		})
+	}
+
+	runCommand(conn, "flushall")
+	if used := srv.usedMemory(); used != 0 {
+		t.Errorf("Expected no used memory after FLUSHALL but got '%d'", used)
+	}
+}
+
+func TestEstimateRecordSize(t *testing.T) {
+	var short, long linkedList
+	for i := 0; i < 100; i++ {
+		short.pushBack("x")
+		long.pushBack(strings.Repeat("x", 100))
+	}
+	if a, b := estimateRecordSize("k", short), estimateRecordSize("k", long); b-a != 100*99 {
+		t.Errorf("Expected 100 longer elements to add '%d' but got '%d'.", 100*99, b-a)
+	}
+
+	ints, strs := newSet(), newSet()
+	for i := 0; i < 100; i++ {
+		ints.add(fmt.Sprint(i))
+		strs.add(fmt.Sprint("member", i))
+	}
+	if a, b := estimateRecordSize("k", ints), estimateRecordSize("k", strs); a >= b {
+		t.Errorf("Expected an intset '%d' to be smaller than a set of strings '%d'.", a, b)
+	}
+}
+
+// fillServer sets keys prefix0 to prefix<n-1> of 100 bytes, volatile ones
+// when ttl isn't empty.
+func fillServer(conn *client, prefix string, n int, ttl string) {
+	for i := 0; i < n; i++ {
+		args := []string{"set", fmt.Sprint(prefix, i), strings.Repeat("x", 100)}
+		if ttl != "" {
+			args = append(args, "ex", ttl)
+		}
+		runCommand(conn, args...)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	// About 19 keys of fillServer fit in maxmemory.
+	fill := func(srv *server, conn *client) {
+		fillServer(conn, "key", 19, "")
+	}
+	fillVolatile := func(srv *server, conn *client) {
+		runCommand(conn, "set", "persistent", "value")
+		fillServer(conn, "key", 19, "1000")
+	}
+
+	var tests = []struct {
+		policy string
+		// Fills the server, keep must survive writing more.
+		prepare func(srv *server, conn *client)
+		keep    []string
+		// The reply to a write once only keys that can't be evicted are left.
+		wantOOM bool
+	}{
+		// the table itself
+		{"noeviction", fill, nil, true},
+		{"allkeys-random", fill, nil, false},
+		{"allkeys-lru", func(srv *server, conn *client) {
+			fill(srv, conn)
+			// Every key but key3 was last used an hour ago.
+			for key, rec := range srv.dbs[0].dict {
+				if key != "key3" {
+					rec.lru -= 3600
+					srv.dbs[0].dict[key] = rec
+				}
+			}
+		}, []string{"key3"}, false},
+		{"allkeys-lfu", func(srv *server, conn *client) {
+			fill(srv, conn)
+			rec := srv.dbs[0].dict["key7"]
+			rec.lfu = 200
+			srv.dbs[0].dict["key7"] = rec
+		}, []string{"key7"}, false},
+		{"volatile-lru", fillVolatile, []string{"persistent"}, false},
+		{"volatile-lfu", fillVolatile, []string{"persistent"}, false},
+		{"volatile-random", fillVolatile, []string{"persistent"}, false},
+		{"volatile-ttl", func(srv *server, conn *client) {
+			fillVolatile(srv, conn)
+			runCommand(conn, "set", "late", "value", "ex", "100000")
+		}, []string{"persistent", "late"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			cfg, err := parseConfig([]string{"--maxmemory", "4kb", "--maxmemory-policy", test.policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Sample every key, so the test doesn't depend on chance.
+			cfg.maxmemorySamples = 64
+			srv := newServer(cfg)
+			conn := newClient(nil, srv.dbs[0])
+			test.prepare(srv, conn)
+
+			ttl := ""
+			if strings.HasPrefix(test.policy, "volatile") {
+				ttl = "1000"
+			}
+			fillServer(conn, "new", 15, ttl)
+			// Writes are checked before they run, so they may go over by one.
+			if used := srv.usedMemory(); used > cfg.maxmemory+300 {
+				t.Errorf("Got used memory '%d' well above maxmemory '%d'.", used, cfg.maxmemory)
+			}
+			checkUsedMemory(t, srv)
+			for _, key := range test.keep {
+				if _, ok := srv.dbs[0].dict[key]; !ok {
+					t.Errorf("Expected '%s' to be kept.", key)
+				}
+			}
+			if test.wantOOM != (srv.evictedKeys == 0) {
+				t.Errorf("Got '%d' evicted keys.", srv.evictedKeys)
+			}
+			if reply := runCommand(conn, "get", "key0"); strings.HasPrefix(reply, "-") {
+				t.Errorf("Expected reads to work but got '%s'", reply)
+			}
+
+			reply := runCommand(conn, "set", "last", strings.Repeat("x", 100))
+			if gotOOM := strings.HasPrefix(reply, "-OOM"); gotOOM != test.wantOOM {
+				t.Errorf("Got '%s' for a write, expected an OOM error '%v'", reply, test.wantOOM)
+			}
+		})
+	}
+}
+
+func TestEvictionVolatileOnly(t *testing.T) {
+	cfg, _ := parseConfig([]string{"--maxmemory", "1kb", "--maxmemory-policy", "volatile-lru"})
+	srv := newServer(cfg)
+	conn := newClient(nil, srv.dbs[0])
+
+	// Persistent keys can't make room, writes fail once they fill memory.
+	fillServer(conn, "key", 20, "")
+	if reply := runCommand(conn, "set", "new", "value"); !strings.HasPrefix(reply, "-OOM") {
+		t.Errorf("Expected an OOM error but got '%s'", reply)
+	}
+	if reply := runCommand(conn, "del", "key0"); reply != ":1\r\n" {
+		t.Errorf("Expected deleting to work but got '%s'", reply)
+	}
+
+	// A queued write fails the transaction.
+	fillServer(conn, "key", 20, "")
+	runCommand(conn, "multi")
+	if reply := runCommand(conn, "incr", "counter"); !strings.HasPrefix(reply, "-OOM") {
+		t.Errorf("Expected an OOM error but got '%s'", reply)
+	}
+	if reply := runCommand(conn, "exec"); !strings.HasPrefix(reply, "-EXECABORT") {
+		t.Errorf("Expected the transaction to abort but got '%s'", reply)
+	}
+}
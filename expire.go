@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func handleExpire(arr []interface{}, conn *client, store *dictionary) {
+	expireGeneric(arr, conn, store, time.Second, false)
+}
+
+func handlePExpire(arr []interface{}, conn *client, store *dictionary) {
+	expireGeneric(arr, conn, store, time.Millisecond, false)
+}
+
+func handleExpireAt(arr []interface{}, conn *client, store *dictionary) {
+	expireGeneric(arr, conn, store, time.Second, true)
+}
+
+func handlePExpireAt(arr []interface{}, conn *client, store *dictionary) {
+	expireGeneric(arr, conn, store, time.Millisecond, true)
+}
+
+// expireGeneric implements the EXPIRE family. The time argument is in units,
+// either relative to now or an absolute unix time.
+func expireGeneric(arr []interface{}, conn *client, store *dictionary, unit time.Duration, absolute bool) {
+	key := arr[1].(string)
+	cmd := strings.ToLower(arr[0].(string))
+	when, err := parseInteger(arr[2])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	var nx, xx, gt, lt bool
+	for _, arg := range arr[3:] {
+		switch strings.ToLower(arg.(string)) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "gt":
+			gt = true
+		case "lt":
+			lt = true
+		default:
+			sendErrorToClient(conn, fmt.Sprintf("ERR Unsupported option %s", arg.(string)))
+			return
+		}
+	}
+	if nx && (xx || gt || lt) {
+		sendErrorToClient(conn, "ERR NX and XX, GT or LT options at the same time are not compatible")
+		return
+	}
+	if gt && lt {
+		sendErrorToClient(conn, "ERR GT and LT options at the same time are not compatible")
+		return
+	}
+
+	expiryTimestamp, err := expiryFromArgument(when, unit, absolute)
+	if err != nil {
+		sendErrorToClient(conn, fmt.Sprintf("ERR invalid expire time in '%s' command", cmd))
+		return
+	}
+	// Logged with the absolute time, so it replays the same later on.
+	args := []string{"pexpireat", key, strconv.FormatInt(expiryTimestamp, 10)}
+	for _, arg := range arr[3:] {
+		args = append(args, arg.(string))
+	}
+	rewriteCommand(conn, args)
+
+	rec, ok := lookupRecord(store, key)
+	if !ok {
+		msg, _, _ := serializeInteger(0)
+		sendMsgToClient(conn, msg)
+		return
+	}
+
+	// A key without an expiry counts as expiring never.
+	current := rec.expiryTimestamp
+	persistent := current == -1
+	if (nx && !persistent) || (xx && persistent) ||
+		(gt && (persistent || expiryTimestamp <= current)) ||
+		(lt && !persistent && expiryTimestamp >= current) {
+		msg, _, _ := serializeInteger(0)
+		sendMsgToClient(conn, msg)
+		return
+	}
+
+	// An expiry in the past deletes the key right away, except while
+	// replaying, where the commands that follow may still see the key.
+	if expiryTimestamp <= time.Now().UnixMilli() && (store.srv == nil || !store.srv.loading) {
+		deleteKey(store, key)
+	} else {
+		setRecord(store, key, record{value: rec.value, expiryTimestamp: expiryTimestamp})
+	}
+
+	msg, _, _ := serializeInteger(1)
+	sendMsgToClient(conn, msg)
+}
+
+// expiryFromArgument converts an EXPIRE style argument to a unix time in
+// milliseconds, failing on overflow.
+func expiryFromArgument(when int64, unit time.Duration, absolute bool) (int64, error) {
+	scale := int64(unit / time.Millisecond)
+	if when > math.MaxInt64/scale || when < math.MinInt64/scale {
+		return 0, errors.New("expire time overflows")
+	}
+	millis := when * scale
+	if absolute {
+		return millis, nil
+	}
+	now := time.Now().UnixMilli()
+	if millis > math.MaxInt64-now {
+		return 0, errors.New("expire time overflows")
+	}
+	return now + millis, nil
+}
+
+func handleTTL(arr []interface{}, conn *client, store *dictionary) {
+	ttlGeneric(arr, conn, store, time.Second, false)
+}
+
+func handlePTTL(arr []interface{}, conn *client, store *dictionary) {
+	ttlGeneric(arr, conn, store, time.Millisecond, false)
+}
+
+func handleExpireTime(arr []interface{}, conn *client, store *dictionary) {
+	ttlGeneric(arr, conn, store, time.Second, true)
+}
+
+func handlePExpireTime(arr []interface{}, conn *client, store *dictionary) {
+	ttlGeneric(arr, conn, store, time.Millisecond, true)
+}
+
+// ttlGeneric implements TTL, PTTL, EXPIRETIME and PEXPIRETIME. The reply is -2
+// for a missing key and -1 for a key without an expiry.
+func ttlGeneric(arr []interface{}, conn *client, store *dictionary, unit time.Duration, absolute bool) {
+	reply := int64(-2)
+	if rec, ok := lookupRecord(store, arr[1].(string)); ok {
+		scale := int64(unit / time.Millisecond)
+		switch {
+		case rec.expiryTimestamp == -1:
+			reply = -1
+		case absolute:
+			reply = rec.expiryTimestamp / scale
+		default:
+			// Round to the nearest unit, like Redis does.
+			remaining := max(rec.expiryTimestamp-time.Now().UnixMilli(), 0)
+			reply = (remaining + scale/2) / scale
+		}
+	}
+
+	msg, _, _ := serializeInteger(reply)
+	sendMsgToClient(conn, msg)
+}
+
+func handlePersist(arr []interface{}, conn *client, store *dictionary) {
+	key := arr[1].(string)
+
+	rec, ok := lookupRecord(store, key)
+	if !ok || rec.expiryTimestamp == -1 {
+		msg, _, _ := serializeInteger(0)
+		sendMsgToClient(conn, msg)
+		return
+	}
+	setRecord(store, key, record{value: rec.value, expiryTimestamp: -1})
+
+	msg, _, _ := serializeInteger(1)
+	sendMsgToClient(conn, msg)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"container/heap"
+	"math/rand"
+	"time"
+)
+
+const (
+	// How often the active expirer runs, like the default Redis hz of 10.
+	activeExpireInterval = 100 * time.Millisecond
+	// Keys deleted per acquisition of store.mu, bounding how long other
+	// clients wait for the lock.
+	activeExpireKeyLimit = 20
+	// Time budget of a single cycle, so a mass expiry can't hog the CPU.
+	activeExpireCycleBudget = 25 * time.Millisecond
+	// Volatile keys sampled to estimate expired_stale_perc.
+	expireStaleSamples = 20
+)
+
+type expiryEntry struct {
+	key  string
+	when int64
+	// Position in the heap, maintained by expiryHeap.
+	index int
+}
+
+// expiryHeap is a min-heap of entries ordered by expiry time.
+type expiryHeap []*expiryEntry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].when < h[j].when }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x any) {
+	e := x.(*expiryEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+// expiryIndex tracks every volatile key by expiry time, so the active expirer
+// finds due keys without scanning the keyspace.
+type expiryIndex struct {
+	heap    expiryHeap
+	entries map[string]*expiryEntry
+}
+
+func newExpiryIndex() *expiryIndex {
+	return &expiryIndex{entries: map[string]*expiryEntry{}}
+}
+
+// set records that key expires at when, -1 removes the key from the index.
+func (ei *expiryIndex) set(key string, when int64) {
+	e, ok := ei.entries[key]
+	switch {
+	case when == -1:
+		ei.remove(key)
+	case ok:
+		e.when = when
+		heap.Fix(&ei.heap, e.index)
+	default:
+		e = &expiryEntry{key: key, when: when}
+		heap.Push(&ei.heap, e)
+		ei.entries[key] = e
+	}
+}
+
+func (ei *expiryIndex) remove(key string) {
+	e, ok := ei.entries[key]
+	if !ok {
+		return
+	}
+	heap.Remove(&ei.heap, e.index)
+	delete(ei.entries, key)
+}
+
+// next returns the entry expiring first, or nil if there are no volatile keys.
+func (ei *expiryIndex) next() *expiryEntry {
+	if len(ei.heap) == 0 {
+		return nil
+	}
+	return ei.heap[0]
+}
+
+// random returns a random entry, or nil if there are no volatile keys.
+func (ei *expiryIndex) random() *expiryEntry {
+	if len(ei.heap) == 0 {
+		return nil
+	}
+	return ei.heap[rand.Intn(len(ei.heap))]
+}
+
+func (ei *expiryIndex) len() int {
+	return len(ei.heap)
+}
+
+// expireStats are the counters INFO reports about expiry.
+type expireStats struct {
+	expiredKeys int64
+	// Estimated fraction of volatile keys that expired but weren't deleted
+	// yet, as a moving average.
+	staleRatio float64
+	// Cycles that ran out of time before deleting every due key.
+	timeCapReached int64
+}
+
+// activeKeyExpirer deletes expired keys in the background, so keys nobody
+// reads again don't hold on to memory.
+func activeKeyExpirer(srv *server) {
+	ticker := time.NewTicker(activeExpireInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		activeExpireCycle(srv)
+	}
+}
+
+// activeExpireCycle deletes due keys of every database, earliest first,
+// releasing the lock after every activeExpireKeyLimit keys and stopping when
+// the time budget is spent.
+func activeExpireCycle(srv *server) {
+	start := time.Now()
+	for _, store := range srv.dbs {
+		for {
+			srv.mu.Lock()
+			done := expireDueKeys(store, activeExpireKeyLimit)
+			if !done && time.Since(start) > activeExpireCycleBudget {
+				store.expireStats.timeCapReached++
+				sampleStaleKeys(store)
+				srv.mu.Unlock()
+				return
+			}
+			if done {
+				sampleStaleKeys(store)
+				srv.mu.Unlock()
+				break
+			}
+			srv.mu.Unlock()
+		}
+	}
+}
+
+// expireDueKeys deletes up to limit expired keys and reports whether no due
+// keys are left.
+// Must be called with store.mu held.
+func expireDueKeys(store *dictionary, limit int) bool {
+	for i := 0; i < limit; i++ {
+		e := store.expires.next()
+		if e == nil || !recordExpired(e.when) {
+			return true
+		}
+		expireKey(store, e.key)
+	}
+	e := store.expires.next()
+	return e == nil || !recordExpired(e.when)
+}
+
+// sampleStaleKeys updates the estimate of expired keys still in memory, by
+// sampling random volatile keys the way Redis does.
+// Must be called with store.mu held.
+func sampleStaleKeys(store *dictionary) {
+	perc := 0.0
+	if n := store.expires.len(); n > 0 {
+		stale := 0
+		for i := 0; i < expireStaleSamples; i++ {
+			if recordExpired(store.expires.heap[rand.Intn(n)].when) {
+				stale++
+			}
+		}
+		perc = float64(stale) / expireStaleSamples
+	}
+	store.expireStats.staleRatio = perc*0.05 + store.expireStats.staleRatio*0.95
+}
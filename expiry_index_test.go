@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpiryIndex(t *testing.T) {
+	ei := newExpiryIndex()
+	ei.set("c", 30)
+	ei.set("a", 10)
+	ei.set("b", 20)
+	ei.set("d", 5)
+	ei.set("d", 40)
+	ei.remove("b")
+	ei.set("e", -1)
+
+	// the table itself
+	expected := []struct {
+		key  string
+		when int64
+	}{
+		{"a", 10},
+		{"c", 30},
+		{"d", 40},
+	}
+	if ei.len() != len(expected) {
+		t.Fatalf("Got length '%d' but expected '%d'.", ei.len(), len(expected))
+	}
+	for _, test := range expected {
+		e := ei.next()
+		if e == nil || e.key != test.key || e.when != test.when {
+			t.Fatalf("Expected '%s' at %d but got '%v'.", test.key, test.when, e)
+		}
+		ei.remove(e.key)
+	}
+	if ei.next() != nil {
+		t.Errorf("Expected an empty index.")
+	}
+}
+
+func TestExpireDueKeys(t *testing.T) {
+	store := newStore()
+	past := time.Now().Add(-time.Second).UnixMilli()
+	future := time.Now().Add(time.Hour).UnixMilli()
+	for _, key := range []string{"a", "b", "c"} {
+		setRecord(store, key, record{value: "x", expiryTimestamp: past})
+	}
+	setRecord(store, "later", record{value: "x", expiryTimestamp: future})
+	setRecord(store, "forever", record{value: "x", expiryTimestamp: -1})
+
+	if expireDueKeys(store, 2) {
+		t.Errorf("Expected due keys to be left after hitting the limit.")
+	}
+	if !expireDueKeys(store, 2) {
+		t.Errorf("Expected no due keys to be left.")
+	}
+	if len(store.dict) != 2 || store.expires.len() != 1 {
+		t.Errorf("Expected 'later' and 'forever' to be left but got %v.", store.dict)
+	}
+	if store.expireStats.expiredKeys != 3 {
+		t.Errorf("Expected 3 expired keys but got '%d'.", store.expireStats.expiredKeys)
+	}
+}
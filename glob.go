@@ -0,0 +1,113 @@
+package main
+
+// globMatch reports whether str matches a Redis glob-style pattern:
+//
+//   - matches any sequence of characters, including none
+//     ?      matches a single character
+//     [abc]  matches one of the listed characters, [^abc] negates and
+//     [a-z] matches a range
+//     \x     matches x literally
+func globMatch(pattern, str string) bool {
+	p, s := 0, 0
+	// Position to resume from after the last '*', for backtracking.
+	starP, starS := -1, 0
+
+	for s < len(str) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				// Collapse consecutive stars.
+				for p < len(pattern) && pattern[p] == '*' {
+					p++
+				}
+				if p == len(pattern) {
+					return true
+				}
+				starP, starS = p, s
+				continue
+			case '?':
+				p++
+				s++
+				continue
+			case '[':
+				if end, ok := matchClass(pattern, p, str[s]); ok {
+					p = end
+					s++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) {
+					if pattern[p+1] == str[s] {
+						p += 2
+						s++
+						continue
+					}
+				} else if str[s] == '\\' {
+					p++
+					s++
+					continue
+				}
+			default:
+				if pattern[p] == str[s] {
+					p++
+					s++
+					continue
+				}
+			}
+		}
+
+		// Mismatch, let the last star swallow one more character.
+		if starP == -1 {
+			return false
+		}
+		starS++
+		p, s = starP, starS
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass matches c against the [...] class starting at pattern[start] and
+// returns the position after it. Like Redis, an unterminated class extends to
+// the end of the pattern.
+func matchClass(pattern string, start int, c byte) (int, bool) {
+	p := start + 1
+	negate := false
+	if p < len(pattern) && pattern[p] == '^' {
+		negate = true
+		p++
+	}
+
+	matched := false
+	for p < len(pattern) && pattern[p] != ']' {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			if pattern[p+1] == c {
+				matched = true
+			}
+			p += 2
+		case p+2 < len(pattern) && pattern[p+1] == '-':
+			lo, hi := pattern[p], pattern[p+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if lo <= c && c <= hi {
+				matched = true
+			}
+			p += 3
+		default:
+			if pattern[p] == c {
+				matched = true
+			}
+			p++
+		}
+	}
+	if p < len(pattern) {
+		// Step over the closing bracket.
+		p++
+	}
+	return p, matched != negate
+}
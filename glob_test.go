@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGlobMatch(t *testing.T) {
+	var tests = []struct {
+		pattern string
+		input   string
+		want    bool
+	}{
+		// the table itself
+		{"*", "anything", true},
+		{"*", "", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h*llo", "hello world", false},
+		{"user:*:session", "user:42:session", true},
+		{"user:*:session", "user:42:sessions", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"a*b*c", "aXXbYYc", true},
+		{"a*b*c", "aXXbYY", false},
+		{"**", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern+" "+test.input, func(t *testing.T) {
+			if got := globMatch(test.pattern, test.input); got != test.want {
+				t.Errorf("Got '%v' but expected '%v'.", got, test.want)
+			}
+		})
+	}
+}
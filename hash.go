@@ -0,0 +1,417 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// getHash returns the hash stored at key. ok is false when the key doesn't
+// exist, in which case the returned hash is empty.
+// Must be called with store.mu held.
+func getHash(store *dictionary, key string) (h *hash, ok bool, err error) {
+	h, ok, err = lookupValue[*hash](store, key)
+	if !ok {
+		h = newHash()
+	}
+	return h, ok, err
+}
+
+// hashChanged stores h at key when the key didn't exist, or signals that the
+// hash stored there changed. Commands call it once they set a field, so one
+// failing on a new key leaves no empty hash behind.
+// Must be called with store.mu held.
+func hashChanged(store *dictionary, key string, h *hash, existed bool) {
+	if !existed {
+		setRecord(store, key, record{value: h, expiryTimestamp: -1})
+		return
+	}
+	signalModifiedKey(store, key)
+}
+
+// sortedFields returns the fields of h in a stable order, so that HKEYS and
+// HVALS agree with each other.
+func sortedFields(h *hash) []string {
+	fields := h.list()
+	sort.Strings(fields)
+	return fields
+}
+
+func handleHSet(arr []interface{}, conn *client, store *dictionary) {
+	if len(arr)%2 != 0 {
+		sendArityError(conn, strings.ToLower(arr[0].(string)))
+		return
+	}
+
+	h, ok, err := getHash(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	added := 0
+	for i := 2; i < len(arr); i += 2 {
+		if h.set(arr[i].(string), arr[i+1].(string)) {
+			added++
+		}
+	}
+	hashChanged(store, arr[1].(string), h, ok)
+
+	// HMSET is the deprecated variant replying OK.
+	if strings.ToLower(arr[0].(string)) == "hmset" {
+		msg, _ := serializeSimpleString("OK")
+		sendMsgToClient(conn, msg)
+		return
+	}
+	msg, _, _ := serializeInteger(int64(added))
+	sendMsgToClient(conn, msg)
+}
+
+func handleHSetNX(arr []interface{}, conn *client, store *dictionary) {
+	h, ok, err := getHash(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	field := arr[2].(string)
+	if _, exists := h.fields[field]; exists {
+		msg, _, _ := serializeInteger(0)
+		sendMsgToClient(conn, msg)
+		return
+	}
+	h.set(field, arr[3].(string))
+	hashChanged(store, arr[1].(string), h, ok)
+
+	msg, _, _ := serializeInteger(1)
+	sendMsgToClient(conn, msg)
+}
+
+func handleHGet(arr []interface{}, conn *client, store *dictionary) {
+	h, _, err := getHash(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	val, ok := h.fields[arr[2].(string)]
+	if !ok {
+		sendMsgToClient(conn, conn.serializeNull())
+		return
+	}
+	sendMsgToClient(conn, serializeBulkString(val))
+}
+
+func handleHMGet(arr []interface{}, conn *client, store *dictionary) {
+	h, _, err := getHash(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	items := make([]string, 0, len(arr)-2)
+	for _, field := range arr[2:] {
+		if val, ok := h.fields[field.(string)]; ok {
+			items = append(items, serializeBulkString(val))
+		} else {
+			items = append(items, conn.serializeNull())
+		}
+	}
+	sendMsgToClient(conn, serializeArray(items))
+}
+
+func handleHDel(arr []interface{}, conn *client, store *dictionary) {
+	key := arr[1].(string)
+
+	h, _, err := getHash(store, key)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	deleted := 0
+	for _, field := range arr[2:] {
+		if h.remove(field.(string)) {
+			deleted++
+		}
+	}
+	if deleted > 0 && h.size() == 0 {
+		deleteKey(store, key)
+	} else if deleted > 0 {
+		signalModifiedKey(store, key)
+	}
+
+	msg, _, _ := serializeInteger(int64(deleted))
+	sendMsgToClient(conn, msg)
+}
+
+func handleHExists(arr []interface{}, conn *client, store *dictionary) {
+	h, _, err := getHash(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	exists := int64(0)
+	if _, ok := h.fields[arr[2].(string)]; ok {
+		exists = 1
+	}
+	msg, _, _ := serializeInteger(exists)
+	sendMsgToClient(conn, msg)
+}
+
+func handleHLen(arr []interface{}, conn *client, store *dictionary) {
+	h, _, err := getHash(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	msg, _, _ := serializeInteger(int64(h.size()))
+	sendMsgToClient(conn, msg)
+}
+
+func handleHStrLen(arr []interface{}, conn *client, store *dictionary) {
+	h, _, err := getHash(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	msg, _, _ := serializeInteger(int64(len(h.fields[arr[2].(string)])))
+	sendMsgToClient(conn, msg)
+}
+
+func handleHKeys(arr []interface{}, conn *client, store *dictionary) {
+	h, _, err := getHash(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	msg, _, _ := serializeStringArray(sortedFields(h))
+	sendMsgToClient(conn, msg)
+}
+
+func handleHVals(arr []interface{}, conn *client, store *dictionary) {
+	h, _, err := getHash(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	fields := sortedFields(h)
+	vals := make([]string, len(fields))
+	for i, field := range fields {
+		vals[i] = h.fields[field]
+	}
+	msg, _, _ := serializeStringArray(vals)
+	sendMsgToClient(conn, msg)
+}
+
+func handleHGetAll(arr []interface{}, conn *client, store *dictionary) {
+	h, _, err := getHash(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	pairs := make([]string, 0, 2*h.size())
+	for _, field := range sortedFields(h) {
+		pairs = append(pairs, serializeBulkString(field), serializeBulkString(h.fields[field]))
+	}
+	sendMsgToClient(conn, conn.serializeMap(pairs))
+}
+
+func handleHIncrBy(arr []interface{}, conn *client, store *dictionary) {
+	incr, err := parseInteger(arr[3])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	h, ok, err := getHash(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	field := arr[2].(string)
+	num := int64(0)
+	if val, exists := h.fields[field]; exists {
+		num, err = strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			sendErrorToClient(conn, "ERR hash value is not an integer")
+			return
+		}
+	}
+	if (incr > 0 && num > math.MaxInt64-incr) || (incr < 0 && num < math.MinInt64-incr) {
+		sendErrorToClient(conn, "ERR increment or decrement would overflow")
+		return
+	}
+	num += incr
+	h.set(field, strconv.FormatInt(num, 10))
+	hashChanged(store, arr[1].(string), h, ok)
+
+	msg, _, _ := serializeInteger(num)
+	sendMsgToClient(conn, msg)
+}
+
+func handleHIncrByFloat(arr []interface{}, conn *client, store *dictionary) {
+	incr, err := parseFloat(arr[3])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if math.IsInf(incr, 0) {
+		sendErrorToClient(conn, "ERR increment would produce NaN or Infinity")
+		return
+	}
+
+	h, ok, err := getHash(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	field := arr[2].(string)
+	num := 0.0
+	if val, exists := h.fields[field]; exists {
+		num, err = strconv.ParseFloat(val, 64)
+		if err != nil {
+			sendErrorToClient(conn, "ERR hash value is not a float")
+			return
+		}
+	}
+	num += incr
+	if math.IsNaN(num) || math.IsInf(num, 0) {
+		sendErrorToClient(conn, "ERR increment would produce NaN or Infinity")
+		return
+	}
+	h.set(field, strconv.FormatFloat(num, 'f', -1, 64))
+	hashChanged(store, arr[1].(string), h, ok)
+
+	sendMsgToClient(conn, serializeBulkString(h.fields[field]))
+}
+
+func handleHRandField(arr []interface{}, conn *client, store *dictionary) {
+	withValues := false
+	count := int64(1)
+	if len(arr) >= 3 {
+		var err error
+		count, err = parseInteger(arr[2])
+		if err != nil {
+			sendErrorToClient(conn, err.Error())
+			return
+		}
+	}
+	if len(arr) == 4 {
+		if strings.ToLower(arr[3].(string)) != "withvalues" {
+			sendErrorToClient(conn, "ERR syntax error")
+			return
+		}
+		withValues = true
+	}
+	if len(arr) > 4 {
+		sendErrorToClient(conn, "ERR syntax error")
+		return
+	}
+
+	h, _, err := getHash(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	if len(arr) == 2 {
+		if h.size() == 0 {
+			sendMsgToClient(conn, conn.serializeNull())
+			return
+		}
+		sendMsgToClient(conn, serializeBulkString(h.random()))
+		return
+	}
+
+	picked, err := randomMembers(h, count)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	items := make([]string, 0, len(picked))
+	for _, field := range picked {
+		switch {
+		case !withValues:
+			items = append(items, serializeBulkString(field))
+		case conn.protocol == 3:
+			pair, _, _ := serializeStringArray([]string{field, h.fields[field]})
+			items = append(items, pair)
+		default:
+			items = append(items, serializeBulkString(field), serializeBulkString(h.fields[field]))
+		}
+	}
+	sendMsgToClient(conn, serializeArray(items))
+}
+
+// randomSource is a collection random members are picked from.
+type randomSource interface {
+	size() int
+	// random returns a random member, the collection mustn't be empty.
+	random() string
+	list() []string
+}
+
+// randomMembers picks count distinct members, or fewer if there aren't as
+// many. A negative count picks -count members which may repeat.
+func randomMembers(src randomSource, count int64) ([]string, error) {
+	if count < 0 {
+		if count < -math.MaxInt32 {
+			return nil, errors.New("ERR value is out of range")
+		}
+		// Grow the reply while picking, the count comes from the client and
+		// preallocating it could exhaust the memory.
+		picked := []string{}
+		if src.size() == 0 {
+			return picked, nil
+		}
+		for int64(len(picked)) < -count {
+			picked = append(picked, src.random())
+		}
+		return picked, nil
+	}
+
+	// Like Redis, shuffle every member when most of them are asked for, as
+	// picking at random would keep hitting members picked already.
+	if count > int64(src.size())/3 {
+		shuffled := src.list()
+		rand.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		return shuffled[:min(count, int64(len(shuffled)))], nil
+	}
+	picked := make([]string, 0, min(count, int64(src.size())))
+	seen := make(map[string]bool, min(count, int64(src.size())))
+	for int64(len(picked)) < count {
+		if member := src.random(); !seen[member] {
+			seen[member] = true
+			picked = append(picked, member)
+		}
+	}
+	return picked, nil
+}
+
+// handleHScan walks the fields of the hash a few buckets per call, like SCAN.
+func handleHScan(arr []interface{}, conn *client, store *dictionary) {
+	opts, err := parseScanOptions(arr[2:], "novalues")
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	h, _, err := getHash(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	cursor, fields := scanTable(h.table, opts)
+	var items []string
+	for _, field := range fields {
+		if !opts.matches(field) {
+			continue
+		}
+		items = append(items, field)
+		if !opts.noValues {
+			items = append(items, h.fields[field])
+		}
+	}
+	sendMsgToClient(conn, serializeScanReply(cursor, items))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+var serverStartTime = time.Now()
+
+// infoSection generates the "field:value" lines of one INFO section.
+// Must be called with srv.mu held.
+type infoSection struct {
+	name     string
+	generate func(srv *server) []string
+}
+
+// infoSections in the order INFO prints them.
+var infoSections = []infoSection{
+	{"server", infoServer},
+	{"memory", infoMemory},
+	{"persistence", infoPersistence},
+	{"stats", infoStats},
+	{"keyspace", infoKeyspace},
+}
+
+func infoServer(srv *server) []string {
+	return []string{
+		"redis_version:" + serverVersion,
+		"redis_mode:standalone",
+		fmt.Sprintf("process_id:%d", os.Getpid()),
+		fmt.Sprintf("tcp_port:%d", port),
+		fmt.Sprintf("uptime_in_seconds:%d", int64(time.Since(serverStartTime).Seconds())),
+	}
+}
+
+func infoStats(srv *server) []string {
+	var stats expireStats
+	// The stale estimate of each database, weighted by its volatile keys.
+	var volatile int
+	for _, store := range srv.dbs {
+		stats.expiredKeys += store.expireStats.expiredKeys
+		stats.timeCapReached += store.expireStats.timeCapReached
+		stats.staleRatio += store.expireStats.staleRatio * float64(store.expires.len())
+		volatile += store.expires.len()
+	}
+	if volatile > 0 {
+		stats.staleRatio /= float64(volatile)
+	}
+	return []string{
+		fmt.Sprintf("expired_keys:%d", stats.expiredKeys),
+		fmt.Sprintf("expired_stale_perc:%.2f", stats.staleRatio*100),
+		fmt.Sprintf("expired_time_cap_reached_count:%d", stats.timeCapReached),
+		fmt.Sprintf("evicted_keys:%d", srv.evictedKeys),
+	}
+}
+
+func infoKeyspace(srv *server) []string {
+	var lines []string
+	for _, store := range srv.dbs {
+		if len(store.dict) > 0 {
+			lines = append(lines, fmt.Sprintf("db%d:keys=%d,expires=%d,avg_ttl=0", store.id, len(store.dict), store.expires.len()))
+		}
+	}
+	return lines
+}
+
+// handleInfo replies with the requested sections, or the default ones when
+// none are given.
+func handleInfo(arr []interface{}, conn *client, store *dictionary) {
+	requested := make([]string, 0, len(arr)-1)
+	for _, arg := range arr[1:] {
+		requested = append(requested, strings.ToLower(arg.(string)))
+	}
+	all := len(requested) == 0 || slices.ContainsFunc(requested, func(name string) bool {
+		return name == "default" || name == "all" || name == "everything"
+	})
+
+	var b strings.Builder
+	for _, section := range infoSections {
+		if !all && !slices.Contains(requested, section.name) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + "\r\n")
+		for _, line := range section.generate(store.srv) {
+			b.WriteString(line + "\r\n")
+		}
+	}
+
+	if conn.protocol == 3 {
+		msg, _ := serializeVerbatimString("txt", b.String())
+		sendMsgToClient(conn, msg)
+		return
+	}
+	sendMsgToClient(conn, serializeBulkString(b.String()))
+}
@@ -0,0 +1,94 @@
+package main
+
+// Every command runs with store.mu held, dispatchCommand takes it, and reads
+// keys through lookupRecord. An expired key is deleted the moment it is
+// accessed, so no command ever observes it. Writes go through setRecord and
+// deleteKey, which keep the key table and the expiry index in sync. Commands
+// that change a hash, set, list or sorted set in place call signalModifiedKey
+// themselves, and only when something did change.
+
+// lookupRecord returns the record at key, deleting it first if it has expired.
+// While the append-only file is replayed keys don't expire, it has a DEL where
+// they did.
+// Must be called with store.mu held.
+func lookupRecord(store *dictionary, key string) (record, bool) {
+	rec, ok := store.dict[key]
+	if !ok {
+		return rec, false
+	}
+	if recordExpired(rec.expiryTimestamp) && (store.srv == nil || !store.srv.loading) {
+		expireKey(store, key)
+		return record{}, false
+	}
+	touchRecord(store, key, &rec)
+	return rec, true
+}
+
+// expireKey deletes a key that expired.
+// Must be called with store.mu held.
+func expireKey(store *dictionary, key string) {
+	deleteKey(store, key)
+	store.expireStats.expiredKeys++
+	propagate(store, "del", key)
+}
+
+// lookupValue returns the value at key when it is a T. ok is false when the
+// key doesn't exist, in which case val is the zero T. err is errWrongType when
+// the key holds another type.
+// Must be called with store.mu held.
+func lookupValue[T any](store *dictionary, key string) (val T, ok bool, err error) {
+	rec, ok := lookupRecord(store, key)
+	if !ok {
+		return val, false, nil
+	}
+	val, isT := rec.value.(T)
+	if !isT {
+		return val, true, errWrongType
+	}
+	return val, true, nil
+}
+
+// setRecord stores rec at key.
+// Must be called with store.mu held.
+func setRecord(store *dictionary, key string, rec record) {
+	old, exists := store.dict[key]
+	if !exists {
+		store.keys.add(key)
+		if rec.lfu == 0 {
+			rec.lfu = lfuInitValue
+		}
+	} else {
+		// Overwriting a key keeps its access frequency, like in Redis.
+		rec.lfu = old.lfu
+	}
+	rec.lru = lruClock()
+	rec.size = estimateRecordSize(key, rec.value)
+	store.usedMemory += rec.size - old.size
+	store.dict[key] = rec
+	store.expires.set(key, rec.expiryTimestamp)
+	signalModifiedKey(store, key)
+}
+
+// signalModifiedKey records that a write changed key: it fails the EXEC of
+// the clients watching it, and counts as a change for the save rules.
+// Must be called with store.mu held.
+func signalModifiedKey(store *dictionary, key string) {
+	touchWatchedKey(store, key)
+	if store.srv != nil {
+		store.srv.persistence.dirty++
+	}
+}
+
+// deleteKey removes key, whether or not it exists.
+// Must be called with store.mu held.
+func deleteKey(store *dictionary, key string) {
+	rec, exists := store.dict[key]
+	if !exists {
+		return
+	}
+	store.usedMemory -= rec.size
+	delete(store.dict, key)
+	store.keys.remove(key)
+	signalModifiedKey(store, key)
+	store.expires.remove(key)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"math/rand"
+	"slices"
+)
+
+// Buckets of a keyTable never shrink below this.
+const keyTableMinSize = 4
+
+// keyTable buckets every key of the store by hash, the way the Redis dict
+// does. Go maps can't be iterated incrementally, so SCAN walks the buckets
+// instead, with a cursor that keeps working while the table is resized.
+type keyTable struct {
+	seed    maphash.Seed
+	buckets [][]string
+	count   int
+}
+
+func newKeyTable() *keyTable {
+	return &keyTable{seed: maphash.MakeSeed(), buckets: make([][]string, keyTableMinSize)}
+}
+
+func (kt *keyTable) bucket(key string) uint64 {
+	return maphash.String(kt.seed, key) & uint64(len(kt.buckets)-1)
+}
+
+// add inserts key, which mustn't be in the table already.
+func (kt *keyTable) add(key string) {
+	b := kt.bucket(key)
+	kt.buckets[b] = append(kt.buckets[b], key)
+	kt.count++
+	if kt.count > len(kt.buckets) {
+		kt.resize(len(kt.buckets) * 2)
+	}
+}
+
+func (kt *keyTable) remove(key string) {
+	b := kt.bucket(key)
+	i := slices.Index(kt.buckets[b], key)
+	if i == -1 {
+		return
+	}
+	last := len(kt.buckets[b]) - 1
+	kt.buckets[b][i] = kt.buckets[b][last]
+	kt.buckets[b][last] = ""
+	kt.buckets[b] = kt.buckets[b][:last]
+	kt.count--
+	if len(kt.buckets) > keyTableMinSize && kt.count < len(kt.buckets)/8 {
+		kt.resize(len(kt.buckets) / 2)
+	}
+}
+
+func (kt *keyTable) resize(size int) {
+	old := kt.buckets
+	kt.buckets = make([][]string, size)
+	for _, keys := range old {
+		for _, key := range keys {
+			b := kt.bucket(key)
+			kt.buckets[b] = append(kt.buckets[b], key)
+		}
+	}
+}
+
+// scan appends the keys of the bucket at cursor to keys and returns the cursor
+// of the next bucket, 0 once every bucket was visited.
+//
+// The cursor counts up with its bits reversed. Doubling the table splits
+// bucket b into b and b+size, halving it merges them again, and both of them
+// share the low bits that the reversed increment has already passed. So a
+// full scan returns every key that was in the table the whole time, at the
+// price of possibly returning some keys twice after a shrink.
+func (kt *keyTable) scan(cursor uint64, keys []string) (uint64, []string) {
+	mask := uint64(len(kt.buckets) - 1)
+	keys = append(keys, kt.buckets[cursor&mask]...)
+
+	// Set the bits above the mask, so the increment carries straight into
+	// the next bucket.
+	cursor |= ^mask
+	cursor = bits.Reverse64(bits.Reverse64(cursor) + 1)
+	return cursor, keys
+}
+
+// random returns a random key, false when the table is empty.
+func (kt *keyTable) random() (string, bool) {
+	if kt.count == 0 {
+		return "", false
+	}
+	// The table is at least 1/8 full, so this finds a key quickly.
+	for {
+		keys := kt.buckets[rand.Intn(len(kt.buckets))]
+		if len(keys) > 0 {
+			return keys[rand.Intn(len(keys))], true
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+// scanAll runs a full scan of kt, calling between after every step.
+func scanAll(kt *keyTable, between func(step int)) map[string]bool {
+	seen := map[string]bool{}
+	var cursor uint64
+	for step := 0; ; step++ {
+		var keys []string
+		cursor, keys = kt.scan(cursor, nil)
+		for _, key := range keys {
+			seen[key] = true
+		}
+		if cursor == 0 {
+			return seen
+		}
+		between(step)
+	}
+}
+
+func TestKeyTableScan(t *testing.T) {
+	// the table itself
+	tests := []struct {
+		name    string
+		initial int
+		// Runs between scan steps, mutating the table.
+		mutate func(kt *keyTable, step int)
+	}{
+		{"stable", 1000, func(kt *keyTable, step int) {}},
+		{"growing", 100, func(kt *keyTable, step int) {
+			// Growing for ever would keep the scan from ending.
+			if step >= 20 {
+				return
+			}
+			for i := 0; i < 500; i++ {
+				kt.add("new:" + strconv.Itoa(step) + ":" + strconv.Itoa(i))
+			}
+		}},
+		{"shrinking", 1000, func(kt *keyTable, step int) {
+			for i := 0; i < 50; i++ {
+				kt.remove("tmp:" + strconv.Itoa(step*50+i))
+			}
+		}},
+	}
+
+	for _, test := range tests {
+		kt := newKeyTable()
+		for i := 0; i < test.initial; i++ {
+			kt.add("key:" + strconv.Itoa(i))
+		}
+		for i := 0; i < 5000; i++ {
+			kt.add("tmp:" + strconv.Itoa(i))
+		}
+		if test.name != "shrinking" {
+			for i := 0; i < 5000; i++ {
+				kt.remove("tmp:" + strconv.Itoa(i))
+			}
+		}
+
+		seen := scanAll(kt, func(step int) { test.mutate(kt, step) })
+		// Keys present for the whole scan must all be returned.
+		for i := 0; i < test.initial; i++ {
+			if key := "key:" + strconv.Itoa(i); !seen[key] {
+				t.Errorf("%s: Scan missed '%s'.", test.name, key)
+			}
+		}
+	}
+}
+
+func TestKeyTableRemove(t *testing.T) {
+	kt := newKeyTable()
+	for i := 0; i < 100; i++ {
+		kt.add(strconv.Itoa(i))
+	}
+	for i := 0; i < 100; i += 2 {
+		kt.remove(strconv.Itoa(i))
+	}
+	kt.remove("missing")
+	if kt.count != 50 {
+		t.Fatalf("Got count '%d' but expected '50'.", kt.count)
+	}
+
+	seen := scanAll(kt, func(int) {})
+	if len(seen) != 50 {
+		t.Errorf("Got '%d' keys but expected '50'.", len(seen))
+	}
+	for i := 1; i < 100; i += 2 {
+		if !seen[strconv.Itoa(i)] {
+			t.Errorf("Missing '%d'.", i)
+		}
+	}
+	if key, ok := kt.random(); !ok || !seen[key] {
+		t.Errorf("Got random key '%s' that isn't in the table.", key)
+	}
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"strings"
+)
+
+// getList returns the list stored at key. ok is false when the key doesn't
+// exist, in which case the returned list is empty and ready to be filled.
+// Must be called with store.mu held.
+func getList(store *dictionary, key string) (ll linkedList, ok bool, err error) {
+	return lookupValue[linkedList](store, key)
+}
+
+// setList writes the list back to the store, keeping the key's expiry. Like
+// Redis, a list that became empty deletes the key.
+// Must be called with store.mu held.
+func setList(store *dictionary, key string, ll linkedList) {
+	if ll.length == 0 {
+		deleteKey(store, key)
+		return
+	}
+	rec, ok := store.dict[key]
+	if !ok {
+		rec = record{expiryTimestamp: -1}
+	}
+	rec.value = ll
+	setRecord(store, key, rec)
+}
+
+// listRange converts Redis start/stop indexes, which may be negative and out
+// of range, into an inclusive range within a list of the given length. ok is
+// false when the range is empty.
+func listRange(start, stop, length int) (int, int, bool) {
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop || start >= length {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
+
+func handleLPush(arr []interface{}, conn *client, store *dictionary) {
+	pushGeneric(arr, conn, store, true, false)
+}
+
+func handleRPush(arr []interface{}, conn *client, store *dictionary) {
+	pushGeneric(arr, conn, store, false, false)
+}
+
+func handleLPushX(arr []interface{}, conn *client, store *dictionary) {
+	pushGeneric(arr, conn, store, true, true)
+}
+
+func handleRPushX(arr []interface{}, conn *client, store *dictionary) {
+	pushGeneric(arr, conn, store, false, true)
+}
+
+// pushGeneric implements the push family. Elements are inserted one by one, so
+// LPUSH of A, B, C results in C -> B -> A. With onlyExisting nothing is
+// created when the key is missing.
+func pushGeneric(arr []interface{}, conn *client, store *dictionary, front bool, onlyExisting bool) {
+	key := arr[1].(string)
+
+	ll, ok, err := getList(store, key)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if !ok && onlyExisting {
+		msg, _, _ := serializeInteger(0)
+		sendMsgToClient(conn, msg)
+		return
+	}
+
+	for _, val := range arr[2:] {
+		if front {
+			ll.pushFront(val.(string))
+		} else {
+			ll.pushBack(val.(string))
+		}
+	}
+	setList(store, key, ll)
+	signalListReady(store, key)
+
+	msg, _, _ := serializeInteger(int64(ll.length))
+	sendMsgToClient(conn, msg)
+}
+
+func handleLPop(arr []interface{}, conn *client, store *dictionary) {
+	popGeneric(arr, conn, store, true)
+}
+
+func handleRPop(arr []interface{}, conn *client, store *dictionary) {
+	popGeneric(arr, conn, store, false)
+}
+
+// popGeneric implements LPOP and RPOP. Without a count a single bulk string is
+// returned, with a count always an array.
+func popGeneric(arr []interface{}, conn *client, store *dictionary, front bool) {
+	if len(arr) > 3 {
+		sendArityError(conn, strings.ToLower(arr[0].(string)))
+		return
+	}
+	key := arr[1].(string)
+
+	count := int64(1)
+	if len(arr) == 3 {
+		var err error
+		count, err = parseInteger(arr[2])
+		if err != nil || count < 0 {
+			sendErrorToClient(conn, "ERR value is out of range, must be positive")
+			return
+		}
+	}
+
+	ll, ok, err := getList(store, key)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if !ok {
+		if len(arr) == 3 {
+			sendMsgToClient(conn, conn.serializeNullArray())
+		} else {
+			sendMsgToClient(conn, conn.serializeNull())
+		}
+		return
+	}
+
+	resultArr := popElements(store, key, ll, front, count)
+
+	if len(arr) == 2 {
+		sendMsgToClient(conn, serializeBulkString(resultArr[0]))
+		return
+	}
+	msg, _, _ := serializeStringArray(resultArr)
+	sendMsgToClient(conn, msg)
+}
+
+// popElements pops up to count elements from ll and writes it back to key.
+// Must be called with store.mu held.
+func popElements(store *dictionary, key string, ll linkedList, front bool, count int64) []string {
+	count = min(count, int64(ll.length))
+	resultArr := make([]string, count)
+	for i := range resultArr {
+		if front {
+			resultArr[i] = ll.popFront()
+		} else {
+			resultArr[i] = ll.popBack()
+		}
+	}
+	setList(store, key, ll)
+	return resultArr
+}
+
+func handleLLen(arr []interface{}, conn *client, store *dictionary) {
+	ll, _, err := getList(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	msg, _, _ := serializeInteger(int64(ll.length))
+	sendMsgToClient(conn, msg)
+}
+
+func handleLRange(arr []interface{}, conn *client, store *dictionary) {
+	start, err := parseInteger(arr[2])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	stop, err := parseInteger(arr[3])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	ll, _, err := getList(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	from, to, ok := listRange(int(start), int(stop), int(ll.length))
+	if !ok {
+		msg, _, _ := serializeStringArray(nil)
+		sendMsgToClient(conn, msg)
+		return
+	}
+	resultArr := make([]string, 0, to-from+1)
+	for n := ll.nodeAt(from); len(resultArr) < cap(resultArr); n = n.next {
+		resultArr = append(resultArr, n.value)
+	}
+	msg, _, _ := serializeStringArray(resultArr)
+	sendMsgToClient(conn, msg)
+}
+
+func handleLIndex(arr []interface{}, conn *client, store *dictionary) {
+	index, err := parseInteger(arr[2])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	ll, _, err := getList(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	n := ll.nodeAt(int(index))
+	if n == nil {
+		sendMsgToClient(conn, conn.serializeNull())
+		return
+	}
+	sendMsgToClient(conn, serializeBulkString(n.value))
+}
+
+func handleLSet(arr []interface{}, conn *client, store *dictionary) {
+	index, err := parseInteger(arr[2])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	ll, ok, err := getList(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if !ok {
+		sendErrorToClient(conn, "ERR no such key")
+		return
+	}
+	n := ll.nodeAt(int(index))
+	if n == nil {
+		sendErrorToClient(conn, "ERR index out of range")
+		return
+	}
+	n.value = arr[3].(string)
+	signalModifiedKey(store, arr[1].(string))
+
+	msg, _ := serializeSimpleString("OK")
+	sendMsgToClient(conn, msg)
+}
+
+func handleLInsert(arr []interface{}, conn *client, store *dictionary) {
+	key := arr[1].(string)
+	where := strings.ToLower(arr[2].(string))
+	if where != "before" && where != "after" {
+		sendErrorToClient(conn, "ERR syntax error")
+		return
+	}
+	pivot, val := arr[3].(string), arr[4].(string)
+
+	ll, ok, err := getList(store, key)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if !ok {
+		msg, _, _ := serializeInteger(0)
+		sendMsgToClient(conn, msg)
+		return
+	}
+
+	n := ll.head
+	for n != nil && n.value != pivot {
+		n = n.next
+	}
+	if n == nil {
+		msg, _, _ := serializeInteger(-1)
+		sendMsgToClient(conn, msg)
+		return
+	}
+	if where == "before" {
+		ll.insertBefore(n, val)
+	} else {
+		ll.insertAfter(n, val)
+	}
+	setList(store, key, ll)
+
+	msg, _, _ := serializeInteger(int64(ll.length))
+	sendMsgToClient(conn, msg)
+}
+
+func handleLRem(arr []interface{}, conn *client, store *dictionary) {
+	key := arr[1].(string)
+	count, err := parseInteger(arr[2])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	val := arr[3].(string)
+
+	ll, _, err := getList(store, key)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	// A negative count removes from the tail, zero removes all occurrences.
+	removed := int64(0)
+	if count >= 0 {
+		for n := ll.head; n != nil && (count == 0 || removed < count); {
+			next := n.next
+			if n.value == val {
+				ll.remove(n)
+				removed++
+			}
+			n = next
+		}
+	} else {
+		for n := ll.tail; n != nil && removed < -count; {
+			prev := n.prev
+			if n.value == val {
+				ll.remove(n)
+				removed++
+			}
+			n = prev
+		}
+	}
+	if removed > 0 {
+		setList(store, key, ll)
+	}
+
+	msg, _, _ := serializeInteger(removed)
+	sendMsgToClient(conn, msg)
+}
+
+func handleLTrim(arr []interface{}, conn *client, store *dictionary) {
+	key := arr[1].(string)
+	start, err := parseInteger(arr[2])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	stop, err := parseInteger(arr[3])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	ll, ok, err := getList(store, key)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if ok {
+		from, to, ok := listRange(int(start), int(stop), int(ll.length))
+		if !ok {
+			ll = linkedList{}
+		} else {
+			for ll.length > uint(to+1) {
+				ll.popBack()
+			}
+			for i := 0; i < from; i++ {
+				ll.popFront()
+			}
+		}
+		setList(store, key, ll)
+	}
+
+	msg, _ := serializeSimpleString("OK")
+	sendMsgToClient(conn, msg)
+}
+
+func handleLPos(arr []interface{}, conn *client, store *dictionary) {
+	key, val := arr[1].(string), arr[2].(string)
+
+	rank, count, maxLen := int64(1), int64(-1), int64(0)
+	for i := 3; i < len(arr); i += 2 {
+		if i+1 >= len(arr) {
+			sendErrorToClient(conn, "ERR syntax error")
+			return
+		}
+		num, err := parseInteger(arr[i+1])
+		if err != nil {
+			sendErrorToClient(conn, err.Error())
+			return
+		}
+		switch strings.ToLower(arr[i].(string)) {
+		case "rank":
+			if num == 0 {
+				sendErrorToClient(conn, "ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the last match")
+				return
+			}
+			rank = num
+		case "count":
+			if num < 0 {
+				sendErrorToClient(conn, "ERR COUNT can't be negative")
+				return
+			}
+			count = num
+		case "maxlen":
+			if num < 0 {
+				sendErrorToClient(conn, "ERR MAXLEN can't be negative")
+				return
+			}
+			maxLen = num
+		default:
+			sendErrorToClient(conn, "ERR syntax error")
+			return
+		}
+	}
+
+	ll, _, err := getList(store, key)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	// A negative rank scans from the tail, skipping the first -rank-1 matches.
+	var matches []string
+	skip := rank - 1
+	n, index, step := ll.head, int64(0), int64(1)
+	if rank < 0 {
+		skip = -rank - 1
+		n, index, step = ll.tail, int64(ll.length)-1, -1
+	}
+	for scanned := int64(0); n != nil && (maxLen == 0 || scanned < maxLen); scanned++ {
+		if n.value == val {
+			if skip > 0 {
+				skip--
+			} else {
+				msg, _, _ := serializeInteger(index)
+				matches = append(matches, msg)
+				if count != 0 && int64(len(matches)) >= max(count, 1) {
+					break
+				}
+			}
+		}
+		if step > 0 {
+			n = n.next
+		} else {
+			n = n.prev
+		}
+		index += step
+	}
+
+	if count == -1 {
+		if len(matches) == 0 {
+			sendMsgToClient(conn, conn.serializeNull())
+			return
+		}
+		sendMsgToClient(conn, matches[0])
+		return
+	}
+	sendMsgToClient(conn, serializeArray(matches))
+}
+
+func handleLMove(arr []interface{}, conn *client, store *dictionary) {
+	from := strings.ToLower(arr[3].(string))
+	to := strings.ToLower(arr[4].(string))
+	if (from != "left" && from != "right") || (to != "left" && to != "right") {
+		sendErrorToClient(conn, "ERR syntax error")
+		return
+	}
+	moveGeneric(conn, store, arr[1].(string), arr[2].(string), from == "left", to == "left")
+}
+
+func handleRPopLPush(arr []interface{}, conn *client, store *dictionary) {
+	moveGeneric(conn, store, arr[1].(string), arr[2].(string), false, true)
+}
+
+// moveGeneric atomically pops an element from src and pushes it to dst. When
+// src and dst are the same list, this rotates it.
+func moveGeneric(conn *client, store *dictionary, src string, dst string, fromFront bool, toFront bool) {
+	val, ok, err := moveElement(store, src, dst, fromFront, toFront)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if !ok {
+		sendMsgToClient(conn, conn.serializeNull())
+		return
+	}
+	sendMsgToClient(conn, serializeBulkString(val))
+}
+
+// moveElement does the work of moveGeneric, ok is false when src doesn't
+// exist. Must be called with store.mu held.
+func moveElement(store *dictionary, src string, dst string, fromFront bool, toFront bool) (string, bool, error) {
+	srcList, ok, err := getList(store, src)
+	if err != nil || !ok {
+		return "", false, err
+	}
+	// Check the destination type before modifying anything.
+	if _, _, err := getList(store, dst); err != nil {
+		return "", false, err
+	}
+
+	var val string
+	if fromFront {
+		val = srcList.popFront()
+	} else {
+		val = srcList.popBack()
+	}
+
+	dstList := srcList
+	if src != dst {
+		setList(store, src, srcList)
+		dstList, _, _ = getList(store, dst)
+	}
+	if toFront {
+		dstList.pushFront(val)
+	} else {
+		dstList.pushBack(val)
+	}
+	setList(store, dst, dstList)
+	signalListReady(store, dst)
+
+	return val, true, nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// How long to wait before retrying a background save that failed.
+const bgsaveRetryDelay = 5 * time.Second
+
+// persistence is the snapshot state of a server.
+type persistence struct {
+	// Writes since the last successful snapshot.
+	dirty    int64
+	lastSave time.Time
+	// The last background save, and whether it succeeded.
+	lastBgsaveTry    time.Time
+	lastBgsaveOK     bool
+	bgsaveInProgress bool
+	// Set by BGSAVE SCHEDULE while another one is in progress.
+	bgsaveScheduled bool
+}
+
+func (srv *server) snapshotPath() string {
+	return filepath.Join(srv.cfg.dir, srv.cfg.dbfilename)
+}
+
+// snapshotDatabases collects the databases to write. A background save needs
+// a copy, since commands keep changing the databases while it writes. Copying
+// is much faster than encoding and writing, so writers only wait for the
+// copy. Strings are immutable and shared with the copy.
+// Must be called with srv.mu held.
+func snapshotDatabases(srv *server, copyValues bool) []snapshotDB {
+	dbs := make([]snapshotDB, len(srv.dbs))
+	for i, store := range srv.dbs {
+		dbs[i] = snapshotDB{id: store.id, dict: store.dict}
+		if !copyValues {
+			continue
+		}
+		dict := make(map[string]record, len(store.dict))
+		for key, rec := range store.dict {
+			dict[key] = record{value: cloneValue(rec.value), expiryTimestamp: rec.expiryTimestamp}
+		}
+		dbs[i].dict = dict
+	}
+	return dbs
+}
+
+// saveDone records the result of a snapshot started at start, when there were
+// dirty writes since the last one.
+// Must be called with srv.mu held.
+func saveDone(srv *server, err error, start time.Time, dirty int64) {
+	if err != nil {
+		log.Println("Error saving the snapshot:", err)
+		return
+	}
+	srv.persistence.dirty -= dirty
+	srv.persistence.lastSave = start
+	log.Println("Snapshot saved on disk.")
+}
+
+// startBackgroundSave copies the databases and writes them to disk from
+// another goroutine.
+// Must be called with srv.mu held.
+func startBackgroundSave(srv *server) {
+	p := &srv.persistence
+	start := time.Now()
+	dbs := snapshotDatabases(srv, true)
+	dirty := p.dirty
+	p.bgsaveInProgress = true
+	p.bgsaveScheduled = false
+	p.lastBgsaveTry = start
+	log.Printf("Background saving started, copying took %v.", time.Since(start))
+
+	go func() {
+		err := saveSnapshot(srv.snapshotPath(), dbs, srv.cfg.snapshotFormat)
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		p.bgsaveInProgress = false
+		p.lastBgsaveOK = err == nil
+		saveDone(srv, err, start, dirty)
+	}()
+}
+
+// snapshotCron takes a background snapshot whenever a save rule is met.
+func snapshotCron(srv *server) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		srv.mu.Lock()
+		if saveRuleMet(srv, now) {
+			startBackgroundSave(srv)
+		}
+		srv.mu.Unlock()
+	}
+}
+
+// saveRuleMet reports whether a snapshot is due.
+// Must be called with srv.mu held.
+func saveRuleMet(srv *server, now time.Time) bool {
+	p := &srv.persistence
+	if p.bgsaveInProgress || (!p.lastBgsaveOK && now.Sub(p.lastBgsaveTry) < bgsaveRetryDelay) {
+		return false
+	}
+	if p.bgsaveScheduled {
+		return true
+	}
+	for _, rule := range srv.cfg.saveRules {
+		if p.dirty >= rule.changes && p.dirty > 0 && now.Sub(p.lastSave) >= time.Duration(rule.seconds)*time.Second {
+			return true
+		}
+	}
+	return false
+}
+
+// handleSave writes the snapshot right away, every other client waits.
+func handleSave(arr []interface{}, conn *client, store *dictionary) {
+	srv := store.srv
+	if srv.persistence.bgsaveInProgress {
+		sendErrorToClient(conn, "ERR Background save already in progress")
+		return
+	}
+	start := time.Now()
+	dirty := srv.persistence.dirty
+	err := saveSnapshot(srv.snapshotPath(), snapshotDatabases(srv, false), srv.cfg.snapshotFormat)
+	saveDone(srv, err, start, dirty)
+	if err != nil {
+		sendErrorToClient(conn, "ERR "+err.Error())
+		return
+	}
+
+	msg, _ := serializeSimpleString("OK")
+	sendMsgToClient(conn, msg)
+}
+
+// handleBgsave starts a background save. With SCHEDULE, a save that can't
+// start yet because another one is in progress runs as soon as that's done.
+func handleBgsave(arr []interface{}, conn *client, store *dictionary) {
+	p := &store.srv.persistence
+	schedule := len(arr) == 2 && strings.ToLower(arr[1].(string)) == "schedule"
+	if len(arr) > 2 || (len(arr) == 2 && !schedule) {
+		sendErrorToClient(conn, "ERR syntax error")
+		return
+	}
+	if p.bgsaveInProgress {
+		if !schedule {
+			sendErrorToClient(conn, "ERR Background save already in progress")
+			return
+		}
+		p.bgsaveScheduled = true
+		msg, _ := serializeSimpleString("Background saving scheduled")
+		sendMsgToClient(conn, msg)
+		return
+	}
+	startBackgroundSave(store.srv)
+
+	msg, _ := serializeSimpleString("Background saving started")
+	sendMsgToClient(conn, msg)
+}
+
+func handleLastSave(arr []interface{}, conn *client, store *dictionary) {
+	msg, _, _ := serializeInteger(store.srv.persistence.lastSave.Unix())
+	sendMsgToClient(conn, msg)
+}
+
+func infoPersistence(srv *server) []string {
+	p := &srv.persistence
+	status := "ok"
+	if !p.lastBgsaveOK {
+		status = "err"
+	}
+	inProgress := 0
+	if p.bgsaveInProgress {
+		inProgress = 1
+	}
+	lines := []string{
+		"loading:0",
+		fmt.Sprintf("rdb_changes_since_last_save:%d", p.dirty),
+		fmt.Sprintf("rdb_bgsave_in_progress:%d", inProgress),
+		fmt.Sprintf("rdb_last_save_time:%d", p.lastSave.Unix()),
+		"rdb_last_bgsave_status:" + status,
+	}
+	return append(lines, infoAppendOnly(srv)...)
+}
+
+// loadDataFromDisk restores the keyspace at startup. The append-only file
+// has the most recent writes, so it is preferred over the snapshot when
+// enabled. The first time it is enabled, it starts out from the snapshot.
+// Must be called before clients are served.
+func loadDataFromDisk(srv *server) error {
+	start := time.Now()
+	if srv.cfg.appendOnly {
+		err := loadAppendOnlyFile(srv, srv.appendOnlyPath())
+		if err == nil {
+			log.Printf("Append-only file loaded in %v.", time.Since(start))
+			return openAppendOnlyFile(srv)
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	switch err := loadSnapshot(srv, srv.snapshotPath()); {
+	case err == nil:
+		log.Printf("Snapshot loaded in %v.", time.Since(start))
+	case !os.IsNotExist(err):
+		return err
+	}
+	if srv.cfg.appendOnly {
+		return openAppendOnlyFile(srv)
+	}
+	return nil
+}
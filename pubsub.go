@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// pubsub tracks which clients subscribe to which channels and patterns.
+// Sharded channels are a namespace of their own, without a cluster there is
+// a single shard and they behave like plain channels.
+type pubsub struct {
+	channels      map[string]map[*client]struct{}
+	patterns      map[string]map[*client]struct{}
+	shardChannels map[string]map[*client]struct{}
+}
+
+func newPubsub() *pubsub {
+	return &pubsub{
+		channels:      map[string]map[*client]struct{}{},
+		patterns:      map[string]map[*client]struct{}{},
+		shardChannels: map[string]map[*client]struct{}{},
+	}
+}
+
+// Commands a RESP2 client may still run while subscribed. Its connection
+// carries the messages, so there would be no telling replies apart.
+var subscribedCommands = map[string]bool{
+	"subscribe":    true,
+	"unsubscribe":  true,
+	"psubscribe":   true,
+	"punsubscribe": true,
+	"ssubscribe":   true,
+	"sunsubscribe": true,
+	"ping":         true,
+	"quit":         true,
+	"reset":        true,
+}
+
+// subscriptionKind describes channels, patterns or shard channels, with the
+// names used in their replies.
+type subscriptionKind struct {
+	subscribe   string
+	unsubscribe string
+	// The server's and the client's subscriptions of this kind.
+	serverSubs func(ps *pubsub) map[string]map[*client]struct{}
+	clientSubs func(c *client) map[string]struct{}
+	// count is the number of subscriptions reported in replies.
+	count func(c *client) int
+}
+
+var (
+	channelSubscriptions = &subscriptionKind{
+		subscribe:   "subscribe",
+		unsubscribe: "unsubscribe",
+		serverSubs:  func(ps *pubsub) map[string]map[*client]struct{} { return ps.channels },
+		clientSubs:  func(c *client) map[string]struct{} { return c.channels },
+		count:       (*client).subscriptions,
+	}
+	patternSubscriptions = &subscriptionKind{
+		subscribe:   "psubscribe",
+		unsubscribe: "punsubscribe",
+		serverSubs:  func(ps *pubsub) map[string]map[*client]struct{} { return ps.patterns },
+		clientSubs:  func(c *client) map[string]struct{} { return c.patterns },
+		count:       (*client).subscriptions,
+	}
+	shardSubscriptions = &subscriptionKind{
+		subscribe:   "ssubscribe",
+		unsubscribe: "sunsubscribe",
+		serverSubs:  func(ps *pubsub) map[string]map[*client]struct{} { return ps.shardChannels },
+		clientSubs:  func(c *client) map[string]struct{} { return c.shardChannels },
+		count:       func(c *client) int { return len(c.shardChannels) },
+	}
+)
+
+// subscriptions counts the channels and patterns the client subscribes to.
+func (c *client) subscriptions() int {
+	return len(c.channels) + len(c.patterns)
+}
+
+// subscribed reports whether the client is in subscribe mode.
+func (c *client) subscribed() bool {
+	return c.subscriptions()+len(c.shardChannels) > 0
+}
+
+// subscribe adds a subscription and replies with the client's count.
+// Must be called with store.mu held.
+func subscribe(ps *pubsub, conn *client, kind *subscriptionKind, name string) {
+	subs := kind.clientSubs(conn)
+	if _, ok := subs[name]; !ok {
+		subs[name] = struct{}{}
+		clients := kind.serverSubs(ps)[name]
+		if clients == nil {
+			clients = map[*client]struct{}{}
+			kind.serverSubs(ps)[name] = clients
+		}
+		clients[conn] = struct{}{}
+	}
+
+	count, _, _ := serializeInteger(int64(kind.count(conn)))
+	sendMsgToClient(conn, conn.serializePush([]string{serializeBulkString(kind.subscribe), serializeBulkString(name), count}))
+}
+
+// unsubscribe removes a subscription, replying with the client's count when
+// notify is set.
+// Must be called with store.mu held.
+func unsubscribe(ps *pubsub, conn *client, kind *subscriptionKind, name string, notify bool) {
+	subs := kind.clientSubs(conn)
+	if _, ok := subs[name]; ok {
+		delete(subs, name)
+		clients := kind.serverSubs(ps)[name]
+		delete(clients, conn)
+		if len(clients) == 0 {
+			delete(kind.serverSubs(ps), name)
+		}
+	}
+
+	if notify {
+		count, _, _ := serializeInteger(int64(kind.count(conn)))
+		sendMsgToClient(conn, conn.serializePush([]string{serializeBulkString(kind.unsubscribe), serializeBulkString(name), count}))
+	}
+}
+
+// unsubscribeAll removes every subscription of a kind. With notify set, it
+// replies for each of them, or once with a null name if there were none.
+// Must be called with store.mu held.
+func unsubscribeAll(ps *pubsub, conn *client, kind *subscriptionKind, notify bool) {
+	subs := kind.clientSubs(conn)
+	if len(subs) == 0 && notify {
+		count, _, _ := serializeInteger(int64(kind.count(conn)))
+		sendMsgToClient(conn, conn.serializePush([]string{serializeBulkString(kind.unsubscribe), conn.serializeNull(), count}))
+		return
+	}
+	for name := range subs {
+		unsubscribe(ps, conn, kind, name, notify)
+	}
+}
+
+// freePubsubClient drops all subscriptions of a disconnecting client.
+// Must be called with store.mu held.
+func freePubsubClient(ps *pubsub, conn *client) {
+	unsubscribeAll(ps, conn, channelSubscriptions, false)
+	unsubscribeAll(ps, conn, patternSubscriptions, false)
+	unsubscribeAll(ps, conn, shardSubscriptions, false)
+}
+
+// publish delivers a message to the subscribers of channel, and for plain
+// channels also to the clients with a matching pattern. Returns the number of
+// clients that received it.
+// Must be called with store.mu held.
+func publish(srv *server, kind *subscriptionKind, channel string, message string) int64 {
+	limit := srv.cfg.pubsubOutputLimit
+	msgType := "message"
+	if kind == shardSubscriptions {
+		msgType = "smessage"
+	}
+
+	var receivers int64
+	for c := range kind.serverSubs(srv.pubsub)[channel] {
+		c.push(c.serializePush([]string{serializeBulkString(msgType), serializeBulkString(channel), serializeBulkString(message)}), limit)
+		receivers++
+	}
+	if kind != channelSubscriptions {
+		return receivers
+	}
+	for pattern, clients := range srv.pubsub.patterns {
+		if !globMatch(pattern, channel) {
+			continue
+		}
+		for c := range clients {
+			c.push(c.serializePush([]string{serializeBulkString("pmessage"), serializeBulkString(pattern),
+				serializeBulkString(channel), serializeBulkString(message)}), limit)
+			receivers++
+		}
+	}
+	return receivers
+}
+
+func handleSubscribe(arr []interface{}, conn *client, store *dictionary) {
+	for _, name := range arr[1:] {
+		subscribe(store.srv.pubsub, conn, channelSubscriptions, name.(string))
+	}
+}
+
+func handlePSubscribe(arr []interface{}, conn *client, store *dictionary) {
+	for _, name := range arr[1:] {
+		subscribe(store.srv.pubsub, conn, patternSubscriptions, name.(string))
+	}
+}
+
+func handleSSubscribe(arr []interface{}, conn *client, store *dictionary) {
+	for _, name := range arr[1:] {
+		subscribe(store.srv.pubsub, conn, shardSubscriptions, name.(string))
+	}
+}
+
+func handleUnsubscribe(arr []interface{}, conn *client, store *dictionary) {
+	unsubscribeGeneric(arr, conn, store, channelSubscriptions)
+}
+
+func handlePUnsubscribe(arr []interface{}, conn *client, store *dictionary) {
+	unsubscribeGeneric(arr, conn, store, patternSubscriptions)
+}
+
+func handleSUnsubscribe(arr []interface{}, conn *client, store *dictionary) {
+	unsubscribeGeneric(arr, conn, store, shardSubscriptions)
+}
+
+// unsubscribeGeneric unsubscribes from the given names, or from everything of
+// the kind when there are none.
+func unsubscribeGeneric(arr []interface{}, conn *client, store *dictionary, kind *subscriptionKind) {
+	if len(arr) == 1 {
+		unsubscribeAll(store.srv.pubsub, conn, kind, true)
+		return
+	}
+	for _, name := range arr[1:] {
+		unsubscribe(store.srv.pubsub, conn, kind, name.(string), true)
+	}
+}
+
+func handlePublish(arr []interface{}, conn *client, store *dictionary) {
+	receivers := publish(store.srv, channelSubscriptions, arr[1].(string), arr[2].(string))
+	msg, _, _ := serializeInteger(receivers)
+	sendMsgToClient(conn, msg)
+}
+
+func handleSPublish(arr []interface{}, conn *client, store *dictionary) {
+	receivers := publish(store.srv, shardSubscriptions, arr[1].(string), arr[2].(string))
+	msg, _, _ := serializeInteger(receivers)
+	sendMsgToClient(conn, msg)
+}
+
+// handlePubsub implements the PUBSUB introspection subcommands.
+func handlePubsub(arr []interface{}, conn *client, store *dictionary) {
+	ps := store.srv.pubsub
+	switch sub := strings.ToLower(arr[1].(string)); sub {
+	case "channels", "shardchannels":
+		if len(arr) > 3 {
+			sendArityError(conn, "pubsub|"+sub)
+			return
+		}
+		subs := ps.channels
+		if sub == "shardchannels" {
+			subs = ps.shardChannels
+		}
+		names := []string{}
+		for name := range subs {
+			if len(arr) == 2 || globMatch(arr[2].(string), name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		msg, _, _ := serializeStringArray(names)
+		sendMsgToClient(conn, msg)
+	case "numsub", "shardnumsub":
+		subs := ps.channels
+		if sub == "shardnumsub" {
+			subs = ps.shardChannels
+		}
+		pairs := make([]string, 0, 2*(len(arr)-2))
+		for _, name := range arr[2:] {
+			count, _, _ := serializeInteger(int64(len(subs[name.(string)])))
+			pairs = append(pairs, serializeBulkString(name.(string)), count)
+		}
+		sendMsgToClient(conn, conn.serializeMap(pairs))
+	case "numpat":
+		if len(arr) != 2 {
+			sendArityError(conn, "pubsub|numpat")
+			return
+		}
+		msg, _, _ := serializeInteger(int64(len(ps.patterns)))
+		sendMsgToClient(conn, msg)
+	default:
+		sendErrorToClient(conn, fmt.Sprintf("ERR unknown subcommand '%.128s'. Try PUBSUB HELP.", arr[1]))
+	}
+}
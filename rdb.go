@@ -0,0 +1,936 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"math"
+	"math/bits"
+	"strconv"
+	"time"
+)
+
+// RDB files are the snapshots of Redis itself. Reading them lets us start
+// from a snapshot taken in production, writing them moves data back:
+//
+//	"REDIS" version
+//	{ opAux name value | opSelectDB db | opResizeDB size expires |
+//	  [opExpireTimeMs unix-ms] type key value }
+//	opEOF checksum
+//
+// Every encoding Redis 7.4 writes for strings, lists, sets, sorted sets and
+// hashes is read, including LZF compressed strings, ziplists, listpacks and
+// intsets. Streams, modules and hash field expiries have no counterpart here
+// and fail the load. Files are written with the plain encodings, which every
+// version since RDB 9 reads.
+
+const (
+	rdbMagic = "REDIS"
+	// Redis 7.2 writes version 11, 7.4 writes 12.
+	rdbVersion    = 11
+	rdbMaxVersion = 12
+)
+
+// Value types.
+const (
+	rdbTypeString          byte = 0
+	rdbTypeList            byte = 1
+	rdbTypeSet             byte = 2
+	rdbTypeZSet            byte = 3
+	rdbTypeHash            byte = 4
+	rdbTypeZSet2           byte = 5
+	rdbTypeHashZipmap      byte = 9
+	rdbTypeListZiplist     byte = 10
+	rdbTypeSetIntset       byte = 11
+	rdbTypeZSetZiplist     byte = 12
+	rdbTypeHashZiplist     byte = 13
+	rdbTypeListQuicklist   byte = 14
+	rdbTypeHashListpack    byte = 16
+	rdbTypeZSetListpack    byte = 17
+	rdbTypeListQuicklist2  byte = 18
+	rdbTypeSetListpack     byte = 20
+	rdbQuicklistNodePlain       = 1
+	rdbQuicklistNodePacked      = 2
+)
+
+// Opcodes.
+const (
+	rdbOpSlotInfo     byte = 0xf4
+	rdbOpFunction2    byte = 0xf5
+	rdbOpModuleAux    byte = 0xf7
+	rdbOpIdle         byte = 0xf8
+	rdbOpFreq         byte = 0xf9
+	rdbOpAux          byte = 0xfa
+	rdbOpResizeDB     byte = 0xfb
+	rdbOpExpireTimeMs byte = 0xfc
+	rdbOpExpireTime   byte = 0xfd
+	rdbOpSelectDB     byte = 0xfe
+	rdbOpEOF          byte = 0xff
+)
+
+// Lengths whose two high bits are set are special string encodings.
+const (
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+	rdbEncLZF   = 3
+)
+
+// isRDBHeader reports whether a file starting with header is a Redis RDB file.
+func isRDBHeader(header []byte) bool {
+	if len(header) != len(rdbMagic)+4 || string(header[:len(rdbMagic)]) != rdbMagic {
+		return false
+	}
+	_, err := strconv.ParseUint(string(header[len(rdbMagic):]), 10, 16)
+	return err == nil
+}
+
+// The checksum is the "Jones" variant of CRC-64, computed without inverting
+// the input and output the way hash/crc64 does.
+var rdbCRCTable = crc64.MakeTable(bits.Reverse64(0xad93d23594c935a9))
+
+// rdbChecksum is the running RDB checksum of the bytes written to it.
+type rdbChecksum uint64
+
+func (c *rdbChecksum) Write(p []byte) (int, error) {
+	*c = rdbChecksum(^crc64.Update(^uint64(*c), rdbCRCTable, p))
+	return len(p), nil
+}
+
+func (c *rdbChecksum) Sum64() uint64 {
+	return uint64(*c)
+}
+
+// rdbEncoder writes an RDB file, checksumming everything it writes.
+type rdbEncoder struct {
+	w   *bufio.Writer
+	out io.Writer
+	crc rdbChecksum
+	buf [9]byte
+}
+
+func newRDBEncoder(w io.Writer) *rdbEncoder {
+	e := &rdbEncoder{out: w}
+	e.w = bufio.NewWriter(io.MultiWriter(w, &e.crc))
+	return e
+}
+
+// Errors are sticky in the bufio.Writer, encode reports them when flushing.
+
+// writeLength writes n in the variable length encoding of RDB.
+func (e *rdbEncoder) writeLength(n uint64) {
+	switch {
+	case n < 1<<6:
+		e.w.WriteByte(byte(n))
+	case n < 1<<14:
+		e.w.Write([]byte{0x40 | byte(n>>8), byte(n)})
+	case n <= math.MaxUint32:
+		e.buf[0] = 0x80
+		binary.BigEndian.PutUint32(e.buf[1:], uint32(n))
+		e.w.Write(e.buf[:5])
+	default:
+		e.buf[0] = 0x81
+		binary.BigEndian.PutUint64(e.buf[1:], n)
+		e.w.Write(e.buf[:9])
+	}
+}
+
+func (e *rdbEncoder) writeString(s string) {
+	e.writeLength(uint64(len(s)))
+	e.w.WriteString(s)
+}
+
+func (e *rdbEncoder) writeRecord(key string, rec record) {
+	if rec.expiryTimestamp != -1 {
+		e.w.WriteByte(rdbOpExpireTimeMs)
+		e.w.Write(binary.LittleEndian.AppendUint64(e.buf[:0], uint64(rec.expiryTimestamp)))
+	}
+
+	switch v := rec.value.(type) {
+	case string:
+		e.w.WriteByte(rdbTypeString)
+		e.writeString(key)
+		e.writeString(v)
+	case linkedList:
+		e.w.WriteByte(rdbTypeList)
+		e.writeString(key)
+		e.writeLength(uint64(v.length))
+		for n := v.head; n != nil; n = n.next {
+			e.writeString(n.value)
+		}
+	case *set:
+		e.w.WriteByte(rdbTypeSet)
+		e.writeString(key)
+		members := v.list()
+		e.writeLength(uint64(len(members)))
+		for _, member := range members {
+			e.writeString(member)
+		}
+	case *sortedSet:
+		e.w.WriteByte(rdbTypeZSet2)
+		e.writeString(key)
+		e.writeLength(uint64(v.size()))
+		// Redis loads a sorted set fastest highest score first.
+		for x := v.zsl.tail; x != nil; x = x.backward {
+			e.writeString(x.member)
+			e.w.Write(binary.LittleEndian.AppendUint64(e.buf[:0], math.Float64bits(x.score)))
+		}
+	case *hash:
+		e.w.WriteByte(rdbTypeHash)
+		e.writeString(key)
+		e.writeLength(uint64(v.size()))
+		for field, val := range v.fields {
+			e.writeString(field)
+			e.writeString(val)
+		}
+	}
+}
+
+// encode writes a complete RDB file of dbs.
+func (e *rdbEncoder) encode(dbs []snapshotDB) error {
+	fmt.Fprintf(e.w, "%s%04d", rdbMagic, rdbVersion)
+	aux := []string{
+		"redis-ver", serverVersion,
+		"redis-bits", strconv.Itoa(bits.UintSize),
+		"ctime", strconv.FormatInt(time.Now().Unix(), 10),
+	}
+	for i := 0; i < len(aux); i += 2 {
+		e.w.WriteByte(rdbOpAux)
+		e.writeString(aux[i])
+		e.writeString(aux[i+1])
+	}
+
+	for _, db := range dbs {
+		if len(db.dict) == 0 {
+			continue
+		}
+		expires := 0
+		for _, rec := range db.dict {
+			if rec.expiryTimestamp != -1 {
+				expires++
+			}
+		}
+		e.w.WriteByte(rdbOpSelectDB)
+		e.writeLength(uint64(db.id))
+		e.w.WriteByte(rdbOpResizeDB)
+		e.writeLength(uint64(len(db.dict)))
+		e.writeLength(uint64(expires))
+		for key, rec := range db.dict {
+			e.writeRecord(key, rec)
+		}
+	}
+	e.w.WriteByte(rdbOpEOF)
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+
+	// The checksum doesn't cover itself.
+	_, err := e.out.Write(binary.LittleEndian.AppendUint64(nil, uint64(e.crc)))
+	return err
+}
+
+// rdbDecoder reads an RDB file.
+type rdbDecoder struct {
+	snapshotDecoder
+	version int
+}
+
+func newRDBDecoder(r io.Reader) *rdbDecoder {
+	return &rdbDecoder{snapshotDecoder: snapshotDecoder{r: bufio.NewReader(r), crc: new(rdbChecksum)}}
+}
+
+// readLength reads a length. encoded reports a special string encoding, in
+// which case the length is the encoding.
+func (d *rdbDecoder) readLength() (n uint64, encoded bool, err error) {
+	b, err := d.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3f), false, nil
+	case 1:
+		next, err := d.ReadByte()
+		return uint64(b&0x3f)<<8 | uint64(next), false, err
+	case 3:
+		return uint64(b & 0x3f), true, nil
+	}
+	switch b {
+	case 0x80:
+		buf, err := d.readFull(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(buf)), false, nil
+	case 0x81:
+		buf, err := d.readFull(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(buf), false, nil
+	}
+	return 0, false, fmt.Errorf("unknown length encoding 0x%02x", b)
+}
+
+// readPlainLength reads a length that can't be a string encoding.
+func (d *rdbDecoder) readPlainLength() (uint64, error) {
+	n, encoded, err := d.readLength()
+	if err == nil && encoded {
+		err = errors.New("unexpected string encoding")
+	}
+	return n, err
+}
+
+func (d *rdbDecoder) readString() (string, error) {
+	n, encoded, err := d.readLength()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		buf, err := d.readFull(n)
+		return string(buf), err
+	}
+
+	switch n {
+	case rdbEncInt8, rdbEncInt16, rdbEncInt32:
+		buf, err := d.readFull(1 << n)
+		if err != nil {
+			return "", err
+		}
+		var num int64
+		switch n {
+		case rdbEncInt8:
+			num = int64(int8(buf[0]))
+		case rdbEncInt16:
+			num = int64(int16(binary.LittleEndian.Uint16(buf)))
+		default:
+			num = int64(int32(binary.LittleEndian.Uint32(buf)))
+		}
+		return strconv.FormatInt(num, 10), nil
+	case rdbEncLZF:
+		clen, err := d.readPlainLength()
+		if err != nil {
+			return "", err
+		}
+		length, err := d.readPlainLength()
+		if err != nil {
+			return "", err
+		}
+		if length > maxBulkLength {
+			return "", fmt.Errorf("length %d too large", length)
+		}
+		compressed, err := d.readFull(clen)
+		if err != nil {
+			return "", err
+		}
+		return lzfDecompress(compressed, int(length))
+	}
+	return "", fmt.Errorf("unknown string encoding %d", n)
+}
+
+// readScore reads a sorted set score of the old ZSET type, a double as
+// text.
+func (d *rdbDecoder) readScore() (float64, error) {
+	n, err := d.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf, err := d.readFull(uint64(n))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(buf), 64)
+}
+
+// readStrings reads n strings, the elements of a plainly encoded list or set.
+func (d *rdbDecoder) readStrings(n uint64, fn func(s string)) error {
+	for i := uint64(0); i < n; i++ {
+		s, err := d.readString()
+		if err != nil {
+			return err
+		}
+		fn(s)
+	}
+	return nil
+}
+
+// readBlob reads a string holding a ziplist, listpack or intset and calls fn
+// for each of its elements.
+func (d *rdbDecoder) readBlob(parse func(blob []byte, fn func(s string)) error, fn func(s string)) error {
+	blob, err := d.readString()
+	if err != nil {
+		return err
+	}
+	return parse([]byte(blob), fn)
+}
+
+// pairs collects elements into pairs, the fields and values of a hash or the
+// members and scores of a sorted set.
+type pairs struct {
+	first []string
+	err   error
+	// Called with every pair.
+	fn func(a, b string)
+}
+
+func (p *pairs) add(s string) {
+	p.first = append(p.first, s)
+	if len(p.first) == 2 {
+		p.fn(p.first[0], p.first[1])
+		p.first = p.first[:0]
+	}
+}
+
+func (p *pairs) done() error {
+	if len(p.first) != 0 {
+		return errors.New("odd number of elements")
+	}
+	return nil
+}
+
+// readValue reads a value of the given type.
+func (d *rdbDecoder) readValue(valueType byte) (interface{}, error) {
+	switch valueType {
+	case rdbTypeString:
+		return d.readString()
+
+	case rdbTypeList, rdbTypeListZiplist, rdbTypeListQuicklist, rdbTypeListQuicklist2:
+		var ll linkedList
+		push := func(s string) { ll.pushBack(s) }
+		if valueType == rdbTypeListZiplist {
+			return ll, d.readBlob(parseZiplist, push)
+		}
+		n, err := d.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		if valueType == rdbTypeList {
+			return ll, d.readStrings(n, push)
+		}
+		// Quicklists are a list of nodes.
+		for i := uint64(0); i < n; i++ {
+			if valueType == rdbTypeListQuicklist {
+				err = d.readBlob(parseZiplist, push)
+			} else {
+				err = d.readQuicklistNode(push)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return ll, nil
+
+	case rdbTypeSet, rdbTypeSetIntset, rdbTypeSetListpack:
+		s := newSet()
+		add := func(member string) { s.add(member) }
+		switch valueType {
+		case rdbTypeSetIntset:
+			return s, d.readBlob(parseIntset, add)
+		case rdbTypeSetListpack:
+			return s, d.readBlob(parseListpack, add)
+		}
+		n, err := d.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		return s, d.readStrings(n, add)
+
+	case rdbTypeZSet, rdbTypeZSet2:
+		z := newSortedSet()
+		n, err := d.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < n; i++ {
+			member, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			var score float64
+			if valueType == rdbTypeZSet {
+				score, err = d.readScore()
+			} else {
+				var buf []byte
+				buf, err = d.readFull(8)
+				if err == nil {
+					score = math.Float64frombits(binary.LittleEndian.Uint64(buf))
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+			if math.IsNaN(score) {
+				return nil, errors.New("sorted set score is NaN")
+			}
+			z.set(member, score)
+		}
+		return z, nil
+
+	case rdbTypeZSetZiplist, rdbTypeZSetListpack:
+		z := newSortedSet()
+		p := &pairs{}
+		p.fn = func(member, score string) {
+			num, err := strconv.ParseFloat(score, 64)
+			if err != nil || math.IsNaN(num) {
+				p.err = fmt.Errorf("invalid score '%s'", score)
+				return
+			}
+			z.set(member, num)
+		}
+		parse := parseZiplist
+		if valueType == rdbTypeZSetListpack {
+			parse = parseListpack
+		}
+		if err := d.readBlob(parse, p.add); err != nil {
+			return nil, err
+		}
+		if p.err != nil {
+			return nil, p.err
+		}
+		return z, p.done()
+
+	case rdbTypeHash:
+		n, err := d.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		h := newHash()
+		for i := uint64(0); i < n; i++ {
+			field, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			val, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			h.set(field, val)
+		}
+		return h, nil
+
+	case rdbTypeHashZiplist, rdbTypeHashListpack:
+		h := newHash()
+		p := &pairs{fn: func(field, val string) { h.set(field, val) }}
+		parse := parseZiplist
+		if valueType == rdbTypeHashListpack {
+			parse = parseListpack
+		}
+		if err := d.readBlob(parse, p.add); err != nil {
+			return nil, err
+		}
+		return h, p.done()
+
+	case rdbTypeHashZipmap:
+		return nil, errors.New("zipmap encoded hashes predate RDB 4 and are not supported")
+	}
+	return nil, fmt.Errorf("unsupported value type %d", valueType)
+}
+
+// readQuicklistNode reads a node of the quicklist of RDB 10 and later, a
+// listpack or, for large elements, a single plain one.
+func (d *rdbDecoder) readQuicklistNode(fn func(s string)) error {
+	container, err := d.readPlainLength()
+	if err != nil {
+		return err
+	}
+	switch container {
+	case rdbQuicklistNodePlain:
+		s, err := d.readString()
+		if err == nil {
+			fn(s)
+		}
+		return err
+	case rdbQuicklistNodePacked:
+		return d.readBlob(parseListpack, fn)
+	}
+	return fmt.Errorf("unknown quicklist node container %d", container)
+}
+
+// decode reads a whole RDB file, calling fn for every record. It stops at the
+// first error, returned as a *snapshotError unless fn returned it.
+func (d *rdbDecoder) decode(fn func(db int, key string, rec record) error) error {
+	start := d.offset
+	fail := func(err error) error {
+		return &snapshotError{offset: start, err: err}
+	}
+
+	header, err := d.readFull(uint64(len(rdbMagic) + 4))
+	if err != nil {
+		return fail(err)
+	}
+	if !isRDBHeader(header) {
+		return fail(errors.New("not an RDB file"))
+	}
+	d.version, _ = strconv.Atoi(string(header[len(rdbMagic):]))
+	if d.version < 1 || d.version > rdbMaxVersion {
+		return fail(fmt.Errorf("unsupported RDB version %d", d.version))
+	}
+
+	db := 0
+	expiryTimestamp := int64(-1)
+	for {
+		// A record starts with its expiry and access opcodes.
+		if expiryTimestamp == -1 {
+			start = d.offset
+		}
+		op, err := d.ReadByte()
+		if err != nil {
+			return fail(err)
+		}
+
+		switch op {
+		case rdbOpEOF:
+			// Checksums were added in version 5, zero means disabled.
+			if d.version < 5 {
+				return nil
+			}
+			expected := d.crc.Sum64()
+			buf, err := d.readFull(8)
+			if err != nil {
+				return fail(err)
+			}
+			if sum := binary.LittleEndian.Uint64(buf); sum != 0 && sum != expected {
+				return fail(errSnapshotChecksum)
+			}
+			return nil
+		case rdbOpSelectDB:
+			id, err := d.readPlainLength()
+			if err != nil {
+				return fail(err)
+			}
+			db = int(id)
+			continue
+		case rdbOpResizeDB:
+			_, err := d.readPlainLength()
+			if err == nil {
+				_, err = d.readPlainLength()
+			}
+			if err != nil {
+				return fail(err)
+			}
+			continue
+		case rdbOpSlotInfo:
+			for i := 0; i < 3 && err == nil; i++ {
+				_, err = d.readPlainLength()
+			}
+			if err != nil {
+				return fail(err)
+			}
+			continue
+		case rdbOpAux:
+			_, err := d.readString()
+			if err == nil {
+				_, err = d.readString()
+			}
+			if err != nil {
+				return fail(err)
+			}
+			continue
+		case rdbOpFunction2:
+			// Function libraries, which we can't run.
+			if _, err := d.readString(); err != nil {
+				return fail(err)
+			}
+			continue
+		case rdbOpModuleAux:
+			return fail(errors.New("module data is not supported"))
+		case rdbOpExpireTime:
+			buf, err := d.readFull(4)
+			if err != nil {
+				return fail(err)
+			}
+			expiryTimestamp = int64(int32(binary.LittleEndian.Uint32(buf))) * 1000
+			continue
+		case rdbOpExpireTimeMs:
+			buf, err := d.readFull(8)
+			if err != nil {
+				return fail(err)
+			}
+			expiryTimestamp = int64(binary.LittleEndian.Uint64(buf))
+			continue
+		case rdbOpIdle:
+			// LRU and LFU information, not kept here.
+			if _, err := d.readPlainLength(); err != nil {
+				return fail(err)
+			}
+			continue
+		case rdbOpFreq:
+			if _, err := d.ReadByte(); err != nil {
+				return fail(err)
+			}
+			continue
+		}
+
+		key, err := d.readString()
+		if err != nil {
+			return fail(err)
+		}
+		value, err := d.readValue(op)
+		if err != nil {
+			return fail(err)
+		}
+		if err := fn(db, key, record{value: value, expiryTimestamp: expiryTimestamp}); err != nil {
+			return err
+		}
+		expiryTimestamp = -1
+	}
+}
+
+// lzfDecompress expands LZF compressed data to its length.
+func lzfDecompress(in []byte, length int) (string, error) {
+	out := make([]byte, 0, length)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 1<<5 {
+			// A literal run of ctrl+1 bytes.
+			n := ctrl + 1
+			if i+n > len(in) || len(out)+n > length {
+				return "", errors.New("corrupt LZF data")
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+
+		// A back reference, copied byte by byte since it may overlap.
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return "", errors.New("corrupt LZF data")
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return "", errors.New("corrupt LZF data")
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		n += 2
+		if ref < 0 || len(out)+n > length {
+			return "", errors.New("corrupt LZF data")
+		}
+		for j := 0; j < n; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != length {
+		return "", errors.New("corrupt LZF data")
+	}
+	return string(out), nil
+}
+
+var errBlobCorrupt = errors.New("corrupt ziplist, listpack or intset")
+
+// parseZiplist calls fn for every element of a ziplist, the compact encoding
+// of small lists, hashes and sorted sets up to RDB 9:
+//
+//	zlbytes(4) zltail(4) zllen(2) { prevlen encoding data } 0xff
+func parseZiplist(blob []byte, fn func(s string)) error {
+	if len(blob) < 11 {
+		return errBlobCorrupt
+	}
+	i := 10
+	for {
+		if i >= len(blob) {
+			return errBlobCorrupt
+		}
+		if blob[i] == 0xff {
+			return nil
+		}
+		// The length of the previous entry, to walk backwards.
+		if blob[i] == 0xfe {
+			i += 5
+		} else {
+			i++
+		}
+		if i >= len(blob) {
+			return errBlobCorrupt
+		}
+
+		enc := blob[i]
+		i++
+		var n int
+		switch enc >> 6 {
+		case 0:
+			n = int(enc & 0x3f)
+		case 1:
+			if i+1 > len(blob) {
+				return errBlobCorrupt
+			}
+			n = int(enc&0x3f)<<8 | int(blob[i])
+			i++
+		case 2:
+			if i+4 > len(blob) {
+				return errBlobCorrupt
+			}
+			n = int(binary.BigEndian.Uint32(blob[i:]))
+			i += 4
+		default:
+			// Integers.
+			var size int
+			switch enc {
+			case 0xc0:
+				size = 2
+			case 0xd0:
+				size = 4
+			case 0xe0:
+				size = 8
+			case 0xf0:
+				size = 3
+			case 0xfe:
+				size = 1
+			default:
+				if enc < 0xf1 || enc > 0xfd {
+					return errBlobCorrupt
+				}
+				// A 4 bit immediate from 1 to 13, standing for 0 to 12.
+				fn(strconv.Itoa(int(enc&0x0f) - 1))
+				continue
+			}
+			if i+size > len(blob) {
+				return errBlobCorrupt
+			}
+			fn(strconv.FormatInt(littleEndianInt(blob[i:i+size]), 10))
+			i += size
+			continue
+		}
+
+		if n < 0 || i+n > len(blob) {
+			return errBlobCorrupt
+		}
+		fn(string(blob[i : i+n]))
+		i += n
+	}
+}
+
+// parseListpack calls fn for every element of a listpack, the compact
+// encoding that replaced ziplists in RDB 10:
+//
+//	total-bytes(4) num-elements(2) { encoding data backlen } 0xff
+func parseListpack(blob []byte, fn func(s string)) error {
+	if len(blob) < 7 {
+		return errBlobCorrupt
+	}
+	i := 6
+	for {
+		if i >= len(blob) {
+			return errBlobCorrupt
+		}
+		start := i
+		enc := blob[i]
+		i++
+
+		var str int = -1
+		var num int64
+		switch {
+		case enc == 0xff:
+			return nil
+		case enc&0x80 == 0:
+			// 7 bit unsigned integer.
+			num = int64(enc)
+		case enc&0xc0 == 0x80:
+			str = int(enc & 0x3f)
+		case enc&0xe0 == 0xc0:
+			// 13 bit signed integer.
+			if i >= len(blob) {
+				return errBlobCorrupt
+			}
+			num = int64(enc&0x1f)<<8 | int64(blob[i])
+			if num >= 1<<12 {
+				num -= 1 << 13
+			}
+			i++
+		case enc&0xf0 == 0xe0:
+			if i >= len(blob) {
+				return errBlobCorrupt
+			}
+			str = int(enc&0x0f)<<8 | int(blob[i])
+			i++
+		case enc == 0xf0:
+			if i+4 > len(blob) {
+				return errBlobCorrupt
+			}
+			str = int(binary.LittleEndian.Uint32(blob[i:]))
+			i += 4
+		case enc >= 0xf1 && enc <= 0xf4:
+			size := []int{2, 3, 4, 8}[enc-0xf1]
+			if i+size > len(blob) {
+				return errBlobCorrupt
+			}
+			num = littleEndianInt(blob[i : i+size])
+			i += size
+		default:
+			return errBlobCorrupt
+		}
+
+		if str >= 0 {
+			if i+str > len(blob) {
+				return errBlobCorrupt
+			}
+			fn(string(blob[i : i+str]))
+			i += str
+		} else {
+			fn(strconv.FormatInt(num, 10))
+		}
+		// The entry length again, to walk backwards.
+		i += listpackBacklenSize(i - start)
+	}
+}
+
+// listpackBacklenSize is the size of the backlen of an entry of n bytes.
+func listpackBacklenSize(n int) int {
+	switch {
+	case n <= 127:
+		return 1
+	case n < 16383:
+		return 2
+	case n < 2097151:
+		return 3
+	case n < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// parseIntset calls fn for every member of an intset, the encoding of small
+// sets of integers:
+//
+//	encoding(4) length(4) { integer }
+func parseIntset(blob []byte, fn func(s string)) error {
+	if len(blob) < 8 {
+		return errBlobCorrupt
+	}
+	size := int(binary.LittleEndian.Uint32(blob))
+	n := int(binary.LittleEndian.Uint32(blob[4:]))
+	if (size != 2 && size != 4 && size != 8) || n < 0 || len(blob) != 8+n*size {
+		return errBlobCorrupt
+	}
+	for i := 8; i < len(blob); i += size {
+		fn(strconv.FormatInt(littleEndianInt(blob[i:i+size]), 10))
+	}
+	return nil
+}
+
+// littleEndianInt decodes a signed little endian integer of 1 to 8 bytes.
+func littleEndianInt(b []byte) int64 {
+	var u uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		u = u<<8 | uint64(b[i])
+	}
+	// Sign extend.
+	shift := 64 - 8*len(b)
+	return int64(u<<shift) >> shift
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRDBChecksum(t *testing.T) {
+	// The check value of the Jones CRC-64.
+	var crc rdbChecksum
+	crc.Write([]byte("1234"))
+	crc.Write([]byte("56789"))
+	if crc.Sum64() != 0xe9c6d914c4b8d9ca {
+		t.Errorf("Expected '0xe9c6d914c4b8d9ca' but got '%#x'", crc.Sum64())
+	}
+}
+
+// rdbFile wraps records in an RDB file as Redis writes it.
+func rdbFile(records ...[]byte) []byte {
+	data := []byte("REDIS0011")
+	data = append(data, rdbOpAux, 9)
+	data = append(data, "redis-ver"...)
+	data = append(data, 5)
+	data = append(data, "7.2.4"...)
+	data = append(data, rdbOpSelectDB, 0, rdbOpResizeDB, byte(len(records)), 0)
+	for _, rec := range records {
+		data = append(data, rec...)
+	}
+	data = append(data, rdbOpEOF)
+	var crc rdbChecksum
+	crc.Write(data)
+	return binary.LittleEndian.AppendUint64(data, crc.Sum64())
+}
+
+// rdbRecord returns a record of key "k" with a value of the given type.
+func rdbRecord(valueType byte, value ...byte) []byte {
+	return append([]byte{valueType, 1, 'k'}, value...)
+}
+
+// rdbBlob returns a string holding a ziplist, listpack or intset.
+func rdbBlob(blob ...byte) []byte {
+	return append([]byte{byte(len(blob))}, blob...)
+}
+
+func decodeRDB(data []byte) (map[int]map[string]record, error) {
+	got := map[int]map[string]record{}
+	err := newRDBDecoder(bytes.NewReader(data)).decode(func(db int, key string, rec record) error {
+		if got[db] == nil {
+			got[db] = map[string]record{}
+		}
+		got[db][key] = rec
+		return nil
+	})
+	return got, err
+}
+
+func TestRDBRoundTrip(t *testing.T) {
+	dbs := testDatabases()
+	var buf bytes.Buffer
+	if err := newRDBEncoder(&buf).encode(dbs); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := decodeRDB(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, db := range dbs {
+		for key, want := range db.dict {
+			rec, ok := got[db.id][key]
+			if !ok {
+				t.Errorf("Missing '%s' in db %d.", key, db.id)
+				continue
+			}
+			if rec.expiryTimestamp != want.expiryTimestamp {
+				t.Errorf("Got expiry '%d' for '%s' but expected '%d'.", rec.expiryTimestamp, key, want.expiryTimestamp)
+			}
+			if !reflect.DeepEqual(comparable(rec.value), comparable(want.value)) {
+				t.Errorf("Got '%v' for '%s' but expected '%v'.", comparable(rec.value), key, comparable(want.value))
+			}
+		}
+	}
+}
+
+func TestRDBEncodings(t *testing.T) {
+	score := binary.LittleEndian.AppendUint64(nil, math.Float64bits(2.5))
+
+	var tests = []struct {
+		name   string
+		record []byte
+		want   interface{}
+	}{
+		// the table itself
+		{"Should read a plain string", rdbRecord(rdbTypeString, 3, 'a', 'b', 'c'), "abc"},
+		{"Should read an 8 bit integer string", rdbRecord(rdbTypeString, 0xc0, 0x85), "-123"},
+		{"Should read a 16 bit integer string", rdbRecord(rdbTypeString, 0xc1, 0x39, 0x30), "12345"},
+		{"Should read a 32 bit integer string", rdbRecord(rdbTypeString, 0xc2, 0x40, 0x42, 0x0f, 0x00), "1000000"},
+		{"Should read an LZF string", rdbRecord(rdbTypeString, 0xc3, 5, 10, 0x00, 'a', 0xe0, 0x00, 0x00), "aaaaaaaaaa"},
+		{"Should read a 14 bit length", rdbRecord(rdbTypeString, append([]byte{0x40, 0x40}, bytes.Repeat([]byte{'z'}, 64)...)...), string(bytes.Repeat([]byte{'z'}, 64))},
+		{"Should read a plain list", rdbRecord(rdbTypeList, 2, 1, 'a', 0xc0, 5), []string{"a", "5"}},
+		{"Should read a ziplist list", rdbRecord(rdbTypeListZiplist, rdbBlob(
+			16, 0, 0, 0, 12, 0, 0, 0, 2, 0,
+			0, 0x01, 'a',
+			3, 0xf6,
+			0xff)...), []string{"a", "5"}},
+		{"Should read a ziplist quicklist", rdbRecord(rdbTypeListQuicklist, append([]byte{1}, rdbBlob(
+			20, 0, 0, 0, 14, 0, 0, 0, 2, 0,
+			0, 0xfe, 0x80,
+			3, 0xc0, 0x00, 0x01,
+			0xff)...)...), []string{"-128", "256"}},
+		{"Should read a listpack quicklist", rdbRecord(rdbTypeListQuicklist2, append(append([]byte{2, 2}, rdbBlob(
+			11, 0, 0, 0, 2, 0,
+			0x81, 'x', 2,
+			7, 1,
+			0xff)...), 1, 3, 'b', 'i', 'g')...), []string{"x", "7", "big"}},
+		{"Should read a plain set", rdbRecord(rdbTypeSet, 2, 1, 'a', 1, 'b'), map[string]bool{"a": true, "b": true}},
+		{"Should read an intset", rdbRecord(rdbTypeSetIntset, rdbBlob(
+			2, 0, 0, 0, 2, 0, 0, 0,
+			0xfe, 0xff, 0x01, 0x00)...), map[string]bool{"-2": true, "1": true}},
+		{"Should read a listpack set", rdbRecord(rdbTypeSetListpack, rdbBlob(
+			13, 0, 0, 0, 2, 0,
+			0x81, 'a', 2,
+			0xf1, 0x10, 0x27, 3,
+			0xff)...), map[string]bool{"a": true, "10000": true}},
+		{"Should read a zset with text scores", rdbRecord(rdbTypeZSet, 2, 1, 'a', 3, '1', '.', '5', 1, 'b', 254), map[string]float64{"a": 1.5, "b": math.Inf(1)}},
+		{"Should read a zset with binary scores", rdbRecord(rdbTypeZSet2, append([]byte{1, 1, 'a'}, score...)...), map[string]float64{"a": 2.5}},
+		{"Should read a ziplist zset", rdbRecord(rdbTypeZSetZiplist, rdbBlob(
+			19, 0, 0, 0, 13, 0, 0, 0, 2, 0,
+			0, 0x01, 'a',
+			3, 0x03, '1', '.', '5',
+			0xff)...), map[string]float64{"a": 1.5}},
+		{"Should read a listpack zset", rdbRecord(rdbTypeZSetListpack, rdbBlob(
+			17, 0, 0, 0, 4, 0,
+			0x81, 'a', 2,
+			0xdf, 0x9c, 2,
+			0x81, 'b', 2,
+			0x03, 1,
+			0xff)...), map[string]float64{"a": -100, "b": 3}},
+		{"Should read a plain hash", rdbRecord(rdbTypeHash, 1, 1, 'f', 1, 'v'), map[string]string{"f": "v"}},
+		{"Should read a ziplist hash", rdbRecord(rdbTypeHashZiplist, rdbBlob(
+			17, 0, 0, 0, 14, 0, 0, 0, 2, 0,
+			0, 0x01, 'f',
+			3, 0x02, 'v', 'v',
+			0xff)...), map[string]string{"f": "vv"}},
+		{"Should read a listpack hash", rdbRecord(rdbTypeHashListpack, rdbBlob(
+			13, 0, 0, 0, 2, 0,
+			0x81, 'f', 2,
+			0xf3, 0xff, 0xff, 0xff, 0x7f, 5,
+			0xff)...), map[string]string{"f": "2147483647"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := decodeRDB(rdbFile(test.record))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec, ok := got[0]["k"]
+			if !ok {
+				t.Fatalf("Expected key 'k' to be loaded.")
+			}
+			value := comparable(rec.value)
+			if !reflect.DeepEqual(value, test.want) {
+				t.Errorf("Got '%v' but expected '%v'.", value, test.want)
+			}
+			if rec.expiryTimestamp != -1 {
+				t.Errorf("Expected no expiry but got '%d'.", rec.expiryTimestamp)
+			}
+		})
+	}
+}
+
+func TestRDBExpiry(t *testing.T) {
+	ms := binary.LittleEndian.AppendUint64([]byte{rdbOpExpireTimeMs}, 4102444800123)
+	seconds := binary.LittleEndian.AppendUint32([]byte{rdbOpExpireTime}, 2000000000)
+	data := rdbFile(
+		append(ms, rdbTypeString, 1, 'a', 0),
+		append(append(seconds, rdbOpFreq, 5), rdbTypeString, 1, 'b', 0),
+		append([]byte{rdbOpIdle, 3}, rdbTypeString, 1, 'c', 0),
+	)
+
+	got, err := decodeRDB(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{"a": 4102444800123, "b": 2000000000000, "c": -1}
+	for key, expiry := range want {
+		if got[0][key].expiryTimestamp != expiry {
+			t.Errorf("Got expiry '%d' for '%s' but expected '%d'.", got[0][key].expiryTimestamp, key, expiry)
+		}
+	}
+}
+
+func TestRDBCorruption(t *testing.T) {
+	data := rdbFile(rdbRecord(rdbTypeString, 1, 'v'))
+
+	badSum := bytes.Clone(data)
+	badSum[len(badSum)-1] ^= 0x01
+	noSum := append(bytes.Clone(data[:len(data)-8]), make([]byte, 8)...)
+	badZiplist := rdbFile(rdbRecord(rdbTypeListZiplist, rdbBlob(11, 0, 0, 0, 10, 0, 0, 0, 1, 0, 0, 0x05, 'a')...))
+	stream := rdbFile(rdbRecord(21, 0))
+	future := bytes.Clone(data)
+	copy(future[len(rdbMagic):], "0099")
+
+	var tests = []struct {
+		name string
+		data []byte
+		// Whether the file loads, if not the error is a snapshotError.
+		ok bool
+	}{
+		// the table itself
+		{"Should load an intact file", data, true},
+		{"Should load a file without a checksum", noSum, true},
+		{"Should reject a wrong checksum", badSum, false},
+		{"Should reject a truncated file", data[:len(data)-10], false},
+		{"Should reject an overlong ziplist entry", badZiplist, false},
+		{"Should reject a stream", stream, false},
+		{"Should reject a newer version", future, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := decodeRDB(test.data)
+			if test.ok {
+				if err != nil {
+					t.Errorf("Expected the file to load but got '%v'.", err)
+				}
+				return
+			}
+			var snapErr *snapshotError
+			if !errors.As(err, &snapErr) {
+				t.Fatalf("Expected a snapshot error but got '%v'.", err)
+			}
+			if snapErr.offset < 0 || snapErr.offset > int64(len(test.data)) {
+				t.Errorf("Got offset '%d' outside the file.", snapErr.offset)
+			}
+		})
+	}
+
+	_, err := decodeRDB(badSum)
+	if !errors.Is(err, errSnapshotChecksum) {
+		t.Errorf("Expected '%v' but got '%v'.", errSnapshotChecksum, err)
+	}
+}
+
+func TestLoadSnapshotFormats(t *testing.T) {
+	for _, format := range []snapshotFormat{snapshotFormatLite, snapshotFormatRDB} {
+		path := filepath.Join(t.TempDir(), "dump.rdb")
+		if err := saveSnapshot(path, testDatabases(), format); err != nil {
+			t.Fatal(err)
+		}
+		if isRDB := isRDBHeader(readHeader(t, path)); isRDB != (format == snapshotFormatRDB) {
+			t.Errorf("Got an RDB header '%v' for format %d.", isRDB, format)
+		}
+
+		srv := newServer(defaultConfig())
+		if err := loadSnapshot(srv, path); err != nil {
+			t.Fatal(err)
+		}
+		if got := comparable(srv.dbs[0].dict["hash"].value); !reflect.DeepEqual(got, map[string]string{"field": "value", "empty": ""}) {
+			t.Errorf("Got '%v' for 'hash' in format %d.", got, format)
+		}
+		if _, ok := srv.dbs[3].dict["zset"]; !ok {
+			t.Errorf("Missing 'zset' in format %d.", format)
+		}
+	}
+}
+
+func readHeader(t *testing.T, path string) []byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data[:len(rdbMagic)+4]
+}
@@ -1,114 +1,150 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math"
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 )
 
 const port = 6379
-const activeExpireKeyLimit = 20
 
-func handleRequest(conn net.Conn, store *dictionary) {
-	defer conn.Close()
-	// TODO: Find a way to support long messages without allocating 512MB. Most messages are short.
-	var buffer []byte
-	buf := make([]byte, 1024)
+// Version reported to clients, the newest Redis whose commands we mimic.
+const serverVersion = "7.2.0"
+
+func handleRequest(netConn net.Conn, srv *server) {
+	defer netConn.Close()
+	conn := newClient(netConn, srv.dbs[0])
+	defer func() {
+		srv.mu.Lock()
+		unwatchAllKeys(conn)
+		freePubsubClient(srv.pubsub, conn)
+		// Nothing can push to the client anymore, stop its pusher.
+		close(conn.wake)
+		srv.mu.Unlock()
+	}()
 
 	for {
-		n, err := conn.Read(buf)
+		// Only wait for the network once the pipelined commands are served.
+		if conn.reader.rd.Buffered() == 0 {
+			if err := conn.flush(); err != nil {
+				log.Println("Error writing to connection:", err)
+				return
+			}
+		}
+
+		arr, err := conn.reader.readCommand()
 		if err != nil {
-			if err == io.EOF {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			}
+			if _, ok := err.(*protocolError); ok {
+				sendErrorToClient(conn, "ERR "+err.Error())
+				conn.flush()
 				return
 			}
 			fmt.Println("Error reading from client:", err)
 			return
 		}
 
-		buffer = append(buffer, buf[:n]...)
+		if arr == nil {
+			log.Println("Received nil array.")
+			continue
+		}
+		// Redis silently skips empty arrays.
+		if len(arr) == 0 {
+			continue
+		}
 
-		if len(buffer) >= 2 && buffer[len(buffer)-2] == '\r' && buffer[len(buffer)-1] == '\n' {
-			input, _, err := deserializeNullOrArray(string(buf[:n]))
-			if err != nil {
-				sendErrorToClient(conn, "ERR - failed while deserializing input")
+		dispatchCommand(arr, conn)
+	}
+}
+
+func handleEcho(arr []interface{}, conn *client, store *dictionary) {
+	msg := serializeBulkString(arr[1].(string))
+	sendMsgToClient(conn, msg)
+}
+
+func handleHello(arr []interface{}, conn *client, store *dictionary) {
+	protocol := conn.protocol
+	if len(arr) > 1 {
+		version, err := strconv.ParseInt(arr[1].(string), 10, 64)
+		if err != nil {
+			sendErrorToClient(conn, "ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if version != 2 && version != 3 {
+			sendErrorToClient(conn, "NOPROTO unsupported protocol version")
+			return
+		}
+		protocol = int(version)
+	}
+
+	// Validate all options before touching the connection state.
+	name := conn.name
+	for i := 2; i < len(arr); i++ {
+		switch opt := strings.ToLower(arr[i].(string)); {
+		case opt == "auth" && i+2 < len(arr):
+			// There are no users or passwords, only the default user which
+			// accepts anything, same as Redis without requirepass.
+			if arr[i+1].(string) != "default" {
+				sendErrorToClient(conn, "WRONGPASS invalid username-password pair or user is disabled.")
 				return
 			}
-
-			if input == nil {
-				log.Println("Received nil array.")
-			} else {
-				if arr, ok := input.([]interface{}); ok {
-					cmd := strings.ToLower(arr[0].(string))
-					switch cmd {
-					case "ping":
-						handlePing(arr, conn)
-					case "echo":
-						handleEcho(arr, conn)
-					case "set":
-						handleSet(arr, conn, store)
-					case "get":
-						handleGet(arr, conn, store)
-					case "exists":
-						handleExists(arr, conn, store)
-					case "del":
-						handleDel(arr, conn, store)
-					case "incr":
-						handleIncr(arr, conn, store)
-					case "decr":
-						handleDecr(arr, conn, store)
-					case "lpush":
-						handleLPush(arr, conn, store)
-					case "lpop":
-						handleLPop(arr, conn, store)
-					default:
-						msg, _ := serializeSimpleError(fmt.Sprintf("-ERR unknown command '%s'", cmd))
-						sendMsgToClient(conn, msg)
-					}
-				}
+			i += 2
+		case opt == "setname" && i+1 < len(arr):
+			name = arr[i+1].(string)
+			if strings.ContainsFunc(name, func(r rune) bool { return r < '!' || r > '~' }) {
+				sendErrorToClient(conn, "ERR Client names cannot contain spaces, newlines or special characters.")
+				return
 			}
-			// Clear buffer for the next message
-			buffer = nil
+			i++
+		default:
+			sendErrorToClient(conn, fmt.Sprintf("ERR Syntax error in HELLO option '%.128s'", arr[i]))
+			return
 		}
 	}
-}
 
-func handleEcho(arr []interface{}, conn net.Conn) {
-	msg := serializeBulkString(arr[1].(string))
+	conn.protocol = protocol
+	conn.name = name
+
+	proto, _, _ := serializeInteger(int64(conn.protocol))
+	id, _, _ := serializeInteger(conn.id)
+	msg := conn.serializeMap([]string{
+		serializeBulkString("server"), serializeBulkString("redis"),
+		serializeBulkString("version"), serializeBulkString(serverVersion),
+		serializeBulkString("proto"), proto,
+		serializeBulkString("id"), id,
+		serializeBulkString("mode"), serializeBulkString("standalone"),
+		serializeBulkString("role"), serializeBulkString("master"),
+		serializeBulkString("modules"), serializeArray(nil),
+	})
 	sendMsgToClient(conn, msg)
 }
 
-func handleDel(arr []interface{}, conn net.Conn, store *dictionary) {
-	if len(arr) < 2 {
-		sendErrorToClient(conn, "ERR wrong number of arguments for 'del' command")
-		return
-	}
+func handleDel(arr []interface{}, conn *client, store *dictionary) {
 	count := 0
-	store.mu.Lock()
 	for _, key := range arr[1:] {
-		if _, ok := store.dict[key.(string)]; ok {
+		if _, ok := lookupRecord(store, key.(string)); ok {
 			count++
-			delete(store.dict, key.(string))
+			deleteKey(store, key.(string))
 		}
 	}
-	store.mu.Unlock()
 	msg, _, _ := serializeInteger(int64(count))
 	sendMsgToClient(conn, msg)
 }
 
-func handleExists(arr []interface{}, conn net.Conn, store *dictionary) {
-	if len(arr) < 2 {
-		sendErrorToClient(conn, "ERR wrong number of arguments for 'exists' command")
-		return
-	}
+func handleExists(arr []interface{}, conn *client, store *dictionary) {
 	count := 0
 	for _, key := range arr[1:] {
-		if _, ok := store.dict[key.(string)]; ok {
+		if _, ok := lookupRecord(store, key.(string)); ok {
 			count++
 		}
 	}
@@ -116,17 +152,29 @@ func handleExists(arr []interface{}, conn net.Conn, store *dictionary) {
 	sendMsgToClient(conn, msg)
 }
 
-func handlePing(arr []interface{}, conn net.Conn) {
+func handlePing(arr []interface{}, conn *client, store *dictionary) {
 	var msg string
 	var err error
 
+	// A subscribed RESP2 client can't tell a simple string from a message,
+	// so it gets an array like one.
+	if conn.protocol == 2 && conn.subscribed() && len(arr) <= 2 {
+		payload := ""
+		if len(arr) == 2 {
+			payload = arr[1].(string)
+		}
+		sendMsgToClient(conn, serializeArray([]string{serializeBulkString("pong"), serializeBulkString(payload)}))
+		return
+	}
+
 	switch len(arr) {
 	case 1:
 		msg, err = serializeSimpleString("PONG")
 	case 2:
 		msg = serializeBulkString(arr[1].(string))
 	default:
-		msg, err = serializeSimpleError("ERR wrong number of arguments for 'ping' command")
+		sendArityError(conn, "ping")
+		return
 	}
 
 	if err != nil {
@@ -137,241 +185,57 @@ func handlePing(arr []interface{}, conn net.Conn) {
 	sendMsgToClient(conn, msg)
 }
 
-func handleLPush(arr []interface{}, conn net.Conn, store *dictionary) {
-	if len(arr) < 2 {
-		msg, _ := serializeSimpleError("ERR wrong number of arguments for 'lpush' command")
-		sendMsgToClient(conn, msg)
-		return
-	}
-
-	store.mu.Lock()
-	defer store.mu.Unlock()
-
-	// Add logic to initialize LL if it doesn't exist
-	rec, ok := store.dict[arr[1].(string)]
-	if !ok {
-		// initialize Linked List
-		rec = record{value: linkedList{
-			length: 0},
-			expiryTimestamp: -1}
-		store.dict[arr[1].(string)] = rec
-	}
-
-	ll, ok := rec.value.(linkedList)
-	if !ok {
-		msg, _ := serializeSimpleError("WRONGTYPE Operation against a key holding the wrong kind of value")
-		sendMsgToClient(conn, msg)
-		return
-	}
-
-	// Insert one by one at the front
-	// Inserting A, B, C results in LL of C -> B -> A
-	for _, val := range arr[2:] {
-		node := node{value: val.(string), next: ll.head, prev: nil}
-		if ll.head != nil {
-			ll.head.prev = &node
-		}
-		ll.head = &node
-		ll.length++
-
-		// The only time LPUSH affects the tail is when 1st item is inserted.
-		if ll.length == 1 {
-			ll.tail = &node
-		}
-		rec.value = ll
-		store.dict[arr[1].(string)] = rec
-	}
-
-	msg, _, _ := serializeInteger(int64(ll.length))
-	sendMsgToClient(conn, msg)
+func handleDecr(arr []interface{}, conn *client, store *dictionary) {
+	incrDecrGeneric(arr, conn, store, -1)
 }
 
-func handleLPop(arr []interface{}, conn net.Conn, store *dictionary) {
-	if len(arr) < 2 || len(arr) > 3 {
-		msg, _ := serializeSimpleError("ERR wrong number of arguments for 'lpop' command")
-		sendMsgToClient(conn, msg)
-		return
-	}
-
-	store.mu.Lock()
-	defer store.mu.Unlock()
-
-	rec, ok := store.dict[arr[1].(string)]
-	if !ok {
-		msg := serializeNullArray()
-		sendMsgToClient(conn, msg)
-		return
-	}
-
-	ll, ok := rec.value.(linkedList)
-	if !ok {
-		msg, _ := serializeSimpleError("WRONGTYPE Operation against a key holding the wrong kind of value")
-		sendMsgToClient(conn, msg)
-		return
-	}
-	if ll.length == 0 {
-		msg := serializeNullArray()
-		sendMsgToClient(conn, msg)
-		return
-	}
-
-	count := 1
-	if len(arr) == 3 {
-		parsedCount, err := strconv.ParseInt(arr[2].(string), 10, 64)
-		if err != nil {
-			msg, _ := serializeSimpleError("ERR internal error")
-			sendMsgToClient(conn, msg)
-			return
-		}
-		count = int(parsedCount)
-	}
-
-	resultArr := make([]string, count)
-	for i := 0; i < int(count); i++ {
-		val := ll.head.value
-		ll.head = ll.head.next
-		if ll.head != nil {
-			ll.head.prev = nil
-		}
-		ll.length--
-
-		// If there's only one node, it's both the head and tail
-		if ll.length == 1 {
-			ll.tail = ll.head
-		}
-
-		// Write back ll to map
-		rec.value = ll
-		store.dict[arr[1].(string)] = rec
-
-		resultArr[i] = val
-	}
-
-	if len(resultArr) == 1 {
-		msg := serializeBulkString(resultArr[0])
-		sendMsgToClient(conn, msg)
-		return
-	}
-
-	msg, _, _ := serializeStringArray(resultArr)
-	sendMsgToClient(conn, msg)
+func handleIncr(arr []interface{}, conn *client, store *dictionary) {
+	incrDecrGeneric(arr, conn, store, 1)
 }
 
-func handleDecr(arr []interface{}, conn net.Conn, store *dictionary) {
-	if len(arr) != 2 {
-		msg, _ := serializeSimpleError("ERR wrong number of arguments for 'decr' command")
-		sendMsgToClient(conn, msg)
+func handleGet(arr []interface{}, conn *client, store *dictionary) {
+	val, ok, err := getString(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
 		return
 	}
-	store.mu.Lock()
-	defer store.mu.Unlock()
-
-	rec, ok := store.dict[arr[1].(string)]
 	if !ok {
-		rec = record{"0", -1}
-		store.dict[arr[1].(string)] = rec
-	}
-	val, ok := rec.value.(string)
-	if !ok {
-		msg, _ := serializeSimpleError("WRONGTYPE Operation against a key holding the wrong kind of value")
-		sendMsgToClient(conn, msg)
-		return
-	}
-
-	num, err := strconv.ParseInt(val, 10, 64)
-	if err != nil || num <= math.MinInt64 {
-		msg, _ := serializeSimpleError("ERR value is not an integer or out of range")
-		sendMsgToClient(conn, msg)
+		sendMsgToClient(conn, conn.serializeNull())
 		return
 	}
-	num--
-	store.dict[arr[1].(string)] = record{fmt.Sprint(num), rec.expiryTimestamp}
-	msg, _, _ := serializeInteger(num)
-	sendMsgToClient(conn, msg)
+	sendMsgToClient(conn, serializeBulkString(val))
 }
 
-func handleIncr(arr []interface{}, conn net.Conn, store *dictionary) {
-	if len(arr) != 2 {
-		msg, _ := serializeSimpleError("ERR wrong number of arguments for 'INCR' command")
-		sendMsgToClient(conn, msg)
-		return
-	}
-	store.mu.Lock()
-	defer store.mu.Unlock()
-
-	rec, ok := store.dict[arr[1].(string)]
-	if !ok {
-		rec = record{"0", -1}
-		store.dict[arr[1].(string)] = rec
-	}
-	val, ok := rec.value.(string)
-	if !ok {
-		msg, _ := serializeSimpleError("WRONGTYPE Operation against a key holding the wrong kind of value")
-		sendMsgToClient(conn, msg)
-		return
+func sendMsgToClient(conn net.Conn, msg string) {
+	if _, err := conn.Write(([]byte(msg))); err != nil {
+		log.Println("Error writing to connection:", err)
 	}
+}
 
-	num, err := strconv.ParseInt(val, 10, 64)
-	if err != nil || num >= math.MaxInt64 {
-		msg, _ := serializeSimpleError("ERR value is not an integer or out of range")
-		sendMsgToClient(conn, msg)
-		return
-	}
-	num++
-	store.dict[arr[1].(string)] = record{fmt.Sprint(num), rec.expiryTimestamp}
-	msg, _, _ := serializeInteger(num)
+func sendErrorToClient(conn net.Conn, errMsg string) {
+	msg, _ := serializeSimpleError(errMsg)
 	sendMsgToClient(conn, msg)
 }
 
-func handleGet(arr []interface{}, conn net.Conn, store *dictionary) {
-	if len(arr) != 2 {
-		sendErrorToClient(conn, "ERR wrong number of arguments for 'get' command")
-		return
-	}
+var errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+var errNotInteger = errors.New("ERR value is not an integer or out of range")
 
-	store.mu.Lock()
-	defer store.mu.Unlock()
-	key, ok := arr[1].(string)
-	if !ok {
-		sendErrorToClient(conn, "ERR wrong argument type")
-		return
-	}
+var errNotFloat = errors.New("ERR value is not a valid float")
 
-	rec, ok := store.dict[key]
-	if !ok {
-		msg := serializeNullArray()
-		sendMsgToClient(conn, msg)
-		return
+func parseFloat(arg interface{}) (float64, error) {
+	num, err := strconv.ParseFloat(arg.(string), 64)
+	if err != nil || math.IsNaN(num) {
+		return 0, errNotFloat
 	}
-
-	val, ok := rec.value.(string)
-	if !ok {
-		sendErrorToClient(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
-		return
-	}
-
-	// delete expired key and return nil, since the key doesn't exist anymore.
-	if recordExpired(rec.expiryTimestamp) {
-		delete(store.dict, key)
-
-		msg := serializeNullArray()
-		sendMsgToClient(conn, msg)
-		return
-	}
-
-	msg := serializeBulkString(val)
-	sendMsgToClient(conn, msg)
+	return num, nil
 }
 
-func sendMsgToClient(conn net.Conn, msg string) {
-	if _, err := conn.Write(([]byte(msg))); err != nil {
-		log.Println("Error writing to connection:", err)
+func parseInteger(arg interface{}) (int64, error) {
+	num, err := strconv.ParseInt(arg.(string), 10, 64)
+	if err != nil {
+		return 0, errNotInteger
 	}
-}
-
-func sendErrorToClient(conn net.Conn, errMsg string) {
-	msg, _ := serializeSimpleError(errMsg)
-	sendMsgToClient(conn, msg)
+	return num, nil
 }
 
 func recordExpired(recordExpiration int64) bool {
@@ -381,8 +245,9 @@ func recordExpired(recordExpiration int64) bool {
 	return recordExpiration < time.Now().UnixMilli()
 }
 
-func parseExpiryTimestamp(exCmd string, exTime string) (int64, error) {
-	var expiryTimestamp int64
+// parseExpiryTimestamp converts the EX, PX, EXAT or PXAT argument of the
+// command cmdName to a unix time in milliseconds.
+func parseExpiryTimestamp(cmdName string, exCmd string, exTime string) (int64, error) {
 	duration, err := strconv.ParseInt(exTime, 10, 64)
 	if err != nil {
 		return 0, fmt.Errorf("ERR expiration argument has to be an integer")
@@ -391,90 +256,114 @@ func parseExpiryTimestamp(exCmd string, exTime string) (int64, error) {
 		return 0, fmt.Errorf("ERR expiration argument has to be positive")
 	}
 
+	var unit time.Duration
+	absolute := false
 	switch cmd := strings.ToLower(exCmd); cmd {
 	case "ex":
-		expiryTimestamp = time.Now().UnixMilli() + duration*1000
+		unit = time.Second
 	case "px":
-		expiryTimestamp = time.Now().UnixMilli() + duration
+		unit = time.Millisecond
 	case "exat":
-		expiryTimestamp = duration * 1000
+		unit, absolute = time.Second, true
 	case "pxat":
-		expiryTimestamp = duration
+		unit, absolute = time.Millisecond, true
 	default:
 		return 0, fmt.Errorf("ERR unknown option for SET")
 	}
+	expiryTimestamp, err := expiryFromArgument(duration, unit, absolute)
+	if err != nil {
+		return 0, fmt.Errorf("ERR invalid expire time in '%s' command", strings.ToLower(cmdName))
+	}
 	return expiryTimestamp, nil
 }
 
-func handleSet(arr []interface{}, conn net.Conn, store *dictionary) {
-	// input validations
-	if len(arr) != 3 && len(arr) != 5 {
-		sendErrorToClient(conn, "ERR wrong number of arguments for 'set' command")
-		return
-	}
-
+func handleSet(arr []interface{}, conn *client, store *dictionary) {
+	key := arr[1].(string)
+	var nx, xx, get, keepTTL bool
 	// -1 denotes no expiration is set.
 	var expiryTimestamp int64 = -1
-
-	if len(arr) == 5 {
-		exCmd, ok1 := arr[3].(string)
-		exTime, ok2 := arr[4].(string)
-		if !ok1 || !ok2 {
-			log.Println("Invalid argument type")
-			sendErrorToClient(conn, "ERR invalid argument type")
+	hasExpiry := false
+
+	for i := 3; i < len(arr); i++ {
+		switch opt := strings.ToLower(arr[i].(string)); {
+		case opt == "nx" && !xx:
+			nx = true
+		case opt == "xx" && !nx:
+			xx = true
+		case opt == "get":
+			get = true
+		case opt == "keepttl" && !hasExpiry:
+			keepTTL = true
+		case (opt == "ex" || opt == "px" || opt == "exat" || opt == "pxat") && !hasExpiry && !keepTTL && i+1 < len(arr):
+			var err error
+			expiryTimestamp, err = parseExpiryTimestamp(arr[0].(string), opt, arr[i+1].(string))
+			if err != nil {
+				sendErrorToClient(conn, err.Error())
+				return
+			}
+			hasExpiry = true
+			// Logged with the absolute time, a relative one would restart
+			// on replay.
+			args := make([]string, len(arr))
+			for j, arg := range arr {
+				args[j] = arg.(string)
+			}
+			args[i], args[i+1] = "pxat", strconv.FormatInt(expiryTimestamp, 10)
+			rewriteCommand(conn, args)
+			i++
+		default:
+			sendErrorToClient(conn, "ERR syntax error")
 			return
 		}
-
-		var err error
-		expiryTimestamp, err = parseExpiryTimestamp(exCmd, exTime)
-		if err != nil {
-			sendErrorToClient(conn, err.Error())
-		}
 	}
 
-	store.mu.Lock()
-	store.dict[arr[1].(string)] = record{value: arr[2].(string), expiryTimestamp: expiryTimestamp}
-	store.mu.Unlock()
-
-	msg, _ := serializeSimpleString("OK")
-	sendMsgToClient(conn, msg)
-}
-
-// Locks the store while cleaning up - Not sure about the perf impact.
-func activeKeyExpirer(store *dictionary) {
-	for {
-		expired := 0
-		total := 0
-		keys := make([]string, 0)
-		store.mu.Lock()
-		for k, v := range store.dict {
-			if v.expiryTimestamp == -1 {
-				continue
-			}
-			if recordExpired(v.expiryTimestamp) {
-				expired++
-				keys = append(keys, k)
-			}
-			total++
-
-			if total >= activeExpireKeyLimit {
-				break
-			}
-		}
+	rec, exists := lookupRecord(store, key)
+	old, isString := rec.value.(string)
+	if get && exists && !isString {
+		sendErrorToClient(conn, errWrongType.Error())
+		return
+	}
 
-		for _, k := range keys {
-			delete(store.dict, k)
+	if (nx && exists) || (xx && !exists) {
+		if get && exists {
+			sendMsgToClient(conn, serializeBulkString(old))
+			return
 		}
+		sendMsgToClient(conn, conn.serializeNull())
+		return
+	}
 
-		store.mu.Unlock()
+	if keepTTL && exists {
+		expiryTimestamp = rec.expiryTimestamp
+	}
+	setRecord(store, key, record{value: arr[2].(string), expiryTimestamp: expiryTimestamp})
 
-		if float64(expired)/float64(total) < 0.25 {
-			time.Sleep(100 * time.Millisecond)
-		}
+	switch {
+	case get && exists:
+		sendMsgToClient(conn, serializeBulkString(old))
+	case get:
+		sendMsgToClient(conn, conn.serializeNull())
+	default:
+		msg, _ := serializeSimpleString("OK")
+		sendMsgToClient(conn, msg)
 	}
 }
 
 func main() {
+	// Installed under the name redis-lite-check, the binary checks files.
+	if strings.HasPrefix(filepath.Base(os.Args[0]), "redis-lite-check") {
+		os.Exit(checkMain(os.Args[1:], os.Stdout))
+	}
+	cfg, err := parseConfig(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	runServer(cfg)
+}
+
+// runServer serves clients until the process exits.
+func runServer(cfg config) {
 	// Sets up logging
 	file, err := os.OpenFile("redis.log", os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0666)
 	if err != nil {
@@ -493,9 +382,14 @@ func main() {
 
 	log.Printf("Listening on %s...", address)
 
-	store := newStore()
+	srv := newServer(cfg)
+	if err := loadDataFromDisk(srv); err != nil {
+		log.Fatal("Error loading the data: ", err)
+	}
 
-	go activeKeyExpirer(store)
+	go activeKeyExpirer(srv)
+	go snapshotCron(srv)
+	go appendOnlyFileCron(srv)
 
 	for {
 		// Accept a connection
@@ -503,6 +397,6 @@ func main() {
 		if err != nil {
 			log.Fatal("Error accepting:", err.Error())
 		}
-		go handleRequest(conn, store)
+		go handleRequest(conn, srv)
 	}
 }
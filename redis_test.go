@@ -1,27 +1,79 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"flag"
 	"fmt"
+	"io"
+	"log"
 	"math"
+	"net"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// The config the test server runs with.
+var testCfg config
+
 func TestMain(m *testing.M) {
-	// Setup the server
-	go main()
+	// Setup the server. Fuzzing runs in several worker processes which can't
+	// all bind the port, and fuzz targets don't talk to the server anyway.
+	flag.Parse()
+	if flag.Lookup("test.fuzz").Value.String() == "" {
+		cfg := defaultConfig()
+		// Small enough for TestSlowSubscriber to exceed quickly.
+		cfg.pubsubOutputLimit = outputLimit{hard: 1 << 20}
+		// TestAppendOnlyFile replays what the whole run logged.
+		cfg.appendOnly = true
+		// Keep snapshots and the append-only file out of the working tree.
+		dir, err := os.MkdirTemp("", "redis-lite")
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.dir = dir
+		testCfg = cfg
+		go runServer(cfg)
+		waitForServer()
+	}
 
 	// Run the tests
 	code := m.Run()
+	if testCfg.dir != "" {
+		os.RemoveAll(testCfg.dir)
+	}
 
 	// Exit
 	os.Exit(code)
 }
 
+// waitForServer blocks until the server answers, which it does once it has
+// loaded its data. A short run removing the directory earlier would break
+// the startup.
+func waitForServer() {
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+	defer rdb.Close()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for rdb.Ping(context.Background()).Err() != nil {
+		if time.Now().After(deadline) {
+			log.Fatal("The server didn't start.")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 func TestSetGet(t *testing.T) {
 	ctx := context.Background()
 	rdb := redis.NewClient(&redis.Options{
@@ -610,3 +662,2605 @@ func TestLPushLPopCount(t *testing.T) {
 		t.Error("Result array does not match or does not have the correct order")
 	}
 }
+
+func TestPipeline(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	cmds, err := rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, "pipelineKey", "value", 0)
+		pipe.Incr(ctx, "pipelineCounter")
+		pipe.Incr(ctx, "pipelineCounter")
+		pipe.Get(ctx, "pipelineKey")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cmds) != 4 {
+		t.Fatalf("Expected 4 replies but got '%d'", len(cmds))
+	}
+	if res := cmds[2].(*redis.IntCmd).Val(); res != 2 {
+		t.Errorf("Expected 2 but got '%d'", res)
+	}
+	if res := cmds[3].(*redis.StringCmd).Val(); res != "value" {
+		t.Errorf("Expected 'value' but got '%s'", res)
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	_, err := rdb.Do(ctx, "NOSUCHCOMMAND", "arg").Result()
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	if err.Error() != "ERR unknown command 'NOSUCHCOMMAND', with args beginning with: 'arg' " {
+		t.Errorf("Expected 'unknown command' but got '%s'", err.Error())
+	}
+}
+
+func TestWrongArity(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	_, err := rdb.Do(ctx, "GET", "key", "extra").Result()
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	if err.Error() != "ERR wrong number of arguments for 'get' command" {
+		t.Errorf("Expected 'wrong number of arguments' but got '%s'", err.Error())
+	}
+}
+
+func TestCommandInfo(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	infos, err := rdb.Command(ctx).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	get, ok := infos["get"]
+	if !ok {
+		t.Fatal("Expected 'get' in COMMAND reply")
+	}
+	if get.Arity != 2 || !get.ReadOnly || get.FirstKeyPos != 1 {
+		t.Errorf("Unexpected command info for 'get': %+v", get)
+	}
+
+	count, err := rdb.Do(ctx, "COMMAND", "COUNT").Int64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != int64(len(infos)) {
+		t.Errorf("Expected '%d' but got '%d'", len(infos), count)
+	}
+}
+
+func TestProtocolError(t *testing.T) {
+	conn, err := net.Dial("tcp", "localhost:6379")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Integers are not allowed as command arguments.
+	if _, err := conn.Write([]byte("*2\r\n$4\r\nECHO\r\n:1\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "-ERR Protocol error: expected '$', got ':'\r\n" {
+		t.Errorf("Expected protocol error but got '%q'", line)
+	}
+
+	// The server closes the connection after a protocol error.
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected the connection to be closed but got '%v'", err)
+	}
+}
+
+func TestInlineCommands(t *testing.T) {
+	conn, err := net.Dial("tcp", "localhost:6379")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("PING\r\nSET inlineKey \"hello world\"\r\nGET inlineKey\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(conn)
+	for _, want := range []string{"+PONG\r\n", "+OK\r\n", "$11\r\n", "hello world\r\n"} {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != want {
+			t.Errorf("Expected '%q' but got '%q'", want, line)
+		}
+	}
+}
+
+func TestHello(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	res, err := rdb.Do(ctx, "HELLO", "3", "SETNAME", "tester").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, ok := res.(map[interface{}]interface{})
+	if !ok {
+		t.Fatalf("Expected a RESP3 map but got '%T'", res)
+	}
+	if info["proto"] != int64(3) || info["server"] != "redis" {
+		t.Errorf("Unexpected HELLO reply: %v", info)
+	}
+
+	_, err = rdb.Do(ctx, "HELLO", "4").Result()
+	if err == nil || err.Error() != "NOPROTO unsupported protocol version" {
+		t.Errorf("Expected 'NOPROTO' but got '%v'", err)
+	}
+}
+
+func TestResp2Client(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+		Protocol: 2,
+	})
+
+	err := rdb.Set(ctx, "resp2Key", "value", 0).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, err := rdb.Get(ctx, "resp2Key").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != "value" {
+		t.Errorf("Got '%s' but expected '%s'", val, "value")
+	}
+	_, err = rdb.Get(ctx, "resp2KeyDoesNotExist").Result()
+	if err != redis.Nil {
+		t.Errorf("Expected 'redis: nil' but got '%v'", err)
+	}
+}
+
+func TestRPushLRange(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	count, err := rdb.RPush(ctx, "listRange", "a", "b", "c", "d").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Errorf("Expected '4' but got '%d'", count)
+	}
+
+	var tests = []struct {
+		start, stop int64
+		want        []string
+	}{
+		{0, -1, []string{"a", "b", "c", "d"}},
+		{1, 2, []string{"b", "c"}},
+		{-2, 100, []string{"c", "d"}},
+		{-100, 0, []string{"a"}},
+		{3, 1, []string{}},
+	}
+	for _, test := range tests {
+		res, err := rdb.LRange(ctx, "listRange", test.start, test.stop).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(res) != fmt.Sprint(test.want) {
+			t.Errorf("LRANGE %d %d: expected '%v' but got '%v'", test.start, test.stop, test.want, res)
+		}
+	}
+}
+
+func TestLIndexLSet(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.RPush(ctx, "listIndex", "a", "b", "c")
+
+	if err := rdb.LSet(ctx, "listIndex", -1, "z").Err(); err != nil {
+		t.Fatal(err)
+	}
+	val, err := rdb.LIndex(ctx, "listIndex", 2).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != "z" {
+		t.Errorf("Expected 'z' but got '%s'", val)
+	}
+
+	if _, err := rdb.LIndex(ctx, "listIndex", 3).Result(); err != redis.Nil {
+		t.Errorf("Expected 'redis: nil' but got '%v'", err)
+	}
+	err = rdb.LSet(ctx, "listIndex", 10, "x").Err()
+	if err == nil || err.Error() != "ERR index out of range" {
+		t.Errorf("Expected 'index out of range' but got '%v'", err)
+	}
+}
+
+func TestLInsertLRem(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.RPush(ctx, "listInsert", "a", "x", "b", "x", "c", "x")
+
+	count, err := rdb.LInsertBefore(ctx, "listInsert", "b", "new").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 7 {
+		t.Errorf("Expected '7' but got '%d'", count)
+	}
+	if count, _ := rdb.LInsertAfter(ctx, "listInsert", "missing", "new").Result(); count != -1 {
+		t.Errorf("Expected '-1' but got '%d'", count)
+	}
+
+	// Remove the last two occurrences of x.
+	removed, err := rdb.LRem(ctx, "listInsert", -2, "x").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Errorf("Expected '2' but got '%d'", removed)
+	}
+	res, _ := rdb.LRange(ctx, "listInsert", 0, -1).Result()
+	if fmt.Sprint(res) != "[a x new b c]" {
+		t.Errorf("Expected '[a x new b c]' but got '%v'", res)
+	}
+}
+
+func TestLTrim(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.RPush(ctx, "listTrim", "a", "b", "c", "d", "e")
+
+	if err := rdb.LTrim(ctx, "listTrim", 1, -2).Err(); err != nil {
+		t.Fatal(err)
+	}
+	res, _ := rdb.LRange(ctx, "listTrim", 0, -1).Result()
+	if fmt.Sprint(res) != "[b c d]" {
+		t.Errorf("Expected '[b c d]' but got '%v'", res)
+	}
+
+	// Trimming to an empty range deletes the key.
+	if err := rdb.LTrim(ctx, "listTrim", 5, 10).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := rdb.Exists(ctx, "listTrim").Result(); exists != 0 {
+		t.Errorf("Expected the key to be deleted")
+	}
+}
+
+func TestLPos(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.RPush(ctx, "listPos", "a", "b", "c", "1", "2", "3", "c", "c")
+
+	pos, err := rdb.LPos(ctx, "listPos", "c", redis.LPosArgs{}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != 2 {
+		t.Errorf("Expected '2' but got '%d'", pos)
+	}
+
+	positions, err := rdb.LPosCount(ctx, "listPos", "c", 2, redis.LPosArgs{Rank: -1}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(positions) != "[7 6]" {
+		t.Errorf("Expected '[7 6]' but got '%v'", positions)
+	}
+
+	if _, err := rdb.LPos(ctx, "listPos", "c", redis.LPosArgs{MaxLen: 2}).Result(); err != redis.Nil {
+		t.Errorf("Expected 'redis: nil' but got '%v'", err)
+	}
+}
+
+func TestLMove(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.RPush(ctx, "listMoveSrc", "a", "b", "c")
+
+	val, err := rdb.LMove(ctx, "listMoveSrc", "listMoveDst", "RIGHT", "LEFT").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != "c" {
+		t.Errorf("Expected 'c' but got '%s'", val)
+	}
+	val, err = rdb.RPopLPush(ctx, "listMoveSrc", "listMoveDst").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != "b" {
+		t.Errorf("Expected 'b' but got '%s'", val)
+	}
+	res, _ := rdb.LRange(ctx, "listMoveDst", 0, -1).Result()
+	if fmt.Sprint(res) != "[b c]" {
+		t.Errorf("Expected '[b c]' but got '%v'", res)
+	}
+
+	// Rotate a list onto itself.
+	rdb.LMove(ctx, "listMoveDst", "listMoveDst", "LEFT", "RIGHT")
+	res, _ = rdb.LRange(ctx, "listMoveDst", 0, -1).Result()
+	if fmt.Sprint(res) != "[c b]" {
+		t.Errorf("Expected '[c b]' but got '%v'", res)
+	}
+}
+
+func TestRPopDeletesEmptyList(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.RPush(ctx, "listRPop", "a", "b")
+
+	res, err := rdb.RPopCount(ctx, "listRPop", 5).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(res) != "[b a]" {
+		t.Errorf("Expected '[b a]' but got '%v'", res)
+	}
+	if exists, _ := rdb.Exists(ctx, "listRPop").Result(); exists != 0 {
+		t.Errorf("Expected the key to be deleted")
+	}
+	if _, err := rdb.RPush(ctx, "listRPop", "c").Result(); err != nil {
+		t.Errorf("Expected the key to be reusable but got '%v'", err)
+	}
+}
+
+func TestBLPopTimeout(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	start := time.Now()
+	// go-redis rounds timeouts up to whole seconds, send a fractional one by hand.
+	_, err := rdb.Do(ctx, "BLPOP", "blpopTimeout", "0.2").Result()
+	if err != redis.Nil {
+		t.Errorf("Expected 'redis: nil' but got '%v'", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Expected to block for about 200ms but blocked for '%v'", elapsed)
+	}
+}
+
+func TestBLPopWakeup(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		rdb.RPush(ctx, "blpopWakeup2", "value")
+	}()
+
+	res, err := rdb.BLPop(ctx, 0, "blpopWakeup1", "blpopWakeup2").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(res) != "[blpopWakeup2 value]" {
+		t.Errorf("Expected '[blpopWakeup2 value]' but got '%v'", res)
+	}
+	if exists, _ := rdb.Exists(ctx, "blpopWakeup2").Result(); exists != 0 {
+		t.Errorf("Expected the key to be deleted")
+	}
+}
+
+func TestBLPopFairness(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	results := make([]chan string, 3)
+	for i := range results {
+		results[i] = make(chan string, 1)
+		go func(ch chan string) {
+			res, err := rdb.BRPop(ctx, 2*time.Second, "brpopFair").Result()
+			if err != nil {
+				ch <- err.Error()
+				return
+			}
+			ch <- res[1]
+		}(results[i])
+		// Give each client time to block so the order is well defined.
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	rdb.RPush(ctx, "brpopFair", "first", "second", "third")
+
+	// BRPOP takes from the tail, the client that blocked first is served first.
+	for i, want := range []string{"third", "second", "first"} {
+		if got := <-results[i]; got != want {
+			t.Errorf("Client %d: expected '%s' but got '%s'", i, want, got)
+		}
+	}
+}
+
+func TestBlockedClientDisconnect(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	conn, err := net.Dial("tcp", "localhost:6379")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("BLPOP blpopDisconnect 0\r\n"))
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	// The element must not be handed to the client that went away.
+	rdb.RPush(ctx, "blpopDisconnect", "value")
+	length, err := rdb.LLen(ctx, "blpopDisconnect").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length != 1 {
+		t.Errorf("Expected '1' but got '%d'", length)
+	}
+}
+
+func TestBLMove(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		rdb.LPush(ctx, "blmoveSrc", "a", "b")
+	}()
+
+	val, err := rdb.BLMove(ctx, "blmoveSrc", "blmoveDst", "LEFT", "RIGHT", time.Second).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != "b" {
+		t.Errorf("Expected 'b' but got '%s'", val)
+	}
+	res, _ := rdb.LRange(ctx, "blmoveDst", 0, -1).Result()
+	if fmt.Sprint(res) != "[b]" {
+		t.Errorf("Expected '[b]' but got '%v'", res)
+	}
+}
+
+func TestBLMPop(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		rdb.RPush(ctx, "blmpop2", "a", "b", "c")
+	}()
+
+	key, vals, err := rdb.BLMPop(ctx, time.Second, "right", 2, "blmpop1", "blmpop2").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "blmpop2" || fmt.Sprint(vals) != "[c b]" {
+		t.Errorf("Expected 'blmpop2 [c b]' but got '%s %v'", key, vals)
+	}
+
+	_, err = rdb.Do(ctx, "BLMPOP", "-1", "1", "blmpop1", "LEFT").Result()
+	if err == nil || err.Error() != "ERR timeout is negative" {
+		t.Errorf("Expected 'timeout is negative' but got '%v'", err)
+	}
+	for _, timeout := range []string{"9223372037", "1e300"} {
+		_, err = rdb.Do(ctx, "BLMPOP", timeout, "1", "blmpop1", "LEFT").Result()
+		if err == nil || err.Error() != "ERR timeout is out of range" {
+			t.Errorf("Expected 'timeout is out of range' but got '%v'", err)
+		}
+	}
+}
+
+func TestHSetHGet(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	added, err := rdb.HSet(ctx, "hashSession", "user", "alice", "role", "admin").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 2 {
+		t.Errorf("Expected '2' but got '%d'", added)
+	}
+	if added, _ := rdb.HSet(ctx, "hashSession", "role", "user").Result(); added != 0 {
+		t.Errorf("Expected '0' but got '%d'", added)
+	}
+
+	val, err := rdb.HGet(ctx, "hashSession", "role").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != "user" {
+		t.Errorf("Expected 'user' but got '%s'", val)
+	}
+	if _, err := rdb.HGet(ctx, "hashSession", "missing").Result(); err != redis.Nil {
+		t.Errorf("Expected 'redis: nil' but got '%v'", err)
+	}
+
+	vals, err := rdb.HMGet(ctx, "hashSession", "user", "missing").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vals[0] != "alice" || vals[1] != nil {
+		t.Errorf("Expected '[alice <nil>]' but got '%v'", vals)
+	}
+
+	all, err := rdb.HGetAll(ctx, "hashSession").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all["user"] != "alice" || all["role"] != "user" {
+		t.Errorf("Unexpected HGETALL reply '%v'", all)
+	}
+
+	if ok, _ := rdb.HSetNX(ctx, "hashSession", "user", "bob").Result(); ok {
+		t.Errorf("Expected HSETNX not to overwrite an existing field")
+	}
+	if length, _ := rdb.Do(ctx, "HSTRLEN", "hashSession", "user").Int64(); length != 5 {
+		t.Errorf("Expected '5' but got '%d'", length)
+	}
+}
+
+func TestHDel(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.HSet(ctx, "hashDel", "a", "1", "b", "2")
+
+	deleted, err := rdb.HDel(ctx, "hashDel", "a", "missing").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Errorf("Expected '1' but got '%d'", deleted)
+	}
+	if exists, _ := rdb.HExists(ctx, "hashDel", "a").Result(); exists {
+		t.Errorf("Expected field to be deleted")
+	}
+	if length, _ := rdb.HLen(ctx, "hashDel").Result(); length != 1 {
+		t.Errorf("Expected '1' but got '%d'", length)
+	}
+
+	// Deleting the last field deletes the key.
+	rdb.HDel(ctx, "hashDel", "b")
+	if exists, _ := rdb.Exists(ctx, "hashDel").Result(); exists != 0 {
+		t.Errorf("Expected the key to be deleted")
+	}
+}
+
+func TestHKeysHVals(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.HSet(ctx, "hashKeys", "b", "2", "a", "1", "c", "3")
+
+	keys, err := rdb.HKeys(ctx, "hashKeys").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals, err := rdb.HVals(ctx, "hashKeys").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(keys) != "[a b c]" || fmt.Sprint(vals) != "[1 2 3]" {
+		t.Errorf("Expected matching fields and values but got '%v' and '%v'", keys, vals)
+	}
+}
+
+func TestHIncrBy(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	res, err := rdb.HIncrBy(ctx, "hashIncr", "counter", 5).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != 5 {
+		t.Errorf("Expected '5' but got '%d'", res)
+	}
+
+	f, err := rdb.HIncrByFloat(ctx, "hashIncr", "counter", 0.5).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f != 5.5 {
+		t.Errorf("Expected '5.5' but got '%f'", f)
+	}
+
+	_, err = rdb.HIncrBy(ctx, "hashIncr", "counter", 1).Result()
+	if err == nil || err.Error() != "ERR hash value is not an integer" {
+		t.Errorf("Expected 'hash value is not an integer' but got '%v'", err)
+	}
+
+	// A failing increment doesn't create the hash.
+	_, err = rdb.Do(ctx, "HINCRBYFLOAT", "hashIncrNew", "f", "inf").Result()
+	if err == nil || err.Error() != "ERR increment would produce NaN or Infinity" {
+		t.Errorf("Expected 'increment would produce NaN or Infinity' but got '%v'", err)
+	}
+	if exists, _ := rdb.Exists(ctx, "hashIncrNew").Result(); exists != 0 {
+		t.Errorf("Expected no hash to be created")
+	}
+}
+
+func TestHRandField(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.HSet(ctx, "hashRand", "a", "1", "b", "2", "c", "3")
+
+	fields, err := rdb.HRandField(ctx, "hashRand", 10).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 3 {
+		t.Errorf("Expected 3 distinct fields but got '%v'", fields)
+	}
+
+	fields, err = rdb.HRandField(ctx, "hashRand", -10).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 10 {
+		t.Errorf("Expected 10 fields but got '%d'", len(fields))
+	}
+
+	pairs, err := rdb.HRandFieldWithValues(ctx, "hashRand", 1).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 1 || pairs[0].Value == "" {
+		t.Errorf("Expected a field with its value but got '%v'", pairs)
+	}
+
+	// A huge negative count mustn't allocate for members that aren't there.
+	fields, err = rdb.HRandField(ctx, "hashRandMissing", -2147483647).Result()
+	if err != nil || len(fields) != 0 {
+		t.Errorf("Expected no fields but got '%v' (%v)", fields, err)
+	}
+	members, err := rdb.SRandMemberN(ctx, "setRandMissing", -2147483647).Result()
+	if err != nil || len(members) != 0 {
+		t.Errorf("Expected no members but got '%v' (%v)", members, err)
+	}
+
+	// Nor must a huge positive count, which returns every member.
+	fields, err = rdb.HRandField(ctx, "hashRand", 4611686018427387904).Result()
+	if err != nil || len(fields) != 3 {
+		t.Errorf("Expected every field but got '%v' (%v)", fields, err)
+	}
+	rdb.SAdd(ctx, "setRandHuge", "a", "b")
+	members, err = rdb.SRandMemberN(ctx, "setRandHuge", 4611686018427387904).Result()
+	if err != nil || len(members) != 2 {
+		t.Errorf("Expected every member but got '%v' (%v)", members, err)
+	}
+	members, err = rdb.SPopN(ctx, "setRandHuge", 4611686018427387904).Result()
+	if err != nil || len(members) != 2 {
+		t.Errorf("Expected every member but got '%v' (%v)", members, err)
+	}
+}
+
+func TestHScan(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.HSet(ctx, "hashScan", "user:1", "a", "user:2", "b", "other", "c")
+
+	items, cursor, err := rdb.HScan(ctx, "hashScan", 0, "user:*", 10).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairs := map[string]string{}
+	for i := 0; i+1 < len(items); i += 2 {
+		pairs[items[i]] = items[i+1]
+	}
+	if cursor != 0 || len(items) != 4 || pairs["user:1"] != "a" || pairs["user:2"] != "b" {
+		t.Errorf("Unexpected HSCAN reply '%v' with cursor '%d'", items, cursor)
+	}
+
+	// A large hash is returned a few fields per call.
+	for i := 0; i < 1000; i++ {
+		rdb.HSet(ctx, "hashScanLarge", fmt.Sprint("field", i), i)
+	}
+	seen := map[string]bool{}
+	calls := 0
+	for cursor = 0; ; calls++ {
+		var page []string
+		page, cursor, err = rdb.HScan(ctx, "hashScanLarge", cursor, "", 20).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) > 200 {
+			t.Fatalf("Expected about 20 fields per call but got '%d'", len(page)/2)
+		}
+		for i := 0; i < len(page); i += 2 {
+			seen[page[i]] = true
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 1000 || calls < 10 {
+		t.Errorf("Expected 1000 fields in several calls but got '%d' in '%d'", len(seen), calls)
+	}
+}
+
+func TestHashWrongType(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.RPush(ctx, "hashWrongType", "a")
+
+	_, err := rdb.HSet(ctx, "hashWrongType", "field", "value").Result()
+	if err == nil || err.Error() != "WRONGTYPE Operation against a key holding the wrong kind of value" {
+		t.Errorf("Expected 'WRONGTYPE' but got '%v'", err)
+	}
+}
+
+func TestSAddSRem(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	added, err := rdb.SAdd(ctx, "setTags", "go", "redis", "go").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 2 {
+		t.Errorf("Expected '2' but got '%d'", added)
+	}
+	if card, _ := rdb.SCard(ctx, "setTags").Result(); card != 2 {
+		t.Errorf("Expected '2' but got '%d'", card)
+	}
+	if ok, _ := rdb.SIsMember(ctx, "setTags", "go").Result(); !ok {
+		t.Errorf("Expected 'go' to be a member")
+	}
+	res, err := rdb.SMIsMember(ctx, "setTags", "go", "rust").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(res) != "[true false]" {
+		t.Errorf("Expected '[true false]' but got '%v'", res)
+	}
+
+	removed, err := rdb.SRem(ctx, "setTags", "go", "redis", "rust").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Errorf("Expected '2' but got '%d'", removed)
+	}
+	if exists, _ := rdb.Exists(ctx, "setTags").Result(); exists != 0 {
+		t.Errorf("Expected the key to be deleted")
+	}
+}
+
+func TestSetAlgebra(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.SAdd(ctx, "setA", "a", "b", "c", "1", "2")
+	rdb.SAdd(ctx, "setB", "b", "c", "d", "2")
+	rdb.SAdd(ctx, "setC", "c", "2", "e")
+
+	inter, err := rdb.SInter(ctx, "setA", "setB", "setC").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(inter)
+	if fmt.Sprint(inter) != "[2 c]" {
+		t.Errorf("Expected '[2 c]' but got '%v'", inter)
+	}
+
+	union, _ := rdb.SUnion(ctx, "setB", "setC").Result()
+	sort.Strings(union)
+	if fmt.Sprint(union) != "[2 b c d e]" {
+		t.Errorf("Expected '[2 b c d e]' but got '%v'", union)
+	}
+
+	diff, _ := rdb.SDiff(ctx, "setA", "setB", "setMissing").Result()
+	sort.Strings(diff)
+	if fmt.Sprint(diff) != "[1 a]" {
+		t.Errorf("Expected '[1 a]' but got '%v'", diff)
+	}
+
+	count, err := rdb.SInterStore(ctx, "setDst", "setA", "setB").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("Expected '3' but got '%d'", count)
+	}
+	if card, _ := rdb.SCard(ctx, "setDst").Result(); card != 3 {
+		t.Errorf("Expected '3' but got '%d'", card)
+	}
+
+	card, err := rdb.SInterCard(ctx, 1, "setA", "setB").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card != 1 {
+		t.Errorf("Expected '1' but got '%d'", card)
+	}
+
+	// An empty result deletes the destination.
+	rdb.SDiffStore(ctx, "setDst", "setC", "setA", "setC")
+	if exists, _ := rdb.Exists(ctx, "setDst").Result(); exists != 0 {
+		t.Errorf("Expected the key to be deleted")
+	}
+}
+
+func TestSPopSRandMember(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.SAdd(ctx, "setRandom", "a", "b", "c")
+
+	members, err := rdb.SRandMemberN(ctx, "setRandom", -5).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 5 {
+		t.Errorf("Expected 5 members but got '%v'", members)
+	}
+	members, _ = rdb.SRandMemberN(ctx, "setRandom", 5).Result()
+	if len(members) != 3 {
+		t.Errorf("Expected 3 distinct members but got '%v'", members)
+	}
+
+	popped, err := rdb.SPopN(ctx, "setRandom", 2).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(popped) != 2 {
+		t.Errorf("Expected 2 members but got '%v'", popped)
+	}
+	if card, _ := rdb.SCard(ctx, "setRandom").Result(); card != 1 {
+		t.Errorf("Expected '1' but got '%d'", card)
+	}
+
+	// Popping one at a time drains both encodings of a set.
+	for _, prefix := range []string{"", "member"} {
+		for i := 0; i < 500; i++ {
+			rdb.SAdd(ctx, "setDrain", fmt.Sprint(prefix, i))
+		}
+		members, _ = rdb.SRandMemberN(ctx, "setDrain", 10).Result()
+		if len(members) != 10 {
+			t.Errorf("Expected 10 distinct members but got '%v'", members)
+		}
+		seen := map[string]bool{}
+		for {
+			member, err := rdb.SPop(ctx, "setDrain").Result()
+			if err != nil {
+				break
+			}
+			seen[member] = true
+		}
+		if len(seen) != 500 {
+			t.Errorf("Expected 500 distinct members to be popped but got '%d'", len(seen))
+		}
+	}
+}
+
+func TestSMove(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.SAdd(ctx, "setMoveSrc", "a", "b")
+
+	if ok, err := rdb.SMove(ctx, "setMoveSrc", "setMoveDst", "a").Result(); err != nil || !ok {
+		t.Fatalf("Expected the member to be moved but got '%v' '%v'", ok, err)
+	}
+	if ok, _ := rdb.SMove(ctx, "setMoveSrc", "setMoveDst", "missing").Result(); ok {
+		t.Errorf("Expected a missing member not to be moved")
+	}
+	members, _ := rdb.SMembers(ctx, "setMoveDst").Result()
+	if fmt.Sprint(members) != "[a]" {
+		t.Errorf("Expected '[a]' but got '%v'", members)
+	}
+}
+
+func TestSScan(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.SAdd(ctx, "setScan", "10", "11", "20")
+
+	members, cursor, err := rdb.SScan(ctx, "setScan", 0, "1*", 10).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor != 0 || fmt.Sprint(members) != "[10 11]" {
+		t.Errorf("Unexpected SSCAN reply '%v' with cursor '%d'", members, cursor)
+	}
+
+	// A large set is returned a few members per call.
+	for i := 0; i < 1000; i++ {
+		rdb.SAdd(ctx, "setScanLarge", fmt.Sprint("member", i))
+	}
+	seen := map[string]bool{}
+	calls := 0
+	for cursor = 0; ; calls++ {
+		var page []string
+		page, cursor, err = rdb.SScan(ctx, "setScanLarge", cursor, "", 20).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) > 100 {
+			t.Fatalf("Expected about 20 members per call but got '%d'", len(page))
+		}
+		for _, member := range page {
+			seen[member] = true
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 1000 || calls < 10 {
+		t.Errorf("Expected 1000 members in several calls but got '%d' in '%d'", len(seen), calls)
+	}
+}
+
+func TestZAdd(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	added, err := rdb.ZAdd(ctx, "zsetBoard", redis.Z{Score: 10, Member: "alice"}, redis.Z{Score: 20, Member: "bob"}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 2 {
+		t.Errorf("Expected '2' but got '%d'", added)
+	}
+
+	// NX never updates, XX never adds.
+	added, _ = rdb.ZAddNX(ctx, "zsetBoard", redis.Z{Score: 99, Member: "alice"}, redis.Z{Score: 5, Member: "carol"}).Result()
+	if added != 1 {
+		t.Errorf("Expected '1' but got '%d'", added)
+	}
+	added, _ = rdb.ZAddXX(ctx, "zsetBoard", redis.Z{Score: 15, Member: "alice"}, redis.Z{Score: 1, Member: "dave"}).Result()
+	if added != 0 {
+		t.Errorf("Expected '0' but got '%d'", added)
+	}
+
+	// GT only raises scores, CH counts the changed members.
+	changed, err := rdb.ZAddArgs(ctx, "zsetBoard", redis.ZAddArgs{GT: true, Ch: true,
+		Members: []redis.Z{{Score: 25, Member: "bob"}, {Score: 1, Member: "alice"}}}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed != 1 {
+		t.Errorf("Expected '1' but got '%d'", changed)
+	}
+
+	score, err := rdb.ZAddArgsIncr(ctx, "zsetBoard", redis.ZAddArgs{Members: []redis.Z{{Score: 2.5, Member: "carol"}}}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if score != 7.5 {
+		t.Errorf("Expected '7.5' but got '%v'", score)
+	}
+	if _, err := rdb.ZAddArgsIncr(ctx, "zsetBoard", redis.ZAddArgs{NX: true, Members: []redis.Z{{Score: 1, Member: "carol"}}}).Result(); err != redis.Nil {
+		t.Errorf("Expected a nil reply but got '%v'", err)
+	}
+
+	if score, _ := rdb.ZIncrBy(ctx, "zsetBoard", -5, "alice").Result(); score != 10 {
+		t.Errorf("Expected '10' but got '%v'", score)
+	}
+	if score, _ := rdb.ZScore(ctx, "zsetBoard", "bob").Result(); score != 25 {
+		t.Errorf("Expected '25' but got '%v'", score)
+	}
+	scores, err := rdb.ZMScore(ctx, "zsetBoard", "carol", "missing").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(scores) != "[7.5 0]" {
+		t.Errorf("Expected '[7.5 0]' but got '%v'", scores)
+	}
+	if card, _ := rdb.ZCard(ctx, "zsetBoard").Result(); card != 3 {
+		t.Errorf("Expected '3' but got '%d'", card)
+	}
+
+	if err := rdb.Do(ctx, "ZADD", "zsetBoard", "NX", "XX", "1", "a").Err(); err == nil || err.Error() != "ERR XX and NX options at the same time are not compatible" {
+		t.Errorf("Unexpected error '%v'", err)
+	}
+	if err := rdb.Do(ctx, "ZADD", "zsetBoard", "nope", "a").Err(); err == nil || err.Error() != "ERR value is not a valid float" {
+		t.Errorf("Unexpected error '%v'", err)
+	}
+
+	removed, _ := rdb.ZRem(ctx, "zsetBoard", "alice", "bob", "carol", "missing").Result()
+	if removed != 3 {
+		t.Errorf("Expected '3' but got '%d'", removed)
+	}
+	if exists, _ := rdb.Exists(ctx, "zsetBoard").Result(); exists != 0 {
+		t.Errorf("Expected the key to be deleted")
+	}
+}
+
+func TestZRange(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.ZAdd(ctx, "zsetRange", redis.Z{Score: 1, Member: "a"}, redis.Z{Score: 2, Member: "b"},
+		redis.Z{Score: 3, Member: "c"}, redis.Z{Score: 4, Member: "d"}, redis.Z{Score: 5, Member: "e"})
+
+	members, err := rdb.ZRange(ctx, "zsetRange", 1, -2).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(members) != "[b c d]" {
+		t.Errorf("Expected '[b c d]' but got '%v'", members)
+	}
+	members, _ = rdb.ZRevRange(ctx, "zsetRange", 0, 1).Result()
+	if fmt.Sprint(members) != "[e d]" {
+		t.Errorf("Expected '[e d]' but got '%v'", members)
+	}
+
+	withScores, err := rdb.ZRangeArgsWithScores(ctx, redis.ZRangeArgs{Key: "zsetRange", Start: "(1", Stop: "+inf",
+		ByScore: true, Offset: 1, Count: 2}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(withScores) != "[{3 c} {4 d}]" {
+		t.Errorf("Expected '[{3 c} {4 d}]' but got '%v'", withScores)
+	}
+	members, _ = rdb.ZRangeArgs(ctx, redis.ZRangeArgs{Key: "zsetRange", Start: "2", Stop: "4", ByScore: true, Rev: true}).Result()
+	if fmt.Sprint(members) != "[d c b]" {
+		t.Errorf("Expected '[d c b]' but got '%v'", members)
+	}
+	members, _ = rdb.ZRangeByLex(ctx, "zsetRange", &redis.ZRangeBy{Min: "(a", Max: "[c"}).Result()
+	if fmt.Sprint(members) != "[b c]" {
+		t.Errorf("Expected '[b c]' but got '%v'", members)
+	}
+	members, _ = rdb.ZRevRangeByScore(ctx, "zsetRange", &redis.ZRangeBy{Min: "-inf", Max: "(3"}).Result()
+	if fmt.Sprint(members) != "[b a]" {
+		t.Errorf("Expected '[b a]' but got '%v'", members)
+	}
+
+	if count, _ := rdb.ZCount(ctx, "zsetRange", "2", "(5").Result(); count != 3 {
+		t.Errorf("Expected '3' but got '%d'", count)
+	}
+	if rank, _ := rdb.ZRank(ctx, "zsetRange", "c").Result(); rank != 2 {
+		t.Errorf("Expected '2' but got '%d'", rank)
+	}
+	if rank, _ := rdb.ZRevRank(ctx, "zsetRange", "a").Result(); rank != 4 {
+		t.Errorf("Expected '4' but got '%d'", rank)
+	}
+	if _, err := rdb.ZRank(ctx, "zsetRange", "missing").Result(); err != redis.Nil {
+		t.Errorf("Expected a nil reply but got '%v'", err)
+	}
+
+	stored, err := rdb.ZRangeStore(ctx, "zsetRangeDst", redis.ZRangeArgs{Key: "zsetRange", Start: 0, Stop: 1, Rev: true}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != 2 {
+		t.Errorf("Expected '2' but got '%d'", stored)
+	}
+
+	if err := rdb.Do(ctx, "ZRANGE", "zsetRange", "0", "1", "LIMIT", "0", "1").Err(); err == nil ||
+		err.Error() != "ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX" {
+		t.Errorf("Unexpected error '%v'", err)
+	}
+	if err := rdb.Do(ctx, "ZRANGE", "zsetRange", "(x", "1", "BYSCORE").Err(); err == nil || err.Error() != "ERR min or max is not a float" {
+		t.Errorf("Unexpected error '%v'", err)
+	}
+}
+
+func TestZPopAndRemRange(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.ZAdd(ctx, "zsetPop", redis.Z{Score: 1, Member: "a"}, redis.Z{Score: 2, Member: "b"},
+		redis.Z{Score: 3, Member: "c"}, redis.Z{Score: 4, Member: "d"}, redis.Z{Score: 5, Member: "e"})
+
+	popped, err := rdb.ZPopMin(ctx, "zsetPop").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(popped) != "[{1 a}]" {
+		t.Errorf("Expected '[{1 a}]' but got '%v'", popped)
+	}
+	popped, _ = rdb.ZPopMax(ctx, "zsetPop", 2).Result()
+	if fmt.Sprint(popped) != "[{5 e} {4 d}]" {
+		t.Errorf("Expected '[{5 e} {4 d}]' but got '%v'", popped)
+	}
+
+	rdb.ZAdd(ctx, "zsetPop", redis.Z{Score: 10, Member: "x"}, redis.Z{Score: 20, Member: "y"})
+	if removed, _ := rdb.ZRemRangeByScore(ctx, "zsetPop", "(2", "10").Result(); removed != 2 {
+		t.Errorf("Expected '2' but got '%d'", removed)
+	}
+	if removed, _ := rdb.ZRemRangeByRank(ctx, "zsetPop", -1, -1).Result(); removed != 1 {
+		t.Errorf("Expected '1' but got '%d'", removed)
+	}
+	if removed, _ := rdb.ZRemRangeByLex(ctx, "zsetPop", "-", "+").Result(); removed != 1 {
+		t.Errorf("Expected '1' but got '%d'", removed)
+	}
+	if exists, _ := rdb.Exists(ctx, "zsetPop").Result(); exists != 0 {
+		t.Errorf("Expected the key to be deleted")
+	}
+}
+
+func TestZUnionInterStore(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.ZAdd(ctx, "zsetStoreA", redis.Z{Score: 1, Member: "a"}, redis.Z{Score: 2, Member: "b"})
+	rdb.ZAdd(ctx, "zsetStoreB", redis.Z{Score: 10, Member: "b"}, redis.Z{Score: 20, Member: "c"})
+	rdb.SAdd(ctx, "zsetStorePlain", "a", "b")
+
+	count, err := rdb.ZUnionStore(ctx, "zsetStoreDst", &redis.ZStore{Keys: []string{"zsetStoreA", "zsetStoreB"}, Weights: []float64{2, 1}}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("Expected '3' but got '%d'", count)
+	}
+	members, _ := rdb.ZRangeWithScores(ctx, "zsetStoreDst", 0, -1).Result()
+	if fmt.Sprint(members) != "[{2 a} {14 b} {20 c}]" {
+		t.Errorf("Expected '[{2 a} {14 b} {20 c}]' but got '%v'", members)
+	}
+
+	count, err = rdb.ZInterStore(ctx, "zsetStoreDst", &redis.ZStore{Keys: []string{"zsetStoreB", "zsetStorePlain"}, Aggregate: "MAX"}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("Expected '1' but got '%d'", count)
+	}
+	members, _ = rdb.ZRangeWithScores(ctx, "zsetStoreDst", 0, -1).Result()
+	if fmt.Sprint(members) != "[{10 b}]" {
+		t.Errorf("Expected '[{10 b}]' but got '%v'", members)
+	}
+
+	rdb.Set(ctx, "zsetStoreString", "value", 0)
+	if err := rdb.ZUnionStore(ctx, "zsetStoreDst", &redis.ZStore{Keys: []string{"zsetStoreString"}}).Err(); err == nil ||
+		err.Error() != "WRONGTYPE Operation against a key holding the wrong kind of value" {
+		t.Errorf("Unexpected error '%v'", err)
+	}
+}
+
+func TestZScan(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.ZAdd(ctx, "zsetScan", redis.Z{Score: 2, Member: "ab"}, redis.Z{Score: 1, Member: "aa"}, redis.Z{Score: 3, Member: "b"})
+
+	items, cursor, err := rdb.ZScan(ctx, "zsetScan", 0, "a*", 10).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	scores := map[string]string{}
+	for i := 0; i+1 < len(items); i += 2 {
+		scores[items[i]] = items[i+1]
+	}
+	if cursor != 0 || len(items) != 4 || scores["aa"] != "1" || scores["ab"] != "2" {
+		t.Errorf("Unexpected ZSCAN reply '%v' with cursor '%d'", items, cursor)
+	}
+
+	// A large sorted set is returned a few members per call.
+	for i := 0; i < 1000; i++ {
+		rdb.ZAdd(ctx, "zsetScanLarge", redis.Z{Score: float64(i), Member: fmt.Sprint("member", i)})
+	}
+	seen := map[string]bool{}
+	calls := 0
+	for cursor = 0; ; calls++ {
+		var page []string
+		page, cursor, err = rdb.ZScan(ctx, "zsetScanLarge", cursor, "", 20).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) > 200 {
+			t.Fatalf("Expected about 20 members per call but got '%d'", len(page)/2)
+		}
+		for i := 0; i < len(page); i += 2 {
+			seen[page[i]] = true
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 1000 || calls < 10 {
+		t.Errorf("Expected 1000 members in several calls but got '%d' in '%d'", len(seen), calls)
+	}
+}
+
+func TestAppendStrLenRange(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	if length, _ := rdb.Append(ctx, "strAppend", "Hello").Result(); length != 5 {
+		t.Errorf("Expected '5' but got '%d'", length)
+	}
+	if length, _ := rdb.Append(ctx, "strAppend", " World").Result(); length != 11 {
+		t.Errorf("Expected '11' but got '%d'", length)
+	}
+	if length, _ := rdb.StrLen(ctx, "strAppend").Result(); length != 11 {
+		t.Errorf("Expected '11' but got '%d'", length)
+	}
+	if val, _ := rdb.GetRange(ctx, "strAppend", -5, -1).Result(); val != "World" {
+		t.Errorf("Expected 'World' but got '%s'", val)
+	}
+	if val, _ := rdb.Do(ctx, "SUBSTR", "strAppend", "0", "100").Text(); val != "Hello World" {
+		t.Errorf("Expected 'Hello World' but got '%s'", val)
+	}
+
+	if length, _ := rdb.SetRange(ctx, "strAppend", 6, "Redis").Result(); length != 11 {
+		t.Errorf("Expected '11' but got '%d'", length)
+	}
+	if length, _ := rdb.SetRange(ctx, "strPadded", 3, "x").Result(); length != 4 {
+		t.Errorf("Expected '4' but got '%d'", length)
+	}
+	if val, _ := rdb.Get(ctx, "strPadded").Result(); val != "\x00\x00\x00x" {
+		t.Errorf("Expected a zero padded string but got '%q'", val)
+	}
+	for _, offset := range []int64{512 * 1024 * 1024, math.MaxInt64} {
+		if err := rdb.SetRange(ctx, "strPadded", offset, "x").Err(); err == nil ||
+			err.Error() != "ERR string exceeds maximum allowed size (proto-max-bulk-len)" {
+			t.Errorf("Unexpected error '%v' for offset '%d'", err, offset)
+		}
+	}
+}
+
+func TestMSetMGet(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	if err := rdb.MSet(ctx, "strMulti1", "a", "strMulti2", "b").Err(); err != nil {
+		t.Fatal(err)
+	}
+	rdb.RPush(ctx, "strMultiList", "x")
+	vals, err := rdb.MGet(ctx, "strMulti1", "strMultiMissing", "strMulti2", "strMultiList").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(vals) != "[a <nil> b <nil>]" {
+		t.Errorf("Expected '[a <nil> b <nil>]' but got '%v'", vals)
+	}
+
+	if ok, _ := rdb.MSetNX(ctx, "strMulti1", "c", "strMulti3", "c").Result(); ok {
+		t.Errorf("Expected MSETNX to fail when a key exists")
+	}
+	if exists, _ := rdb.Exists(ctx, "strMulti3").Result(); exists != 0 {
+		t.Errorf("Expected no key to be set")
+	}
+	if ok, _ := rdb.MSetNX(ctx, "strMulti3", "c", "strMulti4", "d").Result(); !ok {
+		t.Errorf("Expected MSETNX to succeed")
+	}
+
+	if err := rdb.Do(ctx, "MSET", "strMulti1", "a", "strMulti2").Err(); err == nil ||
+		err.Error() != "ERR wrong number of arguments for 'mset' command" {
+		t.Errorf("Unexpected error '%v'", err)
+	}
+}
+
+func TestIncrByDecrBy(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	if res, _ := rdb.IncrBy(ctx, "strCounter", 10).Result(); res != 10 {
+		t.Errorf("Expected '10' but got '%d'", res)
+	}
+	if res, _ := rdb.DecrBy(ctx, "strCounter", 15).Result(); res != -5 {
+		t.Errorf("Expected '-5' but got '%d'", res)
+	}
+	if _, err := rdb.IncrBy(ctx, "strCounter", math.MinInt64).Result(); err == nil ||
+		err.Error() != "ERR increment or decrement would overflow" {
+		t.Errorf("Unexpected error '%v'", err)
+	}
+
+	res, err := rdb.IncrByFloat(ctx, "strCounter", 5.5).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != 0.5 {
+		t.Errorf("Expected '0.5' but got '%v'", res)
+	}
+	if val, _ := rdb.Get(ctx, "strCounter").Result(); val != "0.5" {
+		t.Errorf("Expected '0.5' but got '%s'", val)
+	}
+	if _, err := rdb.Incr(ctx, "strCounter").Result(); err == nil || err.Error() != "ERR value is not an integer or out of range" {
+		t.Errorf("Unexpected error '%v'", err)
+	}
+}
+
+func TestGetSetGetDelGetEx(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	if _, err := rdb.GetSet(ctx, "strSwap", "first").Result(); err != redis.Nil {
+		t.Errorf("Expected a nil reply but got '%v'", err)
+	}
+	if old, _ := rdb.GetSet(ctx, "strSwap", "second").Result(); old != "first" {
+		t.Errorf("Expected 'first' but got '%s'", old)
+	}
+
+	val, err := rdb.GetEx(ctx, "strSwap", 10*time.Second).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != "second" {
+		t.Errorf("Expected 'second' but got '%s'", val)
+	}
+	rdb.Do(ctx, "GETEX", "strSwap", "PX", "100")
+	time.Sleep(200 * time.Millisecond)
+	if _, err := rdb.Get(ctx, "strSwap").Result(); err != redis.Nil {
+		t.Errorf("Expected the key to expire but got '%v'", err)
+	}
+
+	rdb.Set(ctx, "strGetDel", "value", 0)
+	if val, _ := rdb.GetDel(ctx, "strGetDel").Result(); val != "value" {
+		t.Errorf("Expected 'value' but got '%s'", val)
+	}
+	if exists, _ := rdb.Exists(ctx, "strGetDel").Result(); exists != 0 {
+		t.Errorf("Expected the key to be deleted")
+	}
+}
+
+func TestSetNXSetEx(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	if ok, _ := rdb.SetNX(ctx, "strOnce", "a", 0).Result(); !ok {
+		t.Errorf("Expected SETNX to set the key")
+	}
+	if ok, _ := rdb.SetNX(ctx, "strOnce", "b", 0).Result(); ok {
+		t.Errorf("Expected SETNX not to overwrite the key")
+	}
+
+	if err := rdb.SetEx(ctx, "strTemp", "a", time.Second).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rdb.Do(ctx, "PSETEX", "strTempMs", "100", "b").Err(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if _, err := rdb.Get(ctx, "strTempMs").Result(); err != redis.Nil {
+		t.Errorf("Expected the key to expire but got '%v'", err)
+	}
+	if val, _ := rdb.Get(ctx, "strTemp").Result(); val != "a" {
+		t.Errorf("Expected 'a' but got '%s'", val)
+	}
+	if err := rdb.Do(ctx, "SETEX", "strTemp", "0", "a").Err(); err == nil {
+		t.Errorf("Expected an error for a non-positive expiry")
+	}
+}
+
+func TestHugeExpiry(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	var tests = []struct {
+		args []interface{}
+		want string
+	}{
+		// the table itself
+		{[]interface{}{"getex", "hugeExpiry", "ex", "9223372036854775"}, "ERR invalid expire time in 'getex' command"},
+		{[]interface{}{"getex", "hugeExpiry", "px", "9223372036854775800"}, "ERR invalid expire time in 'getex' command"},
+		{[]interface{}{"setex", "hugeExpiry", "9223372036854775", "b"}, "ERR invalid expire time in 'setex' command"},
+		{[]interface{}{"psetex", "hugeExpiry", "9223372036854775800", "b"}, "ERR invalid expire time in 'psetex' command"},
+		{[]interface{}{"set", "hugeExpiry", "b", "px", "9223372036854775800"}, "ERR invalid expire time in 'set' command"},
+		{[]interface{}{"set", "hugeExpiry", "b", "ex", "9223372036854775"}, "ERR invalid expire time in 'set' command"},
+		{[]interface{}{"set", "hugeExpiry", "b", "exat", "9223372036854776"}, "ERR invalid expire time in 'set' command"},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.args...), func(t *testing.T) {
+			rdb.Set(ctx, "hugeExpiry", "a", 0)
+			if err := rdb.Do(ctx, test.args...).Err(); err == nil || err.Error() != test.want {
+				t.Errorf("Expected '%s' but got '%v'", test.want, err)
+			}
+			// The key is left as it was.
+			if val, err := rdb.Get(ctx, "hugeExpiry").Result(); err != nil || val != "a" {
+				t.Errorf("Expected 'a' but got '%s' (%v)", val, err)
+			}
+			if ttl, _ := rdb.TTL(ctx, "hugeExpiry").Result(); ttl != -1 {
+				t.Errorf("Expected no expiry but got '%v'", ttl)
+			}
+		})
+	}
+}
+
+func TestLCS(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.MSet(ctx, "strLCS1", "ohmytext", "strLCS2", "mynewtext")
+
+	res, err := rdb.LCS(ctx, &redis.LCSQuery{Key1: "strLCS1", Key2: "strLCS2"}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.MatchString != "mytext" {
+		t.Errorf("Expected 'mytext' but got '%s'", res.MatchString)
+	}
+	res, _ = rdb.LCS(ctx, &redis.LCSQuery{Key1: "strLCS1", Key2: "strLCS2", Len: true}).Result()
+	if res.Len != 6 {
+		t.Errorf("Expected '6' but got '%d'", res.Len)
+	}
+
+	res, err = rdb.LCS(ctx, &redis.LCSQuery{Key1: "strLCS1", Key2: "strLCS2", Idx: true, MinMatchLen: 3, WithMatchLen: true}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Len != 6 || fmt.Sprint(res.Matches) != "[{{4 7} {5 8} 4}]" {
+		t.Errorf("Unexpected LCS matches '%v' with length '%d'", res.Matches, res.Len)
+	}
+}
+
+func TestSetOptions(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	// The usual distributed lock.
+	ok, err := rdb.SetNX(ctx, "setLock", "token1", 30*time.Second).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Errorf("Expected the lock to be acquired")
+	}
+	if _, err := rdb.Do(ctx, "SET", "setLock", "token2", "NX", "PX", "30000").Result(); err != redis.Nil {
+		t.Errorf("Expected a nil reply but got '%v'", err)
+	}
+
+	if _, err := rdb.Do(ctx, "SET", "setMissing", "v", "XX").Result(); err != redis.Nil {
+		t.Errorf("Expected a nil reply but got '%v'", err)
+	}
+	if exists, _ := rdb.Exists(ctx, "setMissing").Result(); exists != 0 {
+		t.Errorf("Expected XX not to create the key")
+	}
+
+	// Options may come in any order and GET returns the previous value.
+	rdb.Set(ctx, "setGet", "old", 0)
+	old, err := rdb.Do(ctx, "SET", "setGet", "new", "GET", "EX", "100", "XX").Text()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old != "old" {
+		t.Errorf("Expected 'old' but got '%s'", old)
+	}
+	if val, _ := rdb.Get(ctx, "setGet").Result(); val != "new" {
+		t.Errorf("Expected 'new' but got '%s'", val)
+	}
+
+	// KEEPTTL retains the expiry, a plain SET removes it.
+	rdb.Set(ctx, "setKeepTTL", "a", 200*time.Millisecond)
+	rdb.Do(ctx, "SET", "setKeepTTL", "b", "KEEPTTL")
+	time.Sleep(300 * time.Millisecond)
+	if _, err := rdb.Get(ctx, "setKeepTTL").Result(); err != redis.Nil {
+		t.Errorf("Expected the key to expire but got '%v'", err)
+	}
+
+	// A failed expiry parse must not store anything.
+	if err := rdb.Do(ctx, "SET", "setBadExpiry", "v", "EX", "nope").Err(); err == nil {
+		t.Errorf("Expected an error for an invalid expiry")
+	}
+	if exists, _ := rdb.Exists(ctx, "setBadExpiry").Result(); exists != 0 {
+		t.Errorf("Expected the key not to be stored")
+	}
+
+	// the table itself
+	invalid := [][]interface{}{
+		{"SET", "k", "v", "NX", "XX"},
+		{"SET", "k", "v", "EX", "10", "PX", "100"},
+		{"SET", "k", "v", "KEEPTTL", "EX", "10"},
+		{"SET", "k", "v", "EX"},
+		{"SET", "k", "v", "BOGUS"},
+	}
+	for _, args := range invalid {
+		if err := rdb.Do(ctx, args...).Err(); err == nil || err.Error() != "ERR syntax error" {
+			t.Errorf("Expected a syntax error for '%v' but got '%v'", args, err)
+		}
+	}
+
+	rdb.RPush(ctx, "setGetList", "x")
+	if err := rdb.Do(ctx, "SET", "setGetList", "v", "GET").Err(); err == nil ||
+		err.Error() != "WRONGTYPE Operation against a key holding the wrong kind of value" {
+		t.Errorf("Unexpected error '%v'", err)
+	}
+}
+
+func TestExpireTTL(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.RPush(ctx, "expireList", "a", "b")
+	if ttl, _ := rdb.TTL(ctx, "expireList").Result(); ttl != -1 {
+		t.Errorf("Expected '-1' but got '%v'", ttl)
+	}
+	if ttl, _ := rdb.TTL(ctx, "expireMissing").Result(); ttl != -2 {
+		t.Errorf("Expected '-2' but got '%v'", ttl)
+	}
+
+	if ok, err := rdb.Expire(ctx, "expireList", 100*time.Second).Result(); err != nil || !ok {
+		t.Fatalf("Expected the expiry to be set but got '%v' '%v'", ok, err)
+	}
+	if ttl, _ := rdb.TTL(ctx, "expireList").Result(); ttl != 100*time.Second {
+		t.Errorf("Expected '100s' but got '%v'", ttl)
+	}
+	if ttl, _ := rdb.PTTL(ctx, "expireList").Result(); ttl <= 99*time.Second || ttl > 100*time.Second {
+		t.Errorf("Expected about '100s' but got '%v'", ttl)
+	}
+	if ok, _ := rdb.Expire(ctx, "expireMissing", time.Second).Result(); ok {
+		t.Errorf("Expected no expiry on a missing key")
+	}
+
+	// NX only sets a first expiry, GT only extends it, LT only shortens it.
+	if ok, _ := rdb.ExpireNX(ctx, "expireList", 200*time.Second).Result(); ok {
+		t.Errorf("Expected NX to fail on a volatile key")
+	}
+	if ok, _ := rdb.ExpireGT(ctx, "expireList", 50*time.Second).Result(); ok {
+		t.Errorf("Expected GT to fail on a shorter expiry")
+	}
+	if ok, _ := rdb.ExpireLT(ctx, "expireList", 50*time.Second).Result(); !ok {
+		t.Errorf("Expected LT to shorten the expiry")
+	}
+	if err := rdb.Do(ctx, "EXPIRE", "expireList", "10", "NX", "GT").Err(); err == nil ||
+		err.Error() != "ERR NX and XX, GT or LT options at the same time are not compatible" {
+		t.Errorf("Unexpected error '%v'", err)
+	}
+
+	at := time.Now().Add(time.Hour).Truncate(time.Second)
+	rdb.ExpireAt(ctx, "expireList", at)
+	if ts, _ := rdb.ExpireTime(ctx, "expireList").Result(); ts != time.Duration(at.Unix())*time.Second {
+		t.Errorf("Expected '%d' but got '%v'", at.Unix(), ts)
+	}
+	if ts, _ := rdb.Do(ctx, "PEXPIRETIME", "expireList").Int64(); ts != at.UnixMilli() {
+		t.Errorf("Expected '%d' but got '%d'", at.UnixMilli(), ts)
+	}
+
+	if ok, _ := rdb.Persist(ctx, "expireList").Result(); !ok {
+		t.Errorf("Expected the expiry to be removed")
+	}
+	if ok, _ := rdb.Persist(ctx, "expireList").Result(); ok {
+		t.Errorf("Expected nothing to persist")
+	}
+
+	rdb.HSet(ctx, "expireHash", "f", "v")
+	rdb.PExpire(ctx, "expireHash", 100*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	if ttl, _ := rdb.TTL(ctx, "expireHash").Result(); ttl != -2 {
+		t.Errorf("Expected the hash to expire but got '%v'", ttl)
+	}
+
+	// An expiry in the past deletes the key.
+	rdb.Set(ctx, "expirePast", "v", 0)
+	if ok, _ := rdb.PExpireAt(ctx, "expirePast", time.Unix(1, 0)).Result(); !ok {
+		t.Errorf("Expected the expiry to be set")
+	}
+	if exists, _ := rdb.Exists(ctx, "expirePast").Result(); exists != 0 {
+		t.Errorf("Expected the key to be deleted")
+	}
+}
+
+func TestLazyExpiry(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.Set(ctx, "lazyCounter", "41", 50*time.Millisecond)
+	rdb.RPush(ctx, "lazyList", "stale")
+	rdb.PExpire(ctx, "lazyList", 50*time.Millisecond)
+	rdb.Set(ctx, "lazyDel", "v", 50*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+
+	// An expired counter starts over instead of counting from the stale value.
+	if res, _ := rdb.Incr(ctx, "lazyCounter").Result(); res != 1 {
+		t.Errorf("Expected '1' but got '%d'", res)
+	}
+	if exists, _ := rdb.Exists(ctx, "lazyList").Result(); exists != 0 {
+		t.Errorf("Expected the list to be expired")
+	}
+	if length, _ := rdb.LPush(ctx, "lazyList", "fresh").Result(); length != 1 {
+		t.Errorf("Expected '1' but got '%d'", length)
+	}
+	if ttl, _ := rdb.TTL(ctx, "lazyList").Result(); ttl != -1 {
+		t.Errorf("Expected no expiry on the new list but got '%v'", ttl)
+	}
+	if deleted, _ := rdb.Del(ctx, "lazyDel").Result(); deleted != 0 {
+		t.Errorf("Expected '0' but got '%d'", deleted)
+	}
+}
+
+func TestActiveExpiry(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	for i := 0; i < 100; i++ {
+		rdb.Set(ctx, fmt.Sprintf("activeExpiry:%d", i), "v", 20*time.Millisecond)
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	// Nothing reads the keys, so only the active expirer can have deleted them.
+	info, _ := rdb.Info(ctx, "stats").Result()
+	var expired int
+	for _, line := range strings.Split(info, "\r\n") {
+		if v, ok := strings.CutPrefix(line, "expired_keys:"); ok {
+			expired, _ = strconv.Atoi(v)
+		}
+	}
+	if expired < 100 {
+		t.Errorf("Expected at least '100' expired keys but got '%d'", expired)
+	}
+	if !strings.Contains(info, "expired_stale_perc:") {
+		t.Errorf("Expected expired_stale_perc in '%v'", info)
+	}
+
+	keys, _ := rdb.Info(ctx, "keyspace").Result()
+	if !strings.HasPrefix(keys, "# Keyspace") {
+		t.Errorf("Expected the keyspace section but got '%v'", keys)
+	}
+}
+
+func TestKeysScanType(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	for i := 0; i < 200; i++ {
+		rdb.Set(ctx, fmt.Sprintf("scanString:%d", i), "v", 0)
+	}
+	rdb.RPush(ctx, "scanList:1", "a")
+	rdb.SAdd(ctx, "scanSet:1", "a")
+
+	keys, _ := rdb.Keys(ctx, "scan[LS][ie]*:1").Result()
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "scanList:1" || keys[1] != "scanSet:1" {
+		t.Errorf("Expected '[scanList:1 scanSet:1]' but got '%v'", keys)
+	}
+
+	seen := map[string]bool{}
+	var cursor uint64
+	for {
+		var page []string
+		page, cursor, _ = rdb.Scan(ctx, cursor, "scanString:*", 20).Result()
+		for _, key := range page {
+			seen[key] = true
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 200 {
+		t.Errorf("Expected '200' keys but got '%d'", len(seen))
+	}
+
+	lists, _, _ := rdb.ScanType(ctx, 0, "scan*", 1000000, "list").Result()
+	if len(lists) != 1 || lists[0] != "scanList:1" {
+		t.Errorf("Expected '[scanList:1]' but got '%v'", lists)
+	}
+
+	types := map[string]string{"scanString:1": "string", "scanList:1": "list", "scanSet:1": "set", "scanMissing": "none"}
+	for key, expected := range types {
+		if res, _ := rdb.Type(ctx, key).Result(); res != expected {
+			t.Errorf("Expected '%s' but got '%v'", expected, res)
+		}
+	}
+
+	if size, _ := rdb.DBSize(ctx).Result(); size < 202 {
+		t.Errorf("Expected at least '202' keys but got '%d'", size)
+	}
+	key, err := rdb.RandomKey(ctx).Result()
+	if err != nil || key == "" {
+		t.Errorf("Expected a key but got '%v' (%v)", key, err)
+	}
+}
+
+func TestRenameCopy(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.Set(ctx, "renameSrc", "v1", time.Minute)
+	rdb.Set(ctx, "renameDst", "old", 0)
+	if res, err := rdb.Rename(ctx, "renameSrc", "renameDst").Result(); err != nil || res != "OK" {
+		t.Errorf("Expected 'OK' but got '%v' (%v)", res, err)
+	}
+	if res, _ := rdb.Get(ctx, "renameDst").Result(); res != "v1" {
+		t.Errorf("Expected 'v1' but got '%v'", res)
+	}
+	if ttl, _ := rdb.TTL(ctx, "renameDst").Result(); ttl <= 0 {
+		t.Errorf("Expected the expiry to be carried over but got '%v'", ttl)
+	}
+	if _, err := rdb.Rename(ctx, "renameSrc", "renameDst").Result(); err == nil || err.Error() != "ERR no such key" {
+		t.Errorf("Expected 'ERR no such key' but got '%v'", err)
+	}
+
+	rdb.Set(ctx, "renameNXSrc", "v", 0)
+	if res, _ := rdb.RenameNX(ctx, "renameNXSrc", "renameDst").Result(); res {
+		t.Errorf("Expected 'false' but got '%v'", res)
+	}
+	if res, _ := rdb.RenameNX(ctx, "renameNXSrc", "renameNXDst").Result(); !res {
+		t.Errorf("Expected 'true' but got '%v'", res)
+	}
+
+	rdb.RPush(ctx, "copySrc", "a", "b")
+	if res, _ := rdb.Copy(ctx, "copySrc", "copyDst", 0, false).Result(); res != 1 {
+		t.Errorf("Expected '1' but got '%v'", res)
+	}
+	rdb.RPush(ctx, "copyDst", "c")
+	if res, _ := rdb.LRange(ctx, "copySrc", 0, -1).Result(); len(res) != 2 {
+		t.Errorf("Expected the source to be unchanged but got '%v'", res)
+	}
+	if res, _ := rdb.Copy(ctx, "copySrc", "copyDst", 0, false).Result(); res != 0 {
+		t.Errorf("Expected '0' but got '%v'", res)
+	}
+	if res, _ := rdb.Copy(ctx, "copySrc", "copyDst", 0, true).Result(); res != 1 {
+		t.Errorf("Expected '1' but got '%v'", res)
+	}
+	if res, _ := rdb.LLen(ctx, "copyDst").Result(); res != 2 {
+		t.Errorf("Expected '2' but got '%v'", res)
+	}
+
+	if res, _ := rdb.Touch(ctx, "copySrc", "copyDst", "touchMissing").Result(); res != 2 {
+		t.Errorf("Expected '2' but got '%v'", res)
+	}
+	if res, _ := rdb.Unlink(ctx, "copySrc", "copyDst", "touchMissing").Result(); res != 2 {
+		t.Errorf("Expected '2' but got '%v'", res)
+	}
+}
+
+func TestSelectMoveSwapDB(t *testing.T) {
+	ctx := context.Background()
+	db1 := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       1,
+	})
+	db2 := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       2,
+	})
+	db1.FlushDB(ctx)
+	db2.FlushDB(ctx)
+
+	db1.Set(ctx, "dbKey", "one", 0)
+	if _, err := db2.Get(ctx, "dbKey").Result(); err != redis.Nil {
+		t.Errorf("Expected the key to be missing in DB 2 but got '%v'", err)
+	}
+
+	db1.Set(ctx, "moveKey", "v", time.Minute)
+	if res, _ := db1.Move(ctx, "moveKey", 2).Result(); !res {
+		t.Errorf("Expected 'true' but got '%v'", res)
+	}
+	if ttl, _ := db2.TTL(ctx, "moveKey").Result(); ttl <= 0 {
+		t.Errorf("Expected the expiry to be moved but got '%v'", ttl)
+	}
+	db1.Set(ctx, "moveKey", "again", 0)
+	if res, _ := db1.Move(ctx, "moveKey", 2).Result(); res {
+		t.Errorf("Expected 'false' but got '%v'", res)
+	}
+	if _, err := db1.Move(ctx, "moveKey", 16).Result(); err == nil || err.Error() != "ERR DB index is out of range" {
+		t.Errorf("Expected 'ERR DB index is out of range' but got '%v'", err)
+	}
+
+	if res, _ := db1.Copy(ctx, "dbKey", "copiedKey", 2, false).Result(); res != 1 {
+		t.Errorf("Expected '1' but got '%v'", res)
+	}
+	if res, _ := db2.Get(ctx, "copiedKey").Result(); res != "one" {
+		t.Errorf("Expected 'one' but got '%v'", res)
+	}
+
+	if res, _ := db1.Do(ctx, "SWAPDB", 1, 2).Result(); res != "OK" {
+		t.Errorf("Expected 'OK' but got '%v'", res)
+	}
+	if res, _ := db1.Get(ctx, "copiedKey").Result(); res != "one" {
+		t.Errorf("Expected 'one' but got '%v'", res)
+	}
+	if res, _ := db2.Get(ctx, "moveKey").Result(); res != "again" {
+		t.Errorf("Expected 'again' but got '%v'", res)
+	}
+	if _, err := db1.Do(ctx, "SWAPDB", "x", 1).Result(); err == nil || err.Error() != "ERR invalid first DB index" {
+		t.Errorf("Expected 'ERR invalid first DB index' but got '%v'", err)
+	}
+
+	if res, _ := db2.FlushDB(ctx).Result(); res != "OK" {
+		t.Errorf("Expected 'OK' but got '%v'", res)
+	}
+	if size, _ := db2.DBSize(ctx).Result(); size != 0 {
+		t.Errorf("Expected '0' but got '%d'", size)
+	}
+	if size, _ := db1.DBSize(ctx).Result(); size != 2 {
+		t.Errorf("Expected '2' but got '%d'", size)
+	}
+}
+
+func TestMultiExec(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	var incr *redis.IntCmd
+	cmds, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, "txCounter", "10", 0)
+		incr = pipe.Incr(ctx, "txCounter")
+		pipe.LPop(ctx, "txCounter")
+		return nil
+	})
+	if len(cmds) != 3 || err == nil || !strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		t.Errorf("Expected the WRONGTYPE error of LPOP but got '%v'", err)
+	}
+	if incr.Val() != 11 {
+		t.Errorf("Expected '11' but got '%v'", incr.Val())
+	}
+
+	// A command that can't be queued aborts the whole transaction.
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Incr(ctx, "txCounter")
+		pipe.Do(ctx, "GET")
+		return nil
+	})
+	if err == nil || !strings.HasPrefix(err.Error(), "EXECABORT") {
+		t.Errorf("Expected 'EXECABORT' but got '%v'", err)
+	}
+	if res, _ := rdb.Get(ctx, "txCounter").Result(); res != "11" {
+		t.Errorf("Expected '11' but got '%v'", res)
+	}
+
+	// Blocking commands don't block inside a transaction.
+	var blpop *redis.StringSliceCmd
+	rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		blpop = pipe.BLPop(ctx, 0, "txEmptyList")
+		return nil
+	})
+	if blpop.Err() != redis.Nil {
+		t.Errorf("Expected a nil reply but got '%v'", blpop.Err())
+	}
+
+	if _, err := rdb.Do(ctx, "EXEC").Result(); err == nil || err.Error() != "ERR EXEC without MULTI" {
+		t.Errorf("Expected 'ERR EXEC without MULTI' but got '%v'", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+	other := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.Set(ctx, "watched", "1", 0)
+	increment := func(tx *redis.Tx, interfere bool) error {
+		val, _ := tx.Get(ctx, "watched").Int()
+		if interfere {
+			other.HSet(ctx, "watchedHash", "f", "v")
+			other.Set(ctx, "watched", "100", 0)
+		}
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, "watched", val+1, 0)
+			return nil
+		})
+		return err
+	}
+
+	if err := rdb.Watch(ctx, func(tx *redis.Tx) error { return increment(tx, false) }, "watched"); err != nil {
+		t.Errorf("Expected no error but got '%v'", err)
+	}
+	if res, _ := rdb.Get(ctx, "watched").Result(); res != "2" {
+		t.Errorf("Expected '2' but got '%v'", res)
+	}
+
+	err := rdb.Watch(ctx, func(tx *redis.Tx) error { return increment(tx, true) }, "watched")
+	if err != redis.TxFailedErr {
+		t.Errorf("Expected '%v' but got '%v'", redis.TxFailedErr, err)
+	}
+	if res, _ := rdb.Get(ctx, "watched").Result(); res != "100" {
+		t.Errorf("Expected '100' but got '%v'", res)
+	}
+
+	// Writing to a hash in place counts as a change too.
+	err = rdb.Watch(ctx, func(tx *redis.Tx) error {
+		other.HSet(ctx, "watchedHash", "f", "changed")
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, "watchedHash", "f", "mine")
+			return nil
+		})
+		return err
+	}, "watchedHash")
+	if err != redis.TxFailedErr {
+		t.Errorf("Expected '%v' but got '%v'", redis.TxFailedErr, err)
+	}
+
+	// So does expiring.
+	rdb.Set(ctx, "watchedVolatile", "v", 50*time.Millisecond)
+	err = rdb.Watch(ctx, func(tx *redis.Tx) error {
+		time.Sleep(100 * time.Millisecond)
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, "watchedVolatile", "new", 0)
+			return nil
+		})
+		return err
+	}, "watchedVolatile")
+	if err != redis.TxFailedErr {
+		t.Errorf("Expected '%v' but got '%v'", redis.TxFailedErr, err)
+	}
+
+	// Only writes that change the key fail the transaction.
+	var tests = []struct {
+		name      string
+		setup     []interface{}
+		interfere []interface{}
+		wantFail  bool
+	}{
+		// the table itself
+		{"SETNX that does nothing", []interface{}{"set", "watchedKey", "v"}, []interface{}{"setnx", "watchedKey", "x"}, false},
+		{"LPUSH against a string", []interface{}{"set", "watchedKey", "v"}, []interface{}{"lpush", "watchedKey", "x"}, false},
+		{"DEL of a missing key", nil, []interface{}{"del", "watchedKey"}, false},
+		{"HDEL of a missing field", []interface{}{"hset", "watchedKey", "f", "v"}, []interface{}{"hdel", "watchedKey", "g"}, false},
+		{"SREM of a missing member", []interface{}{"sadd", "watchedKey", "a", "b"}, []interface{}{"srem", "watchedKey", "c"}, false},
+		{"ZADD of the same score", []interface{}{"zadd", "watchedKey", "1", "a"}, []interface{}{"zadd", "watchedKey", "1", "a"}, false},
+		{"SADD of a new member", []interface{}{"sadd", "watchedKey", "a"}, []interface{}{"sadd", "watchedKey", "b"}, true},
+		{"SREM of a member", []interface{}{"sadd", "watchedKey", "a", "b"}, []interface{}{"srem", "watchedKey", "a"}, true},
+		{"LSET", []interface{}{"rpush", "watchedKey", "a"}, []interface{}{"lset", "watchedKey", "0", "b"}, true},
+		{"ZINCRBY", []interface{}{"zadd", "watchedKey", "1", "a"}, []interface{}{"zincrby", "watchedKey", "1", "a"}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rdb.Del(ctx, "watchedKey")
+			if test.setup != nil {
+				rdb.Do(ctx, test.setup...)
+			}
+			err := rdb.Watch(ctx, func(tx *redis.Tx) error {
+				other.Do(ctx, test.interfere...)
+				_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+					pipe.Ping(ctx)
+					return nil
+				})
+				return err
+			}, "watchedKey")
+			if failed := err == redis.TxFailedErr; failed != test.wantFail {
+				t.Errorf("Expected the transaction to fail '%v' but got '%v'", test.wantFail, err)
+			}
+		})
+	}
+}
+
+func TestPipelinedBeforeBlocking(t *testing.T) {
+	conn, err := net.Dial("tcp", "localhost:6379")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The reply to PING must arrive while BLPOP is still blocked.
+	fmt.Fprint(conn, "PING\r\nBLPOP pipelinedBlocking 1\r\n")
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "+PONG\r\n" {
+		t.Errorf("Expected '+PONG' but got '%q'", line)
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	sub := rdb.Subscribe(ctx, "news.tech")
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+	psub := rdb.PSubscribe(ctx, "news.*")
+	defer psub.Close()
+	if _, err := psub.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if res, _ := rdb.Publish(ctx, "news.tech", "hello").Result(); res != 2 {
+		t.Errorf("Expected '2' but got '%v'", res)
+	}
+	msg, err := sub.ReceiveMessage(ctx)
+	if err != nil || msg.Channel != "news.tech" || msg.Payload != "hello" {
+		t.Errorf("Expected 'hello' on 'news.tech' but got '%v' (%v)", msg, err)
+	}
+	msg, err = psub.ReceiveMessage(ctx)
+	if err != nil || msg.Pattern != "news.*" || msg.Channel != "news.tech" || msg.Payload != "hello" {
+		t.Errorf("Expected 'hello' on 'news.tech' via 'news.*' but got '%v' (%v)", msg, err)
+	}
+	if res, _ := rdb.Publish(ctx, "sports", "goal").Result(); res != 0 {
+		t.Errorf("Expected '0' but got '%v'", res)
+	}
+
+	if channels, _ := rdb.PubSubChannels(ctx, "news.*").Result(); len(channels) != 1 || channels[0] != "news.tech" {
+		t.Errorf("Expected '[news.tech]' but got '%v'", channels)
+	}
+	if counts, _ := rdb.PubSubNumSub(ctx, "news.tech", "sports").Result(); counts["news.tech"] != 1 || counts["sports"] != 0 {
+		t.Errorf("Expected 'map[news.tech:1 sports:0]' but got '%v'", counts)
+	}
+	if res, _ := rdb.PubSubNumPat(ctx).Result(); res != 1 {
+		t.Errorf("Expected '1' but got '%v'", res)
+	}
+
+	ssub := rdb.SSubscribe(ctx, "orders")
+	defer ssub.Close()
+	if _, err := ssub.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := rdb.SPublish(ctx, "orders", "new").Result(); res != 1 {
+		t.Errorf("Expected '1' but got '%v'", res)
+	}
+	msg, err = ssub.ReceiveMessage(ctx)
+	if err != nil || msg.Channel != "orders" || msg.Payload != "new" {
+		t.Errorf("Expected 'new' on 'orders' but got '%v' (%v)", msg, err)
+	}
+	// Shard channels don't reach plain subscribers.
+	if res, _ := rdb.Publish(ctx, "orders", "x").Result(); res != 0 {
+		t.Errorf("Expected '0' but got '%v'", res)
+	}
+
+	sub.Unsubscribe(ctx, "news.tech")
+	psub.PUnsubscribe(ctx)
+	time.Sleep(50 * time.Millisecond)
+	if res, _ := rdb.Publish(ctx, "news.tech", "bye").Result(); res != 0 {
+		t.Errorf("Expected '0' but got '%v'", res)
+	}
+}
+
+func TestSubscribeModeRESP2(t *testing.T) {
+	conn, err := net.Dial("tcp", "localhost:6379")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	reader := bufio.NewReader(conn)
+
+	fmt.Fprint(conn, "SUBSCRIBE resp2Channel\r\nGET key\r\nPING\r\n")
+	expected := []string{
+		"*3\r\n", "$9\r\n", "subscribe\r\n", "$12\r\n", "resp2Channel\r\n", ":1\r\n",
+		"-ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context\r\n",
+		"*2\r\n", "$4\r\n", "pong\r\n", "$0\r\n", "\r\n",
+	}
+	for _, want := range expected {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != want {
+			t.Errorf("Expected '%q' but got '%q'", want, line)
+		}
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	// A subscriber that never reads its messages.
+	conn, err := net.Dial("tcp", "localhost:6379")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "SUBSCRIBE slowChannel\r\n")
+	time.Sleep(50 * time.Millisecond)
+
+	payload := strings.Repeat("x", 256*1024)
+	start := time.Now()
+	for i := 0; i < 128; i++ {
+		rdb.Publish(ctx, "slowChannel", payload)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Publishing took '%v', the slow subscriber blocked it", elapsed)
+	}
+
+	// The server drops the subscriber once its output buffer is full.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		counts, _ := rdb.PubSubNumSub(ctx, "slowChannel").Result()
+		if counts["slowChannel"] == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the slow subscriber to be disconnected")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSaveBgsave(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	rdb.Set(ctx, "saveString", "value", 0)
+	rdb.RPush(ctx, "saveList", "a", "b", "c")
+	rdb.HSet(ctx, "saveHash", "field", "value")
+	rdb.Set(ctx, "saveVolatile", "value", time.Hour)
+
+	before := time.Now().Unix()
+	if res, err := rdb.Save(ctx).Result(); err != nil || res != "OK" {
+		t.Fatalf("Expected 'OK' but got '%v' (%v)", res, err)
+	}
+	if res, _ := rdb.LastSave(ctx).Result(); res < before {
+		t.Errorf("Expected LASTSAVE to be at least '%v' but got '%v'", before, res)
+	}
+	if info, _ := rdb.Info(ctx, "persistence").Result(); !strings.Contains(info, "rdb_changes_since_last_save:0") {
+		t.Errorf("Expected no changes since the save but got '%v'", info)
+	}
+
+	// Written after SAVE, so only the background save has it.
+	rdb.SAdd(ctx, "saveSet", "x", "y")
+	if res, err := rdb.BgSave(ctx).Result(); err != nil || res != "Background saving started" {
+		t.Fatalf("Expected 'Background saving started' but got '%v' (%v)", res, err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, _ := rdb.Info(ctx, "persistence").Result()
+		if strings.Contains(info, "rdb_bgsave_in_progress:0") {
+			if !strings.Contains(info, "rdb_last_bgsave_status:ok") {
+				t.Fatalf("Expected the background save to succeed but got '%v'", info)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the background save to finish")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	srv := newServer(testCfg)
+	if err := loadSnapshot(srv, srv.snapshotPath()); err != nil {
+		t.Fatal(err)
+	}
+	db := srv.dbs[0]
+	if rec, ok := db.dict["saveString"]; !ok || rec.value != "value" {
+		t.Errorf("Expected 'value' but got '%v'", rec.value)
+	}
+	if rec, ok := db.dict["saveList"]; !ok || rec.value.(linkedList).length != 3 {
+		t.Errorf("Expected a list of 3 but got '%v'", rec.value)
+	}
+	if rec, ok := db.dict["saveHash"]; !ok || rec.value.(*hash).fields["field"] != "value" {
+		t.Errorf("Expected a hash but got '%v'", rec.value)
+	}
+	if rec, ok := db.dict["saveVolatile"]; !ok || rec.expiryTimestamp == -1 {
+		t.Errorf("Expected a volatile key but got '%v'", rec)
+	}
+	if rec, ok := db.dict["saveSet"]; !ok || rec.value.(*set).size() != 2 {
+		t.Errorf("Expected a set of 2 but got '%v'", rec.value)
+	}
+}
+
+// loadAppendOnlyCopy loads a copy of the append-only file of the test
+// server into a new server.
+func loadAppendOnlyCopy(t *testing.T) *server {
+	srv := newServer(testCfg)
+	data, err := os.ReadFile(srv.appendOnlyPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadAppendOnlyFile(srv, path); err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+// dumpValue renders a value the same way for the test server and a replayed
+// one, with members of unordered types sorted.
+func dumpValue(value interface{}) string {
+	switch v := value.(type) {
+	case linkedList:
+		return fmt.Sprint(listValues(&v))
+	case *set:
+		members := v.list()
+		sort.Strings(members)
+		return fmt.Sprint(members)
+	case *sortedSet:
+		var pairs []string
+		for x := v.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+			pairs = append(pairs, x.member, formatDouble(x.score))
+		}
+		return fmt.Sprint(pairs)
+	case *hash:
+		var pairs []string
+		for field, val := range v.fields {
+			pairs = append(pairs, field+"="+val)
+		}
+		sort.Strings(pairs)
+		return fmt.Sprint(pairs)
+	}
+	return fmt.Sprint(value)
+}
+
+// dumpLive fetches a value from the test server, rendered like dumpValue.
+func dumpLive(ctx context.Context, rdb *redis.Client, key string) string {
+	switch rdb.Type(ctx, key).Val() {
+	case "list":
+		return fmt.Sprint(rdb.LRange(ctx, key, 0, -1).Val())
+	case "set":
+		members := rdb.SMembers(ctx, key).Val()
+		sort.Strings(members)
+		return fmt.Sprint(members)
+	case "zset":
+		var pairs []string
+		for _, z := range rdb.ZRangeWithScores(ctx, key, 0, -1).Val() {
+			pairs = append(pairs, z.Member.(string), formatDouble(z.Score))
+		}
+		return fmt.Sprint(pairs)
+	case "hash":
+		var pairs []string
+		for field, val := range rdb.HGetAll(ctx, key).Val() {
+			pairs = append(pairs, field+"="+val)
+		}
+		sort.Strings(pairs)
+		return fmt.Sprint(pairs)
+	}
+	return rdb.Get(ctx, key).Val()
+}
+
+// compareReplay checks that every key of the replayed server that doesn't
+// expire holds what the test server has.
+func compareReplay(t *testing.T, ctx context.Context, srv *server) {
+	for _, store := range srv.dbs {
+		rdb := redis.NewClient(&redis.Options{
+			Addr:     "localhost:6379",
+			Password: "", // no password set
+			DB:       store.id,
+		})
+		for key, rec := range store.dict {
+			if rec.expiryTimestamp != -1 {
+				continue
+			}
+			if got, want := dumpValue(rec.value), dumpLive(ctx, rdb, key); got != want {
+				t.Errorf("Expected '%v' but got '%v' for '%v' in db %d", want, got, key, store.id)
+			}
+		}
+		rdb.Close()
+	}
+}
+
+func TestAppendOnlyFile(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	// Everything the earlier tests did replays the same.
+	compareReplay(t, ctx, loadAppendOnlyCopy(t))
+
+	rdb.Set(ctx, "aofVolatile", "value", time.Hour)
+	rdb.RPush(ctx, "aofList", "a", "b")
+	rdb.Expire(ctx, "aofList", time.Hour)
+	rdb.SAdd(ctx, "aofSet", "a", "b", "c", "d")
+	rdb.SPop(ctx, "aofSet")
+
+	// A client served by a push pops after it.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		blocking := redis.NewClient(&redis.Options{
+			Addr:     "localhost:6379",
+			Password: "", // no password set
+			DB:       0,  // use default DB
+		})
+		blocking.BLPop(ctx, 5*time.Second, "aofBlocked")
+	}()
+	time.Sleep(100 * time.Millisecond)
+	rdb.LPush(ctx, "aofBlocked", "x", "y")
+	<-done
+
+	rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Incr(ctx, "aofCounter")
+		pipe.Incr(ctx, "aofCounter")
+		return nil
+	})
+
+	srv := loadAppendOnlyCopy(t)
+	compareReplay(t, ctx, srv)
+	for _, key := range []string{"aofVolatile", "aofList"} {
+		want := rdb.PExpireTime(ctx, key).Val().Milliseconds()
+		if got := srv.dbs[0].dict[key].expiryTimestamp; got != want {
+			t.Errorf("Expected expiry '%v' but got '%v' for '%v'", want, got, key)
+		}
+	}
+
+	// Writes made during and after a rewrite end up in the new file.
+	if res, err := rdb.Do(ctx, "BGREWRITEAOF").Result(); err != nil || res != "Background append only file rewriting started" {
+		t.Fatalf("Expected 'Background append only file rewriting started' but got '%v' (%v)", res, err)
+	}
+	rdb.HSet(ctx, "aofHash", "field", "value")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, _ := rdb.Info(ctx, "persistence").Result()
+		if strings.Contains(info, "aof_rewrite_in_progress:0") {
+			if !strings.Contains(info, "aof_last_bgrewrite_status:ok") {
+				t.Fatalf("Expected the rewrite to succeed but got '%v'", info)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the rewrite to finish")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	rdb.ZAdd(ctx, "aofZSet", redis.Z{Score: 1.5, Member: "a"})
+
+	srv = loadAppendOnlyCopy(t)
+	compareReplay(t, ctx, srv)
+	for _, key := range []string{"aofHash", "aofZSet", "aofCounter", "aofVolatile"} {
+		if _, ok := srv.dbs[0].dict[key]; !ok {
+			t.Errorf("Expected '%v' to be replayed", key)
+		}
+	}
+	if got := srv.dbs[0].dict["aofVolatile"].expiryTimestamp; got != rdb.PExpireTime(ctx, "aofVolatile").Val().Milliseconds() {
+		t.Errorf("Expected the rewrite to keep the expiry of 'aofVolatile' but got '%v'", got)
+	}
+}
+
+func TestInfoMemory(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	usedMemory := func() int {
+		info, _ := rdb.Info(ctx, "memory").Result()
+		for _, line := range strings.Split(info, "\r\n") {
+			if v, ok := strings.CutPrefix(line, "used_memory:"); ok {
+				used, _ := strconv.Atoi(v)
+				return used
+			}
+		}
+		t.Fatalf("Expected used_memory in '%v'", info)
+		return 0
+	}
+
+	before := usedMemory()
+	rdb.Set(ctx, "memoryString", strings.Repeat("x", 10000), 0)
+	if after := usedMemory(); after < before+10000 {
+		t.Errorf("Expected at least '%d' but got '%d'", before+10000, after)
+	}
+	rdb.Del(ctx, "memoryString")
+	if after := usedMemory(); after != before {
+		t.Errorf("Expected '%d' but got '%d'", before, after)
+	}
+
+	info, _ := rdb.Info(ctx, "memory").Result()
+	if !strings.Contains(info, "maxmemory:0") || !strings.Contains(info, "maxmemory_policy:noeviction") {
+		t.Errorf("Expected no limit in '%v'", info)
+	}
+	if stats, _ := rdb.Info(ctx, "stats").Result(); !strings.Contains(stats, "evicted_keys:0") {
+		t.Errorf("Expected evicted_keys in '%v'", stats)
+	}
+}
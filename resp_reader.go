@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Redis limits bulk strings to 512MB by default.
+const maxBulkLength = 512_000_000
+
+// Same limit Redis puts on the number of arguments in a single command.
+const maxMultiBulkLength = 1024 * 1024 * 1024
+
+// Longest inline command accepted, same as Redis.
+const maxInlineLength = 64 * 1024
+
+// Bulk strings up to this size are read in one go, larger ones are read
+// incrementally so that a huge length header does not allocate upfront.
+const bulkReadChunk = 64 * 1024
+
+// respReader incrementally decodes client commands from a stream. A command
+// may be split across several reads and a single read may carry several
+// pipelined commands, bufio takes care of both. Besides RESP arrays it also
+// accepts inline commands.
+type respReader struct {
+	rd *bufio.Reader
+}
+
+func newRespReader(rd io.Reader) *respReader {
+	return &respReader{rd: bufio.NewReader(rd)}
+}
+
+// readCommand blocks until a whole command array is available and returns its
+// elements. A null array is returned as nil.
+func (r *respReader) readCommand() ([]interface{}, error) {
+	first, err := r.rd.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] != '*' {
+		return r.readInlineCommand()
+	}
+
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := strconv.ParseInt(line[1:], 10, 64)
+	if err != nil || length < -1 || length > maxMultiBulkLength {
+		return nil, protocolErrorf("invalid multibulk length")
+	}
+	if length == -1 {
+		return nil, nil
+	}
+
+	// Don't trust the header with the allocation, the elements may never come.
+	arr := make([]interface{}, 0, min(length, 1024))
+	for i := int64(0); i < length; i++ {
+		val, err := r.readBulkString()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, val)
+	}
+	return arr, nil
+}
+
+func (r *respReader) readBulkString() (string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return "", err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return "", protocolErrorf("expected '$', got '%.1s'", line)
+	}
+
+	length, err := strconv.ParseInt(line[1:], 10, 64)
+	if err != nil || length < 0 || length > maxBulkLength {
+		return "", protocolErrorf("invalid bulk length")
+	}
+
+	var val string
+	if length <= bulkReadChunk {
+		buf := make([]byte, length)
+		if _, err := io.ReadFull(r.rd, buf); err != nil {
+			return "", unexpectedEOF(err)
+		}
+		val = string(buf)
+	} else {
+		// Grows with the data that actually arrives.
+		var sb strings.Builder
+		sb.Grow(bulkReadChunk)
+		if _, err := io.CopyN(&sb, r.rd, length); err != nil {
+			return "", unexpectedEOF(err)
+		}
+		val = sb.String()
+	}
+
+	if err := r.readCRLF(); err != nil {
+		return "", err
+	}
+	return val, nil
+}
+
+// readInlineCommand reads a command typed by hand, e.g. over telnet: space
+// separated arguments terminated by a newline, with quoting the same way
+// redis-cli does it. A blank line yields an empty command.
+func (r *respReader) readInlineCommand() ([]interface{}, error) {
+	var line []byte
+	for {
+		chunk, err := r.rd.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxInlineLength {
+			return nil, protocolErrorf("too big inline request")
+		}
+		if err == nil {
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			return nil, unexpectedEOF(err)
+		}
+	}
+
+	args, err := splitArgs(strings.TrimRight(string(line), "\r\n"))
+	if err != nil {
+		return nil, err
+	}
+	arr := make([]interface{}, len(args))
+	for i, arg := range args {
+		arr[i] = arg
+	}
+	return arr, nil
+}
+
+// splitArgs splits an inline command into arguments. Double quoted arguments
+// support the usual escapes (\n, \t, \xHH, ...), single quoted ones only \'.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var arg strings.Builder
+		inDouble, inSingle := false, false
+		for done := false; !done; {
+			switch {
+			case inDouble:
+				if i == len(line) {
+					return nil, protocolErrorf("unbalanced quotes in request")
+				}
+				if line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]) {
+					b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					arg.WriteByte(byte(b))
+					i += 3
+				} else if line[i] == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						arg.WriteByte('\n')
+					case 'r':
+						arg.WriteByte('\r')
+					case 't':
+						arg.WriteByte('\t')
+					case 'b':
+						arg.WriteByte('\b')
+					case 'a':
+						arg.WriteByte('\a')
+					default:
+						arg.WriteByte(line[i])
+					}
+				} else if line[i] == '"' {
+					// The closing quote must be followed by a space or nothing.
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, protocolErrorf("unbalanced quotes in request")
+					}
+					done = true
+				} else {
+					arg.WriteByte(line[i])
+				}
+			case inSingle:
+				if i == len(line) {
+					return nil, protocolErrorf("unbalanced quotes in request")
+				}
+				if line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					arg.WriteByte('\'')
+				} else if line[i] == '\'' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, protocolErrorf("unbalanced quotes in request")
+					}
+					done = true
+				} else {
+					arg.WriteByte(line[i])
+				}
+			default:
+				if i == len(line) || isSpace(line[i]) {
+					done = true
+				} else if line[i] == '"' {
+					inDouble = true
+				} else if line[i] == '\'' {
+					inSingle = true
+				} else {
+					arg.WriteByte(line[i])
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		args = append(args, arg.String())
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isHexDigit(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+// readLine returns the next CRLF terminated line without the terminator.
+func (r *respReader) readLine() (string, error) {
+	line, err := r.rd.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return "", protocolErrorf("too big count string")
+		}
+		if err == io.EOF && len(line) > 0 {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", protocolErrorf("line must end with CRLF")
+	}
+	return string(line[:len(line)-2]), nil
+}
+
+func (r *respReader) readCRLF() error {
+	cr, err := r.rd.ReadByte()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	lf, err := r.rd.ReadByte()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if cr != '\r' || lf != '\n' {
+		return protocolErrorf("bulk string must end with CRLF")
+	}
+	return nil
+}
+
+// unexpectedEOF reports a connection closed in the middle of a frame.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// protocolError is a malformed request. Like Redis, the server replies with
+// it and closes the connection since the stream can't be resynchronized.
+type protocolError struct {
+	msg string
+}
+
+func (e *protocolError) Error() string {
+	return "Protocol error: " + e.msg
+}
+
+func protocolErrorf(format string, args ...interface{}) error {
+	return &protocolError{msg: fmt.Sprintf(format, args...)}
+}
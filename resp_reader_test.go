@@ -0,0 +1,200 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReadCommand(t *testing.T) {
+	var tests = []struct {
+		name  string
+		input string
+		want  []interface{}
+	}{
+		// the table itself
+		{"Should read command", "*2\r\n$4\r\necho\r\n$11\r\nhello world\r\n", []interface{}{"echo", "hello world"}},
+		{"Should read empty bulk string", "*2\r\n$4\r\necho\r\n$0\r\n\r\n", []interface{}{"echo", ""}},
+		{"Should read bulk string with CRLF inside", "*2\r\n$4\r\necho\r\n$4\r\na\r\nb\r\n", []interface{}{"echo", "a\r\nb"}},
+		{"Should read empty array", "*0\r\n", []interface{}{}},
+		{"Should read nil array", "*-1\r\n", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Deliver a single byte per read to make sure split frames are reassembled.
+			reader := newRespReader(iotest.OneByteReader(strings.NewReader(test.input)))
+			ans, err := reader.readCommand()
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			if (ans == nil) != (test.want == nil) {
+				t.Fatalf("Got '%v' but expected '%v'.", ans, test.want)
+			}
+			if len(ans) != len(test.want) {
+				t.Fatalf("Got array of len '%d' but expected '%d'.", len(ans), len(test.want))
+			}
+			for i := range ans {
+				if ans[i] != test.want[i] {
+					t.Errorf("Got '%v' but expected '%v'.", ans, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadCommandPipelined(t *testing.T) {
+	input := "*1\r\n$4\r\nping\r\n*2\r\n$3\r\nget\r\n$3\r\nkey\r\n*3\r\n$3\r\nset\r\n$3\r\nkey\r\n$5\r\nvalue\r\n"
+	want := []string{"ping", "get", "set"}
+
+	reader := newRespReader(strings.NewReader(input))
+	for _, cmd := range want {
+		arr, err := reader.readCommand()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if arr[0] != cmd {
+			t.Errorf("Got '%s' but expected '%s'.", arr[0], cmd)
+		}
+	}
+
+	if _, err := reader.readCommand(); err != io.EOF {
+		t.Errorf("Expected EOF after the last command but got '%v'.", err)
+	}
+}
+
+func TestReadCommandLargeBulkString(t *testing.T) {
+	payload := strings.Repeat("x", 3*bulkReadChunk+7)
+	input := "*2\r\n$4\r\necho\r\n" + serializeBulkString(payload)
+
+	reader := newRespReader(iotest.HalfReader(strings.NewReader(input)))
+	arr, err := reader.readCommand()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if arr[1] != payload {
+		t.Errorf("Got bulk string of len '%d' but expected '%d'.", len(arr[1].(string)), len(payload))
+	}
+}
+
+func TestReadCommandInvalid(t *testing.T) {
+	var tests = []struct {
+		name  string
+		input string
+	}{
+		// the table itself
+		{"Should fail on truncated bulk string", "*1\r\n$10\r\nabc"},
+		{"Should fail on missing CRLF after bulk string", "*1\r\n$3\r\nabcde\r\n"},
+		{"Should fail on bulk string over the size limit", "*1\r\n$600000000\r\n"},
+		{"Should fail on negative bulk string length", "*1\r\n$-5\r\n"},
+		{"Should fail on invalid array length", "*x\r\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := newRespReader(strings.NewReader(test.input))
+			if _, err := reader.readCommand(); err == nil {
+				t.Errorf("Got no error but expected failure.")
+			}
+		})
+	}
+}
+
+func TestReadCommandProtocolError(t *testing.T) {
+	var tests = []struct {
+		name  string
+		input string
+		want  string
+	}{
+		// the table itself
+		{"Should reject non-string element", "*1\r\n:1\r\n", "Protocol error: expected '$', got ':'"},
+		{"Should reject invalid bulk length", "*1\r\n$abc\r\n", "Protocol error: invalid bulk length"},
+		{"Should reject invalid multibulk length", "*-5\r\n", "Protocol error: invalid multibulk length"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := newRespReader(strings.NewReader(test.input))
+			_, err := reader.readCommand()
+			if _, ok := err.(*protocolError); !ok {
+				t.Fatalf("Expected a protocol error but got '%v'.", err)
+			}
+			if err.Error() != test.want {
+				t.Errorf("Got '%s' but expected '%s'.", err.Error(), test.want)
+			}
+		})
+	}
+}
+
+func FuzzReadCommand(f *testing.F) {
+	for _, seed := range []string{"*1\r\n$4\r\nping\r\n", "*-1\r\n", "*2\r\n$3\r\nget\r\n$3\r\nkey\r\n", "*1\r\n$99\r\nab\r\n"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, message string) {
+		reader := newRespReader(strings.NewReader(message))
+		for {
+			if _, err := reader.readCommand(); err != nil {
+				return
+			}
+		}
+	})
+}
+
+func TestReadInlineCommand(t *testing.T) {
+	var tests = []struct {
+		name  string
+		input string
+		want  []interface{}
+	}{
+		// the table itself
+		{"Should read inline command", "PING\r\n", []interface{}{"PING"}},
+		{"Should read inline command terminated by LF", "SET foo bar\n", []interface{}{"SET", "foo", "bar"}},
+		{"Should collapse repeated spaces", "  SET   foo\tbar  \r\n", []interface{}{"SET", "foo", "bar"}},
+		{"Should read double quoted argument", "SET foo \"hello world\"\r\n", []interface{}{"SET", "foo", "hello world"}},
+		{"Should unescape double quoted argument", "SET foo \"a\\nb\\x41\\\"\"\r\n", []interface{}{"SET", "foo", "a\nbA\""}},
+		{"Should read single quoted argument", "SET foo 'it\\'s \"raw\"'\r\n", []interface{}{"SET", "foo", "it's \"raw\""}},
+		{"Should read empty quoted argument", "ECHO \"\"\r\n", []interface{}{"ECHO", ""}},
+		{"Should read blank line as empty command", "\r\n", []interface{}{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := newRespReader(iotest.OneByteReader(strings.NewReader(test.input)))
+			ans, err := reader.readCommand()
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			if len(ans) != len(test.want) {
+				t.Fatalf("Got '%q' but expected '%q'.", ans, test.want)
+			}
+			for i := range ans {
+				if ans[i] != test.want[i] {
+					t.Errorf("Got '%q' but expected '%q'.", ans, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadInlineCommandInvalid(t *testing.T) {
+	var tests = []struct {
+		name  string
+		input string
+	}{
+		// the table itself
+		{"Should reject unterminated double quote", "SET foo \"bar\r\n"},
+		{"Should reject unterminated single quote", "SET foo 'bar\r\n"},
+		{"Should reject closing quote followed by text", "SET foo \"bar\"baz\r\n"},
+		{"Should reject too big inline request", strings.Repeat("a", maxInlineLength+1) + "\r\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := newRespReader(strings.NewReader(test.input))
+			if _, err := reader.readCommand(); err == nil {
+				t.Errorf("Got no error but expected failure.")
+			}
+		})
+	}
+}
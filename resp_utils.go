@@ -2,10 +2,21 @@ package main
 
 import (
 	"fmt"
+	"math"
+	"math/big"
 	"strconv"
 	"strings"
 )
 
+// indexCRLF returns the position of the first CRLF at or after start.
+func indexCRLF(message string, start int) (int, error) {
+	i := strings.Index(message[start:], "\r\n")
+	if i == -1 {
+		return 0, fmt.Errorf("malformed message, CRLF not found")
+	}
+	return start + i, nil
+}
+
 // +OK\r\n
 func deserializeSimpleString(message string) (string, int, error) {
 	if len(message) < 3 {
@@ -16,13 +27,9 @@ func deserializeSimpleString(message string) (string, int, error) {
 		return "", 0, fmt.Errorf("expected a simple string starting with '+' but got: %.10q", message)
 	}
 
-	i := 1
-	for i < len(message) && !(message[i] == '\r' && message[i+1] == '\n') {
-		i++
-	}
-
-	if i >= len(message) {
-		return "", 0, fmt.Errorf("malformed message, CRLF not found")
+	i, err := indexCRLF(message, 1)
+	if err != nil {
+		return "", 0, err
 	}
 
 	return message[1:i], i + 2, nil
@@ -44,13 +51,9 @@ func deserializeSimpleError(message string) (string, int, error) {
 		return "", 0, fmt.Errorf("Expected a simple error starting with '-' but got '%.10q'", message[0])
 	}
 
-	i := 1
-	for i < len(message) && !(message[i] == '\r' && message[i+1] == '\n') {
-		i++
-	}
-
-	if i >= len(message) {
-		return "", 0, fmt.Errorf("malformed message, CRLF not found")
+	i, err := indexCRLF(message, 1)
+	if err != nil {
+		return "", 0, err
 	}
 
 	return message[1:i], i + 2, nil
@@ -72,9 +75,9 @@ func deserializeInteger(message string) (int64, int, error) {
 		return 0, 0, fmt.Errorf("Expected a integer to begin with ':' but got '%.10q'", message[0])
 	}
 
-	i := 1
-	for i < len(message) && !(message[i] == '\r' && message[i+1] == '\n') {
-		i++
+	i, err := indexCRLF(message, 1)
+	if err != nil {
+		return 0, 0, err
 	}
 
 	// Redis is uses 64-bit integers.
@@ -114,10 +117,13 @@ func deserializeBulkString(message string) (string, int, error) {
 	if len(message) < 5 {
 		return "", 0, fmt.Errorf("message is too short")
 	}
+	if message[0] != '$' {
+		return "", 0, fmt.Errorf("Expected bulk string to begin with '$' but got '%c'", message[0])
+	}
 
-	i := 1
-	for i < len(message) && !(message[i] == '\r' && message[i+1] == '\n') {
-		i++
+	i, err := indexCRLF(message, 1)
+	if err != nil {
+		return "", 0, err
 	}
 
 	// TODO: Handle very large bulk strings. Redis limits them by default to 512MB.
@@ -128,16 +134,20 @@ func deserializeBulkString(message string) (string, int, error) {
 	if length < 0 {
 		return "", 0, fmt.Errorf("Bulk strings cannot have negative length")
 	}
-	if length > 512_000_000 {
+	if length > maxBulkLength {
 		return "", 0, fmt.Errorf("Bulk strings are limited to 512MB")
 	}
 
-	if message[i] != '\r' && message[i+1] != '\n' {
-		return "", 0, fmt.Errorf("Bulk string must end with CRLF")
-	}
 	// Skip over CRLF after string length
 	i += 2
-	return message[i : i+int(length)], i + int(length) + 2, nil
+	end := i + int(length)
+	if end+2 > len(message) {
+		return "", 0, fmt.Errorf("Bulk string is shorter than its declared length")
+	}
+	if message[end] != '\r' || message[end+1] != '\n' {
+		return "", 0, fmt.Errorf("Bulk string must end with CRLF")
+	}
+	return message[i:end], end + 2, nil
 }
 
 func serializeBulkString(message string) string {
@@ -151,19 +161,19 @@ func isNullBulkString(message string) (bool, error) {
 	if message[0] != '$' {
 		return false, fmt.Errorf("Expected bulk string to begin with '$' but got '%.10q'", message[0])
 	}
-	if message[1] == '-' && message[2] == '1' {
-		return true, nil
-	}
-	return false, nil
+	return strings.HasPrefix(message, "$-1\r\n"), nil
 }
 
 func deserializeNullOrArray(message string) (interface{}, int, error) {
+	if len(message) < 3 {
+		return "", 0, fmt.Errorf("message is too short")
+	}
 	if message[0] != '*' {
 		return "", 0, fmt.Errorf("Expected array to begin with '*' but got '%c'", message[0])
 	}
 
 	if message[1] == '-' && message[2] == '1' {
-		if message[3] != '\r' || message[4] != '\n' {
+		if !strings.HasPrefix(message, "*-1\r\n") {
 			return "", 0, fmt.Errorf("Null array not terminated with CRLF.")
 		}
 		return nil, 5, nil
@@ -190,9 +200,9 @@ func deserializeArray(message string) ([]interface{}, int, error) {
 	}
 
 	// determine array length
-	i := 1
-	for i < len(message) && !(message[i] == '\r' && message[i+1] == '\n') {
-		i++
+	i, err := indexCRLF(message, 1)
+	if err != nil {
+		return []interface{}{}, 0, err
 	}
 	length, err := strconv.ParseInt(message[1:i], 10, 64)
 	if err != nil {
@@ -202,13 +212,14 @@ func deserializeArray(message string) ([]interface{}, int, error) {
 		return []interface{}{}, 0, fmt.Errorf("Array langth can't be negative")
 	}
 
-	if message[i] != '\r' || message[i+1] != '\n' {
-		return []interface{}{}, 0, fmt.Errorf("Array length must be followed by CRLF")
-
-	}
 	// step over CRLF
 	i += 2
 
+	// Every element takes at least 3 bytes, reject lengths the message can't hold.
+	if length > int64(len(message)-i) {
+		return []interface{}{}, 0, fmt.Errorf("Array is shorter than its declared length")
+	}
+
 	arrLength := int(length)
 	arr := make([]interface{}, arrLength)
 	for idx := 0; idx < arrLength; idx++ {
@@ -230,7 +241,15 @@ func serializeStringArray(arr []string) (string, int, error) {
 	return res, len(res), nil
 }
 
+// serializeArray wraps already serialized elements into an array.
+func serializeArray(items []string) string {
+	return fmt.Sprintf("*%d\r\n", len(items)) + strings.Join(items, "")
+}
+
 func deserializePrimitive(message string) (interface{}, int, error) {
+	if len(message) == 0 {
+		return nil, 0, fmt.Errorf("message is too short")
+	}
 	switch message[0] {
 	case '+':
 		return deserializeSimpleString(message)
@@ -243,3 +262,63 @@ func deserializePrimitive(message string) (interface{}, int, error) {
 	}
 	return nil, 0, fmt.Errorf("Expected a primitive to deserialize, but received unsupported type: '%c'", message[0])
 }
+
+// RESP3 types. Callers normally go through the client helpers, which fall
+// back to RESP2 encodings for connections that didn't negotiate RESP3.
+
+// serializeMap wraps already serialized key/value pairs into a map.
+func serializeMap(pairs []string) string {
+	return fmt.Sprintf("%%%d\r\n", len(pairs)/2) + strings.Join(pairs, "")
+}
+
+// serializeSet wraps already serialized elements into a set.
+func serializeSet(items []string) string {
+	return fmt.Sprintf("~%d\r\n", len(items)) + strings.Join(items, "")
+}
+
+// serializePush wraps already serialized elements into an out of band push.
+func serializePush(items []string) string {
+	return fmt.Sprintf(">%d\r\n", len(items)) + strings.Join(items, "")
+}
+
+func serializeDouble(num float64) string {
+	return fmt.Sprintf(",%s\r\n", formatDouble(num))
+}
+
+func serializeBoolean(b bool) string {
+	if b {
+		return "#t\r\n"
+	}
+	return "#f\r\n"
+}
+
+func serializeBigNumber(num *big.Int) string {
+	return fmt.Sprintf("(%s\r\n", num.String())
+}
+
+// serializeVerbatimString prefixes the string with a three letter format such
+// as "txt" or "mkd".
+func serializeVerbatimString(format string, message string) (string, error) {
+	if len(format) != 3 {
+		return "", fmt.Errorf("verbatim string format must be exactly 3 characters")
+	}
+	return fmt.Sprintf("=%d\r\n%s:%s\r\n", len(message)+4, format, message), nil
+}
+
+func serializeNull() string {
+	return "_\r\n"
+}
+
+// formatDouble renders a float the way Redis does, using inf, -inf and nan
+// for the special values.
+func formatDouble(num float64) string {
+	switch {
+	case math.IsInf(num, 1):
+		return "inf"
+	case math.IsInf(num, -1):
+		return "-inf"
+	case math.IsNaN(num):
+		return "nan"
+	}
+	return strconv.FormatFloat(num, 'g', -1, 64)
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"math"
+	"math/big"
 	"testing"
 )
 
@@ -277,3 +279,145 @@ func TestDeserializeNullOrArray(t *testing.T) {
 		})
 	}
 }
+
+func TestSerializeArray(t *testing.T) {
+	var tests = []struct {
+		name  string
+		input []string
+		want  string
+	}{
+		// the table itself
+		{"Should serialize empty array", nil, "*0\r\n"},
+		{"Should serialize mixed array", []string{":1\r\n", "$2\r\nOK\r\n"}, "*2\r\n:1\r\n$2\r\nOK\r\n"},
+		{"Should serialize nested array", []string{"*1\r\n+OK\r\n"}, "*1\r\n*1\r\n+OK\r\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ans := serializeArray(test.input)
+			if ans != test.want {
+				t.Errorf("Got '%q' but expected '%q'.", ans, test.want)
+			}
+		})
+	}
+}
+
+func TestDeserializeInvalid(t *testing.T) {
+	var tests = []struct {
+		name        string
+		input       string
+		deserialize func(string) (interface{}, int, error)
+	}{
+		// the table itself
+		{"Should reject simple string without CRLF", "+OK\r", func(m string) (interface{}, int, error) { return deserializeSimpleString(m) }},
+		{"Should reject integer without CRLF", ":12\r", func(m string) (interface{}, int, error) { return deserializeInteger(m) }},
+		{"Should reject truncated bulk string", "$10\r\nabc\r\n", func(m string) (interface{}, int, error) { return deserializeBulkString(m) }},
+		{"Should reject bulk string without trailing CRLF", "$3\r\nabcde", func(m string) (interface{}, int, error) { return deserializeBulkString(m) }},
+		{"Should reject empty array message", "", deserializeNullOrArray},
+		{"Should reject truncated array", "*3\r\n:1\r\n", deserializeNullOrArray},
+		{"Should reject huge array length", "*9223372036854775807\r\n", deserializeNullOrArray},
+		{"Should reject empty primitive", "", deserializePrimitive},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := test.deserialize(test.input)
+			if err == nil {
+				t.Errorf("Got no error but expected failure.")
+			}
+		})
+	}
+}
+
+// fuzzDeserializer checks that a deserializer never panics and never claims
+// to have consumed more than it was given.
+func fuzzDeserializer(f *testing.F, seeds []string, deserialize func(string) (interface{}, int, error)) {
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, message string) {
+		_, n, err := deserialize(message)
+		if err == nil && (n < 0 || n > len(message)) {
+			t.Errorf("Consumed '%d' bytes of a '%d' byte message.", n, len(message))
+		}
+	})
+}
+
+func FuzzDeserializeSimpleString(f *testing.F) {
+	fuzzDeserializer(f, []string{"+OK\r\n", "+\r\n", "+OK\r"}, func(m string) (interface{}, int, error) {
+		return deserializeSimpleString(m)
+	})
+}
+
+func FuzzDeserializeSimpleError(f *testing.F) {
+	fuzzDeserializer(f, []string{"-ERR unknown command\r\n", "-\r\n", "-E\r"}, func(m string) (interface{}, int, error) {
+		return deserializeSimpleError(m)
+	})
+}
+
+func FuzzDeserializeInteger(f *testing.F) {
+	fuzzDeserializer(f, []string{":1000\r\n", ":-1\r\n", ":+1\r"}, func(m string) (interface{}, int, error) {
+		return deserializeInteger(m)
+	})
+}
+
+func FuzzDeserializeBulkString(f *testing.F) {
+	fuzzDeserializer(f, []string{"$2\r\nOK\r\n", "$0\r\n\r\n", "$5\r\nab\r\n"}, func(m string) (interface{}, int, error) {
+		return deserializeBulkString(m)
+	})
+}
+
+func FuzzDeserializeNullOrBulkString(f *testing.F) {
+	fuzzDeserializer(f, []string{"$-1\r\n", "$2\r\nOK\r\n", "$-1\r"}, deserializeNullOrBulkString)
+}
+
+func FuzzIsNullBulkString(f *testing.F) {
+	fuzzDeserializer(f, []string{"$-1\r\n", "$2\r\nOK\r\n", "$"}, func(m string) (interface{}, int, error) {
+		isNull, err := isNullBulkString(m)
+		return isNull, 0, err
+	})
+}
+
+func FuzzDeserializeNullOrArray(f *testing.F) {
+	fuzzDeserializer(f, []string{"*-1\r\n", "*0\r\n", "*2\r\n$4\r\necho\r\n$2\r\nhi\r\n", "*4\r\n:+123\r\n-ERR\r\n+OK\r\n$-1\r\n"}, deserializeNullOrArray)
+}
+
+func FuzzDeserializeArray(f *testing.F) {
+	fuzzDeserializer(f, []string{"*0\r\n", "*1\r\n:1\r\n", "*2\r\n$4\r\necho\r\n"}, func(m string) (interface{}, int, error) {
+		return deserializeArray(m)
+	})
+}
+
+func FuzzDeserializePrimitive(f *testing.F) {
+	fuzzDeserializer(f, []string{"+OK\r\n", "-ERR\r\n", ":1\r\n", "$-1\r\n", "$2\r\nOK\r\n"}, deserializePrimitive)
+}
+
+func TestSerializeResp3(t *testing.T) {
+	verbatim, _ := serializeVerbatimString("txt", "Some string")
+	var tests = []struct {
+		name  string
+		input string
+		want  string
+	}{
+		// the table itself
+		{"Should serialize map", serializeMap([]string{"+key\r\n", ":1\r\n"}), "%1\r\n+key\r\n:1\r\n"},
+		{"Should serialize set", serializeSet([]string{":1\r\n", ":2\r\n"}), "~2\r\n:1\r\n:2\r\n"},
+		{"Should serialize push", serializePush([]string{"$7\r\nmessage\r\n"}), ">1\r\n$7\r\nmessage\r\n"},
+		{"Should serialize double", serializeDouble(1.5), ",1.5\r\n"},
+		{"Should serialize integral double", serializeDouble(10), ",10\r\n"},
+		{"Should serialize infinite double", serializeDouble(math.Inf(-1)), ",-inf\r\n"},
+		{"Should serialize true", serializeBoolean(true), "#t\r\n"},
+		{"Should serialize false", serializeBoolean(false), "#f\r\n"},
+		{"Should serialize big number", serializeBigNumber(new(big.Int).Lsh(big.NewInt(1), 64)), "(18446744073709551616\r\n"},
+		{"Should serialize verbatim string", verbatim, "=15\r\ntxt:Some string\r\n"},
+		{"Should serialize null", serializeNull(), "_\r\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.input != test.want {
+				t.Errorf("Got '%q' but expected '%q'.", test.input, test.want)
+			}
+		})
+	}
+}
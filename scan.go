@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type scanOptions struct {
+	cursor uint64
+	// Empty matches everything.
+	match    string
+	count    int64
+	typeName string
+	noValues bool
+}
+
+// parseScanOptions parses "cursor [MATCH pattern] [COUNT count]" followed by
+// the one extra option the command supports, if any ("type" or "novalues").
+func parseScanOptions(args []interface{}, extra string) (scanOptions, error) {
+	opts := scanOptions{count: 10}
+	cursor, err := strconv.ParseUint(args[0].(string), 10, 64)
+	if err != nil {
+		return opts, errors.New("ERR invalid cursor")
+	}
+	opts.cursor = cursor
+
+	for i := 1; i < len(args); i++ {
+		opt := strings.ToLower(args[i].(string))
+		switch {
+		case opt == "match" && i+1 < len(args):
+			opts.match = args[i+1].(string)
+			i++
+		case opt == "count" && i+1 < len(args):
+			count, err := parseInteger(args[i+1])
+			if err != nil {
+				return opts, err
+			}
+			if count < 1 {
+				return opts, errors.New("ERR syntax error")
+			}
+			opts.count = count
+			i++
+		case opt == "type" && extra == "type" && i+1 < len(args):
+			opts.typeName = strings.ToLower(args[i+1].(string))
+			i++
+		case opt == "novalues" && extra == "novalues":
+			opts.noValues = true
+		default:
+			return opts, errors.New("ERR syntax error")
+		}
+	}
+	return opts, nil
+}
+
+func (opts scanOptions) matches(name string) bool {
+	return opts.match == "" || globMatch(opts.match, name)
+}
+
+// scanTable returns the names in the buckets of kt from the cursor of opts on,
+// and the cursor to continue from, 0 once every bucket was visited. Like Redis,
+// it visits buckets until COUNT names were collected, but gives up after
+// 10*COUNT buckets so a sparse table doesn't make one call slow.
+func scanTable(kt *keyTable, opts scanOptions) (uint64, []string) {
+	var names []string
+	cursor := opts.cursor
+	for remaining := min(opts.count, math.MaxInt64/10) * 10; remaining > 0; remaining-- {
+		cursor, names = kt.scan(cursor, names)
+		if cursor == 0 || int64(len(names)) >= opts.count {
+			break
+		}
+	}
+	return cursor, names
+}
+
+func serializeScanReply(cursor uint64, items []string) string {
+	elements, _, _ := serializeStringArray(items)
+	return serializeArray([]string{serializeBulkString(strconv.FormatUint(cursor, 10)), elements})
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// getSet returns the set stored at key. ok is false when the key doesn't
+// exist, in which case the returned set is nil.
+// Must be called with store.mu held.
+func getSet(store *dictionary, key string) (s *set, ok bool, err error) {
+	return lookupValue[*set](store, key)
+}
+
+// getSets returns the sets stored at keys, using an empty set for missing keys.
+// Must be called with store.mu held.
+func getSets(store *dictionary, keys []interface{}) ([]*set, error) {
+	sets := make([]*set, len(keys))
+	for i, key := range keys {
+		s, ok, err := getSet(store, key.(string))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			s = newSet()
+		}
+		sets[i] = s
+	}
+	return sets, nil
+}
+
+// storeSet replaces whatever is stored at key with s, deleting the key when s
+// is empty.
+// Must be called with store.mu held.
+func storeSet(store *dictionary, key string, s *set) {
+	if s.size() == 0 {
+		deleteKey(store, key)
+		return
+	}
+	setRecord(store, key, record{value: s, expiryTimestamp: -1})
+}
+
+func setInter(sets []*set, limit int) *set {
+	result := newSet()
+	// Iterate the smallest set and probe the others.
+	sort.Slice(sets, func(i, j int) bool { return sets[i].size() < sets[j].size() })
+	for _, member := range sets[0].list() {
+		inAll := true
+		for _, other := range sets[1:] {
+			if !other.contains(member) {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			result.add(member)
+			if limit > 0 && result.size() >= limit {
+				break
+			}
+		}
+	}
+	return result
+}
+
+func setUnion(sets []*set) *set {
+	result := newSet()
+	for _, s := range sets {
+		for _, member := range s.list() {
+			result.add(member)
+		}
+	}
+	return result
+}
+
+func setDiff(sets []*set) *set {
+	result := newSet()
+	for _, member := range sets[0].list() {
+		inOther := false
+		for _, other := range sets[1:] {
+			if other.contains(member) {
+				inOther = true
+				break
+			}
+		}
+		if !inOther {
+			result.add(member)
+		}
+	}
+	return result
+}
+
+func serializeSetMembers(conn *client, members []string) string {
+	items := make([]string, len(members))
+	for i, member := range members {
+		items[i] = serializeBulkString(member)
+	}
+	return conn.serializeSet(items)
+}
+
+func handleSAdd(arr []interface{}, conn *client, store *dictionary) {
+	key := arr[1].(string)
+
+	s, ok, err := getSet(store, key)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if !ok {
+		s = newSet()
+	}
+	added := 0
+	for _, member := range arr[2:] {
+		if s.add(member.(string)) {
+			added++
+		}
+	}
+	if !ok {
+		setRecord(store, key, record{value: s, expiryTimestamp: -1})
+	} else if added > 0 {
+		signalModifiedKey(store, key)
+	}
+
+	msg, _, _ := serializeInteger(int64(added))
+	sendMsgToClient(conn, msg)
+}
+
+func handleSRem(arr []interface{}, conn *client, store *dictionary) {
+	key := arr[1].(string)
+
+	s, ok, err := getSet(store, key)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	removed := 0
+	if ok {
+		for _, member := range arr[2:] {
+			if s.remove(member.(string)) {
+				removed++
+			}
+		}
+		if s.size() == 0 {
+			deleteKey(store, key)
+		} else if removed > 0 {
+			signalModifiedKey(store, key)
+		}
+	}
+
+	msg, _, _ := serializeInteger(int64(removed))
+	sendMsgToClient(conn, msg)
+}
+
+func handleSIsMember(arr []interface{}, conn *client, store *dictionary) {
+	s, ok, err := getSet(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	isMember := int64(0)
+	if ok && s.contains(arr[2].(string)) {
+		isMember = 1
+	}
+	msg, _, _ := serializeInteger(isMember)
+	sendMsgToClient(conn, msg)
+}
+
+func handleSMIsMember(arr []interface{}, conn *client, store *dictionary) {
+	s, ok, err := getSet(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	items := make([]string, 0, len(arr)-2)
+	for _, member := range arr[2:] {
+		isMember := int64(0)
+		if ok && s.contains(member.(string)) {
+			isMember = 1
+		}
+		msg, _, _ := serializeInteger(isMember)
+		items = append(items, msg)
+	}
+	sendMsgToClient(conn, serializeArray(items))
+}
+
+func handleSMembers(arr []interface{}, conn *client, store *dictionary) {
+	sets, err := getSets(store, arr[1:2])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	sendMsgToClient(conn, serializeSetMembers(conn, sets[0].list()))
+}
+
+func handleSCard(arr []interface{}, conn *client, store *dictionary) {
+	sets, err := getSets(store, arr[1:2])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	msg, _, _ := serializeInteger(int64(sets[0].size()))
+	sendMsgToClient(conn, msg)
+}
+
+func handleSPop(arr []interface{}, conn *client, store *dictionary) {
+	if len(arr) > 3 {
+		sendErrorToClient(conn, "ERR syntax error")
+		return
+	}
+	key := arr[1].(string)
+	count := int64(1)
+	if len(arr) == 3 {
+		var err error
+		count, err = parseInteger(arr[2])
+		if err != nil || count < 0 {
+			sendErrorToClient(conn, "ERR value is out of range, must be positive")
+			return
+		}
+	}
+
+	s, ok, err := getSet(store, key)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if !ok {
+		if len(arr) == 3 {
+			sendMsgToClient(conn, serializeSetMembers(conn, nil))
+		} else {
+			sendMsgToClient(conn, conn.serializeNull())
+		}
+		return
+	}
+
+	popped, _ := randomMembers(s, count)
+	for _, member := range popped {
+		s.remove(member)
+	}
+	if s.size() == 0 {
+		deleteKey(store, key)
+	} else if len(popped) > 0 {
+		signalModifiedKey(store, key)
+	}
+	// Which members were popped is up to chance, replaying has to remove the
+	// same ones.
+	if len(popped) > 0 {
+		rewriteCommand(conn, append([]string{"srem", key}, popped...))
+	} else {
+		rewriteCommand(conn)
+	}
+
+	if len(arr) == 2 {
+		sendMsgToClient(conn, serializeBulkString(popped[0]))
+		return
+	}
+	sendMsgToClient(conn, serializeSetMembers(conn, popped))
+}
+
+func handleSRandMember(arr []interface{}, conn *client, store *dictionary) {
+	if len(arr) > 3 {
+		sendErrorToClient(conn, "ERR syntax error")
+		return
+	}
+	count := int64(1)
+	if len(arr) == 3 {
+		var err error
+		count, err = parseInteger(arr[2])
+		if err != nil {
+			sendErrorToClient(conn, err.Error())
+			return
+		}
+	}
+
+	sets, err := getSets(store, arr[1:2])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if len(arr) == 2 {
+		if sets[0].size() == 0 {
+			sendMsgToClient(conn, conn.serializeNull())
+			return
+		}
+		sendMsgToClient(conn, serializeBulkString(sets[0].random()))
+		return
+	}
+
+	// A negative count may return the same member several times.
+	picked, err := randomMembers(sets[0], count)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	msg, _, _ := serializeStringArray(picked)
+	sendMsgToClient(conn, msg)
+}
+
+func handleSMove(arr []interface{}, conn *client, store *dictionary) {
+	srcKey, dstKey, member := arr[1].(string), arr[2].(string), arr[3].(string)
+
+	src, srcOk, err := getSet(store, srcKey)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	dst, dstOk, err := getSet(store, dstKey)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	if !srcOk || !src.contains(member) {
+		msg, _, _ := serializeInteger(0)
+		sendMsgToClient(conn, msg)
+		return
+	}
+	if srcKey != dstKey {
+		src.remove(member)
+		if src.size() == 0 {
+			deleteKey(store, srcKey)
+		} else {
+			signalModifiedKey(store, srcKey)
+		}
+		if !dstOk {
+			dst = newSet()
+			dst.add(member)
+			setRecord(store, dstKey, record{value: dst, expiryTimestamp: -1})
+		} else if dst.add(member) {
+			signalModifiedKey(store, dstKey)
+		}
+	}
+
+	msg, _, _ := serializeInteger(1)
+	sendMsgToClient(conn, msg)
+}
+
+func handleSInter(arr []interface{}, conn *client, store *dictionary) {
+	setAlgebraGeneric(arr, conn, store, false, func(sets []*set) *set { return setInter(sets, 0) })
+}
+
+func handleSUnion(arr []interface{}, conn *client, store *dictionary) {
+	setAlgebraGeneric(arr, conn, store, false, setUnion)
+}
+
+func handleSDiff(arr []interface{}, conn *client, store *dictionary) {
+	setAlgebraGeneric(arr, conn, store, false, setDiff)
+}
+
+func handleSInterStore(arr []interface{}, conn *client, store *dictionary) {
+	setAlgebraGeneric(arr, conn, store, true, func(sets []*set) *set { return setInter(sets, 0) })
+}
+
+func handleSUnionStore(arr []interface{}, conn *client, store *dictionary) {
+	setAlgebraGeneric(arr, conn, store, true, setUnion)
+}
+
+func handleSDiffStore(arr []interface{}, conn *client, store *dictionary) {
+	setAlgebraGeneric(arr, conn, store, true, setDiff)
+}
+
+// setAlgebraGeneric implements SINTER, SUNION, SDIFF and their STORE variants,
+// which take the destination key as the first argument.
+func setAlgebraGeneric(arr []interface{}, conn *client, store *dictionary, storeResult bool, op func([]*set) *set) {
+	keys := arr[1:]
+	if storeResult {
+		keys = arr[2:]
+	}
+
+	sets, err := getSets(store, keys)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	result := op(sets)
+
+	if storeResult {
+		storeSet(store, arr[1].(string), result)
+		msg, _, _ := serializeInteger(int64(result.size()))
+		sendMsgToClient(conn, msg)
+		return
+	}
+	sendMsgToClient(conn, serializeSetMembers(conn, result.list()))
+}
+
+func handleSInterCard(arr []interface{}, conn *client, store *dictionary) {
+	numKeys, err := parseInteger(arr[1])
+	if err != nil || numKeys <= 0 {
+		sendErrorToClient(conn, "ERR numkeys should be greater than 0")
+		return
+	}
+	if numKeys > int64(len(arr)-2) {
+		sendErrorToClient(conn, "ERR Number of keys can't be greater than number of args")
+		return
+	}
+	keys := arr[2 : 2+numKeys]
+
+	limit := int64(0)
+	rest := arr[2+numKeys:]
+	switch {
+	case len(rest) == 0:
+	case len(rest) == 2 && strings.ToLower(rest[0].(string)) == "limit":
+		limit, err = parseInteger(rest[1])
+		if err != nil || limit < 0 {
+			sendErrorToClient(conn, "ERR LIMIT can't be negative")
+			return
+		}
+	default:
+		sendErrorToClient(conn, "ERR syntax error")
+		return
+	}
+
+	sets, err := getSets(store, keys)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	msg, _, _ := serializeInteger(int64(setInter(sets, int(limit)).size()))
+	sendMsgToClient(conn, msg)
+}
+
+// handleSScan walks the members of the set a few buckets per call, like SCAN.
+// Integer sets are returned whole in a single call with a zero cursor, the same
+// way Redis scans them, they are small.
+func handleSScan(arr []interface{}, conn *client, store *dictionary) {
+	opts, err := parseScanOptions(arr[2:], "")
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	sets, err := getSets(store, arr[1:2])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	s := sets[0]
+	var cursor uint64
+	var members []string
+	if s.isIntset() {
+		members = s.list()
+	} else {
+		cursor, members = scanTable(s.table, opts)
+	}
+	var items []string
+	for _, member := range members {
+		if opts.matches(member) {
+			items = append(items, member)
+		}
+	}
+	sendMsgToClient(conn, serializeScanReply(cursor, items))
+}
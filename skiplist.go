@@ -0,0 +1,374 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Same parameters as the Redis skiplist, enough for 2^64 elements.
+const skiplistMaxLevel = 32
+const skiplistP = 0.25
+
+// skiplist keeps members ordered by score, then by member. Every forward link
+// records how many nodes it skips so ranks can be computed in O(log n).
+type skiplist struct {
+	header *skiplistNode
+	tail   *skiplistNode
+	length int
+	level  int
+}
+
+type skiplistNode struct {
+	member   string
+	score    float64
+	backward *skiplistNode
+	level    []skiplistLevel
+}
+
+type skiplistLevel struct {
+	forward *skiplistNode
+	span    int
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		header: &skiplistNode{level: make([]skiplistLevel, skiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+func randomSkiplistLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+	return level
+}
+
+// before reports whether n sorts before (score, member).
+func (n *skiplistNode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// after reports whether n sorts after (score, member).
+func (n *skiplistNode) after(score float64, member string) bool {
+	return n.score > score || (n.score == score && n.member > member)
+}
+
+// insert adds a new node, the member must not be in the list yet.
+func (zsl *skiplist) insert(score float64, member string) *skiplistNode {
+	var update [skiplistMaxLevel]*skiplistNode
+	var rank [skiplistMaxLevel]int
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomSkiplistLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+
+	x = &skiplistNode{member: member, score: score, level: make([]skiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	// Levels above the new node now skip one more element.
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+	return x
+}
+
+// unlink removes x given the rightmost nodes before it on every level.
+func (zsl *skiplist) unlink(x *skiplistNode, update []*skiplistNode) {
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+}
+
+// findUpdate returns the rightmost node before (score, member) on every level.
+func (zsl *skiplist) findUpdate(score float64, member string) []*skiplistNode {
+	update := make([]*skiplistNode, skiplistMaxLevel)
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	return update
+}
+
+// delete removes the node with the given score and member, reporting whether
+// it was found.
+func (zsl *skiplist) delete(score float64, member string) bool {
+	update := zsl.findUpdate(score, member)
+	x := update[0].level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+	zsl.unlink(x, update)
+	return true
+}
+
+// updateScore moves member from curScore to newScore.
+func (zsl *skiplist) updateScore(curScore float64, member string, newScore float64) {
+	update := zsl.findUpdate(curScore, member)
+	x := update[0].level[0].forward
+
+	// Update in place when the node stays at the same position.
+	if (x.backward == nil || x.backward.before(newScore, member)) &&
+		(x.level[0].forward == nil || !x.level[0].forward.before(newScore, member)) {
+		x.score = newScore
+		return
+	}
+	zsl.unlink(x, update)
+	zsl.insert(newScore, member)
+}
+
+// rank returns the 1-based rank of the element, or 0 if it isn't found.
+func (zsl *skiplist) rank(score float64, member string) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !x.level[i].forward.after(score, member) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != zsl.header && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// byRank returns the node at the 1-based rank, or nil if out of range.
+func (zsl *skiplist) byRank(rank int) *skiplistNode {
+	traversed := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank && x != zsl.header {
+			return x
+		}
+	}
+	return nil
+}
+
+// firstMatching returns the first node for which aboveMin holds. aboveMin must
+// be monotonic over the list order.
+func (zsl *skiplist) firstMatching(aboveMin func(*skiplistNode) bool) *skiplistNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !aboveMin(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	return x.level[0].forward
+}
+
+// lastMatching returns the last node for which belowMax holds. belowMax must
+// be monotonic over the list order.
+func (zsl *skiplist) lastMatching(belowMax func(*skiplistNode) bool) *skiplistNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && belowMax(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	if x == zsl.header {
+		return nil
+	}
+	return x
+}
+
+// scoreRange is a score interval as given to ZRANGEBYSCORE, where a leading
+// '(' makes a bound exclusive.
+type scoreRange struct {
+	min, max     float64
+	minex, maxex bool
+}
+
+func parseScoreBound(arg string) (val float64, exclusive bool, err error) {
+	if strings.HasPrefix(arg, "(") {
+		exclusive = true
+		arg = arg[1:]
+	}
+	val, err = strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(val) {
+		return 0, false, errors.New("ERR min or max is not a float")
+	}
+	return val, exclusive, nil
+}
+
+func parseScoreRange(min, max string) (r scoreRange, err error) {
+	if r.min, r.minex, err = parseScoreBound(min); err != nil {
+		return r, err
+	}
+	if r.max, r.maxex, err = parseScoreBound(max); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+func (r scoreRange) aboveMin(score float64) bool {
+	if r.minex {
+		return score > r.min
+	}
+	return score >= r.min
+}
+
+func (r scoreRange) belowMax(score float64) bool {
+	if r.maxex {
+		return score < r.max
+	}
+	return score <= r.max
+}
+
+func (r scoreRange) contains(score float64) bool {
+	return r.aboveMin(score) && r.belowMax(score)
+}
+
+// lexRange is a member interval as given to ZRANGEBYLEX. Bounds are written
+// "[member" or "(member", while "-" and "+" stand for the smallest and the
+// largest possible member.
+type lexRange struct {
+	min, max       string
+	minex, maxex   bool
+	minInf, maxInf bool
+}
+
+func parseLexBound(arg string) (val string, exclusive bool, inf bool, err error) {
+	switch {
+	case arg == "-" || arg == "+":
+		return "", false, true, nil
+	case strings.HasPrefix(arg, "("):
+		return arg[1:], true, false, nil
+	case strings.HasPrefix(arg, "["):
+		return arg[1:], false, false, nil
+	}
+	return "", false, false, errors.New("ERR min or max not valid string range item")
+}
+
+func parseLexRange(min, max string) (r lexRange, err error) {
+	if r.min, r.minex, r.minInf, err = parseLexBound(min); err != nil {
+		return r, err
+	}
+	if r.max, r.maxex, r.maxInf, err = parseLexBound(max); err != nil {
+		return r, err
+	}
+	// "+" as the minimum or "-" as the maximum leaves nothing in range.
+	if (r.minInf && min == "+") || (r.maxInf && max == "-") {
+		r.min, r.minInf, r.minex = "", false, true
+		r.max, r.maxInf, r.maxex = "", false, true
+	}
+	return r, nil
+}
+
+func (r lexRange) aboveMin(member string) bool {
+	switch {
+	case r.minInf:
+		return true
+	case r.minex:
+		return member > r.min
+	}
+	return member >= r.min
+}
+
+func (r lexRange) belowMax(member string) bool {
+	switch {
+	case r.maxInf:
+		return true
+	case r.maxex:
+		return member < r.max
+	}
+	return member <= r.max
+}
+
+func (r lexRange) contains(member string) bool {
+	return r.aboveMin(member) && r.belowMax(member)
+}
+
+// firstInScoreRange returns the first node within r, or nil if there is none.
+func (zsl *skiplist) firstInScoreRange(r scoreRange) *skiplistNode {
+	x := zsl.firstMatching(func(n *skiplistNode) bool { return r.aboveMin(n.score) })
+	if x == nil || !r.belowMax(x.score) {
+		return nil
+	}
+	return x
+}
+
+// lastInScoreRange returns the last node within r, or nil if there is none.
+func (zsl *skiplist) lastInScoreRange(r scoreRange) *skiplistNode {
+	x := zsl.lastMatching(func(n *skiplistNode) bool { return r.belowMax(n.score) })
+	if x == nil || !r.aboveMin(x.score) {
+		return nil
+	}
+	return x
+}
+
+// firstInLexRange returns the first node within r, or nil if there is none.
+// Lex ranges are only meaningful when all members share the same score.
+func (zsl *skiplist) firstInLexRange(r lexRange) *skiplistNode {
+	x := zsl.firstMatching(func(n *skiplistNode) bool { return r.aboveMin(n.member) })
+	if x == nil || !r.belowMax(x.member) {
+		return nil
+	}
+	return x
+}
+
+// lastInLexRange returns the last node within r, or nil if there is none.
+func (zsl *skiplist) lastInLexRange(r lexRange) *skiplistNode {
+	x := zsl.lastMatching(func(n *skiplistNode) bool { return r.belowMax(n.member) })
+	if x == nil || !r.aboveMin(x.member) {
+		return nil
+	}
+	return x
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// checkSkiplist compares zsl against the expected members, already sorted, and
+// verifies ranks and backward links along the way.
+func checkSkiplist(t *testing.T, zsl *skiplist, expected []string, scores map[string]float64) {
+	t.Helper()
+	if zsl.length != len(expected) {
+		t.Fatalf("Got length '%d' but expected '%d'.", zsl.length, len(expected))
+	}
+	i := 0
+	var prev *skiplistNode
+	for x := zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		if x.member != expected[i] || x.score != scores[x.member] {
+			t.Fatalf("Got '%s' (%g) at %d but expected '%s'.", x.member, x.score, i, expected[i])
+		}
+		if x.backward != prev {
+			t.Fatalf("Wrong backward link at '%s'.", x.member)
+		}
+		if rank := zsl.rank(x.score, x.member); rank != i+1 {
+			t.Fatalf("Got rank '%d' for '%s' but expected '%d'.", rank, x.member, i+1)
+		}
+		if n := zsl.byRank(i + 1); n != x {
+			t.Fatalf("byRank(%d) didn't return '%s'.", i+1, x.member)
+		}
+		prev = x
+		i++
+	}
+	if zsl.tail != prev {
+		t.Fatalf("Wrong tail.")
+	}
+}
+
+func TestSkiplist(t *testing.T) {
+	zsl := newSkiplist()
+	scores := map[string]float64{}
+	sorted := func() []string {
+		members := make([]string, 0, len(scores))
+		for member := range scores {
+			members = append(members, member)
+		}
+		sort.Slice(members, func(i, j int) bool {
+			a, b := members[i], members[j]
+			return scores[a] < scores[b] || (scores[a] == scores[b] && a < b)
+		})
+		return members
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		member := strconv.Itoa(rng.Intn(300))
+		score := float64(rng.Intn(50))
+		cur, exists := scores[member]
+		switch {
+		case !exists:
+			zsl.insert(score, member)
+			scores[member] = score
+		case rng.Intn(2) == 0:
+			zsl.updateScore(cur, member, score)
+			scores[member] = score
+		default:
+			if !zsl.delete(cur, member) {
+				t.Fatalf("Couldn't delete '%s'.", member)
+			}
+			delete(scores, member)
+		}
+	}
+	checkSkiplist(t, zsl, sorted(), scores)
+
+	if zsl.delete(-1, "missing") {
+		t.Errorf("Deleted a missing member.")
+	}
+	if zsl.byRank(zsl.length+1) != nil {
+		t.Errorf("Expected no node past the end.")
+	}
+}
+
+func TestSkiplistRanges(t *testing.T) {
+	zsl := newSkiplist()
+	for i, member := range []string{"a", "b", "c", "d", "e"} {
+		zsl.insert(float64(i+1), member)
+	}
+
+	// the table itself
+	scoreTests := []struct {
+		min, max    string
+		first, last string
+	}{
+		{"-inf", "+inf", "a", "e"},
+		{"2", "4", "b", "d"},
+		{"(2", "(4", "c", "c"},
+		{"(1", "5", "b", "e"},
+		{"6", "10", "", ""},
+		{"(3", "(3", "", ""},
+		{"4", "2", "", ""},
+	}
+	for _, test := range scoreTests {
+		r, err := parseScoreRange(test.min, test.max)
+		if err != nil {
+			t.Fatalf("Unexpected error '%v'.", err)
+		}
+		first, last := zsl.firstInScoreRange(r), zsl.lastInScoreRange(r)
+		if test.first == "" {
+			if first != nil || last != nil {
+				t.Errorf("Expected an empty range for [%s, %s].", test.min, test.max)
+			}
+			continue
+		}
+		if first == nil || first.member != test.first || last == nil || last.member != test.last {
+			t.Errorf("Got wrong ends of [%s, %s], expected '%s' and '%s'.", test.min, test.max, test.first, test.last)
+		}
+	}
+
+	lexTests := []struct {
+		min, max    string
+		first, last string
+	}{
+		{"-", "+", "a", "e"},
+		{"[b", "[d", "b", "d"},
+		{"(b", "(d", "c", "c"},
+		{"-", "(c", "a", "b"},
+		{"[z", "+", "", ""},
+		{"+", "-", "", ""},
+	}
+	for _, test := range lexTests {
+		r, err := parseLexRange(test.min, test.max)
+		if err != nil {
+			t.Fatalf("Unexpected error '%v'.", err)
+		}
+		first, last := zsl.firstInLexRange(r), zsl.lastInLexRange(r)
+		if test.first == "" {
+			if first != nil || last != nil {
+				t.Errorf("Expected an empty range for [%s, %s].", test.min, test.max)
+			}
+			continue
+		}
+		if first == nil || first.member != test.first || last == nil || last.member != test.last {
+			t.Errorf("Got wrong ends of [%s, %s], expected '%s' and '%s'.", test.min, test.max, test.first, test.last)
+		}
+	}
+
+	if _, err := parseScoreRange("x", "1"); err == nil {
+		t.Errorf("Expected an error for an invalid score.")
+	}
+	if _, err := parseLexRange("b", "[c"); err == nil {
+		t.Errorf("Expected an error for an invalid lex bound.")
+	}
+}
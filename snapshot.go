@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Snapshots store all databases in a binary file, in the spirit of the Redis
+// RDB format but simpler:
+//
+//	"REDISLITE" version
+//	{ opSelectDB db { [opExpireMs unix-ms] type key value } }
+//	opEOF checksum
+//
+// Numbers are uvarints, except unix-ms, scores and the checksum, which are 8
+// bytes little-endian. Strings are a length followed by the bytes, and
+// aggregates the number of elements followed by the elements. The checksum is
+// the CRC-64 (ECMA) of everything before it.
+
+const (
+	snapshotMagic   = "REDISLITE"
+	snapshotVersion = "0001"
+)
+
+// Value types.
+const (
+	snapshotTypeString byte = iota
+	snapshotTypeList
+	snapshotTypeSet
+	snapshotTypeSortedSet
+	snapshotTypeHash
+)
+
+// Opcodes, chosen not to collide with value types.
+const (
+	snapshotOpExpireMs byte = 0xfc
+	snapshotOpSelectDB byte = 0xfe
+	snapshotOpEOF      byte = 0xff
+)
+
+var snapshotCRCTable = crc64.MakeTable(crc64.ECMA)
+
+// checksum is a running checksum of the bytes written to it.
+type checksum interface {
+	io.Writer
+	Sum64() uint64
+}
+
+// snapshotChecksum is the running CRC-64 of the bytes written to it.
+type snapshotChecksum uint64
+
+func (c *snapshotChecksum) Write(p []byte) (int, error) {
+	*c = snapshotChecksum(crc64.Update(uint64(*c), snapshotCRCTable, p))
+	return len(p), nil
+}
+
+func (c *snapshotChecksum) Sum64() uint64 {
+	return uint64(*c)
+}
+
+// snapshotDB is the content of one database to be written.
+type snapshotDB struct {
+	id   int
+	dict map[string]record
+}
+
+// snapshotEncoder writes a snapshot, checksumming everything it writes.
+type snapshotEncoder struct {
+	w   *bufio.Writer
+	out io.Writer
+	crc snapshotChecksum
+	buf [binary.MaxVarintLen64]byte
+}
+
+func newSnapshotEncoder(w io.Writer) *snapshotEncoder {
+	e := &snapshotEncoder{out: w}
+	e.w = bufio.NewWriter(io.MultiWriter(w, &e.crc))
+	return e
+}
+
+// Errors are sticky in the bufio.Writer, so the writes below don't return
+// them, encode reports them when flushing.
+
+func (e *snapshotEncoder) writeByte(b byte) {
+	e.w.WriteByte(b)
+}
+
+func (e *snapshotEncoder) writeUvarint(n uint64) {
+	e.w.Write(e.buf[:binary.PutUvarint(e.buf[:], n)])
+}
+
+func (e *snapshotEncoder) writeUint64(n uint64) {
+	e.w.Write(binary.LittleEndian.AppendUint64(e.buf[:0], n))
+}
+
+func (e *snapshotEncoder) writeString(s string) {
+	e.writeUvarint(uint64(len(s)))
+	e.w.WriteString(s)
+}
+
+func (e *snapshotEncoder) writeRecord(key string, rec record) {
+	if rec.expiryTimestamp != -1 {
+		e.writeByte(snapshotOpExpireMs)
+		e.writeUint64(uint64(rec.expiryTimestamp))
+	}
+
+	switch v := rec.value.(type) {
+	case string:
+		e.writeByte(snapshotTypeString)
+		e.writeString(key)
+		e.writeString(v)
+	case linkedList:
+		e.writeByte(snapshotTypeList)
+		e.writeString(key)
+		e.writeUvarint(uint64(v.length))
+		for n := v.head; n != nil; n = n.next {
+			e.writeString(n.value)
+		}
+	case *set:
+		e.writeByte(snapshotTypeSet)
+		e.writeString(key)
+		members := v.list()
+		e.writeUvarint(uint64(len(members)))
+		for _, member := range members {
+			e.writeString(member)
+		}
+	case *sortedSet:
+		e.writeByte(snapshotTypeSortedSet)
+		e.writeString(key)
+		e.writeUvarint(uint64(v.size()))
+		for x := v.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+			e.writeString(x.member)
+			e.writeUint64(math.Float64bits(x.score))
+		}
+	case *hash:
+		e.writeByte(snapshotTypeHash)
+		e.writeString(key)
+		e.writeUvarint(uint64(v.size()))
+		for field, val := range v.fields {
+			e.writeString(field)
+			e.writeString(val)
+		}
+	}
+}
+
+// encode writes a complete snapshot of dbs.
+func (e *snapshotEncoder) encode(dbs []snapshotDB) error {
+	e.w.WriteString(snapshotMagic + snapshotVersion)
+	for _, db := range dbs {
+		if len(db.dict) == 0 {
+			continue
+		}
+		e.writeByte(snapshotOpSelectDB)
+		e.writeUvarint(uint64(db.id))
+		for key, rec := range db.dict {
+			e.writeRecord(key, rec)
+		}
+	}
+	e.writeByte(snapshotOpEOF)
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+
+	// The checksum doesn't cover itself, so it bypasses the checksumming
+	// writer.
+	_, err := e.out.Write(binary.LittleEndian.AppendUint64(nil, uint64(e.crc)))
+	return err
+}
+
+// saveSnapshot writes dbs to path in format. The snapshot is written to a
+// temporary file first and renamed over path, so path always holds a complete
+// one.
+func saveSnapshot(path string, dbs []snapshotDB, format snapshotFormat) (err error) {
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf("temp-%d.rdb", os.Getpid()))
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	encode := newSnapshotEncoder(f).encode
+	if format == snapshotFormatRDB {
+		encode = newRDBEncoder(f).encode
+	}
+	if err = encode(dbs); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// snapshotError reports where a snapshot stops being readable.
+type snapshotError struct {
+	// Offset of the record that couldn't be read.
+	offset int64
+	err    error
+}
+
+func (e *snapshotError) Error() string {
+	return fmt.Sprintf("bad snapshot at offset %d: %v", e.offset, e.err)
+}
+
+func (e *snapshotError) Unwrap() error {
+	return e.err
+}
+
+var errSnapshotChecksum = errors.New("checksum mismatch")
+
+// snapshotDecoder reads a snapshot, checksumming everything it reads.
+type snapshotDecoder struct {
+	r      *bufio.Reader
+	crc    checksum
+	offset int64
+}
+
+func newSnapshotDecoder(r io.Reader) *snapshotDecoder {
+	return &snapshotDecoder{r: bufio.NewReader(r), crc: new(snapshotChecksum)}
+}
+
+// ReadByte makes the decoder an io.ByteReader for binary.ReadUvarint.
+func (d *snapshotDecoder) ReadByte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, noEOF(err)
+	}
+	d.crc.Write([]byte{b})
+	d.offset++
+	return b, nil
+}
+
+func (d *snapshotDecoder) readFull(n uint64) ([]byte, error) {
+	// Don't trust a corrupted length with a huge allocation.
+	if n > maxBulkLength {
+		return nil, fmt.Errorf("length %d too large", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return nil, noEOF(err)
+	}
+	d.crc.Write(buf)
+	d.offset += int64(n)
+	return buf, nil
+}
+
+func (d *snapshotDecoder) readUvarint() (uint64, error) {
+	return binary.ReadUvarint(d)
+}
+
+func (d *snapshotDecoder) readUint64() (uint64, error) {
+	buf, err := d.readFull(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+func (d *snapshotDecoder) readString() (string, error) {
+	n, err := d.readUvarint()
+	if err != nil {
+		return "", err
+	}
+	buf, err := d.readFull(n)
+	return string(buf), err
+}
+
+// readValue reads a value of the given type.
+func (d *snapshotDecoder) readValue(valueType byte) (interface{}, error) {
+	if valueType == snapshotTypeString {
+		return d.readString()
+	}
+
+	n, err := d.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	switch valueType {
+	case snapshotTypeList:
+		var ll linkedList
+		for i := uint64(0); i < n; i++ {
+			val, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			ll.pushBack(val)
+		}
+		return ll, nil
+	case snapshotTypeSet:
+		s := newSet()
+		for i := uint64(0); i < n; i++ {
+			member, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			s.add(member)
+		}
+		return s, nil
+	case snapshotTypeSortedSet:
+		z := newSortedSet()
+		for i := uint64(0); i < n; i++ {
+			member, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			bits, err := d.readUint64()
+			if err != nil {
+				return nil, err
+			}
+			z.set(member, math.Float64frombits(bits))
+		}
+		return z, nil
+	case snapshotTypeHash:
+		h := newHash()
+		for i := uint64(0); i < n; i++ {
+			field, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			val, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			h.set(field, val)
+		}
+		return h, nil
+	}
+	return nil, fmt.Errorf("unknown value type %d", valueType)
+}
+
+// decode reads a whole snapshot, calling fn for every record. It stops at the
+// first error, returned as a *snapshotError unless fn returned it.
+func (d *snapshotDecoder) decode(fn func(db int, key string, rec record) error) error {
+	start := d.offset
+	fail := func(err error) error {
+		return &snapshotError{offset: start, err: err}
+	}
+
+	header, err := d.readFull(uint64(len(snapshotMagic + snapshotVersion)))
+	if err != nil {
+		return fail(err)
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return fail(errors.New("not a redis-lite snapshot"))
+	}
+	if version := string(header[len(snapshotMagic):]); version != snapshotVersion {
+		return fail(fmt.Errorf("unsupported version %s", version))
+	}
+
+	db := -1
+	for {
+		start = d.offset
+		op, err := d.ReadByte()
+		if err != nil {
+			return fail(err)
+		}
+
+		switch op {
+		case snapshotOpEOF:
+			expected := d.crc.Sum64()
+			sum, err := d.readUint64()
+			if err != nil {
+				return fail(err)
+			}
+			if sum != expected {
+				return fail(errSnapshotChecksum)
+			}
+			return nil
+		case snapshotOpSelectDB:
+			id, err := d.readUvarint()
+			if err != nil {
+				return fail(err)
+			}
+			db = int(id)
+			continue
+		}
+
+		if db == -1 {
+			return fail(errors.New("record before any database was selected"))
+		}
+		rec := record{expiryTimestamp: -1}
+		if op == snapshotOpExpireMs {
+			when, err := d.readUint64()
+			if err != nil {
+				return fail(err)
+			}
+			rec.expiryTimestamp = int64(when)
+			if op, err = d.ReadByte(); err != nil {
+				return fail(err)
+			}
+		}
+		key, err := d.readString()
+		if err != nil {
+			return fail(err)
+		}
+		if rec.value, err = d.readValue(op); err != nil {
+			return fail(err)
+		}
+		if err := fn(db, key, rec); err != nil {
+			return err
+		}
+	}
+}
+
+// loadSnapshot reads the snapshot at path into the databases of srv, skipping
+// keys that expired in the meantime. Both our own format and Redis RDB files
+// are read, told apart by their header.
+func loadSnapshot(srv *server, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = decodeSnapshot(f, func(db int, key string, rec record) error {
+		if db >= len(srv.dbs) {
+			return fmt.Errorf("snapshot has database %d, but only %d are configured", db, len(srv.dbs))
+		}
+		if !recordExpired(rec.expiryTimestamp) {
+			setRecord(srv.dbs[db], key, rec)
+		}
+		return nil
+	})
+	// The loaded keys are what the snapshot holds already.
+	srv.persistence.dirty = 0
+	return err
+}
+
+// decodeSnapshot reads a snapshot in either format, told apart by its header.
+func decodeSnapshot(r io.Reader, fn func(db int, key string, rec record) error) error {
+	br := bufio.NewReader(r)
+	if header, _ := br.Peek(len(rdbMagic) + 4); isRDBHeader(header) {
+		return newRDBDecoder(br).decode(fn)
+	}
+	return newSnapshotDecoder(br).decode(fn)
+}
+
+// noEOF turns io.EOF into io.ErrUnexpectedEOF, a snapshot only ends after its
+// checksum.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
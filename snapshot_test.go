@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// testDatabases returns databases holding a value of every type.
+func testDatabases() []snapshotDB {
+	var ll linkedList
+	for _, val := range []string{"a", "b", "c"} {
+		ll.pushBack(val)
+	}
+	ints := newSet()
+	ints.add("1")
+	ints.add("2")
+	strs := newSet()
+	strs.add("x")
+	strs.add("")
+	z := newSortedSet()
+	z.set("one", 1)
+	z.set("inf", math.Inf(1))
+	z.set("neg", -2.5)
+	h := newHash()
+	h.set("field", "value")
+	h.set("empty", "")
+
+	return []snapshotDB{
+		{id: 0, dict: map[string]record{
+			"string":   {value: "value", expiryTimestamp: -1},
+			"volatile": {value: "", expiryTimestamp: 4102444800000},
+			"list":     {value: ll, expiryTimestamp: -1},
+			"hash":     {value: h, expiryTimestamp: -1},
+		}},
+		{id: 1, dict: map[string]record{}},
+		{id: 3, dict: map[string]record{
+			"intset": {value: ints, expiryTimestamp: -1},
+			"set":    {value: strs, expiryTimestamp: 1},
+			"zset":   {value: z, expiryTimestamp: -1},
+		}},
+	}
+}
+
+// comparable turns a record value into something reflect.DeepEqual can
+// compare.
+func comparable(value interface{}) interface{} {
+	switch v := value.(type) {
+	case linkedList:
+		return listValues(&v)
+	case *set:
+		members := map[string]bool{}
+		for _, member := range v.list() {
+			members[member] = true
+		}
+		return members
+	case *sortedSet:
+		return v.dict
+	case *hash:
+		return v.fields
+	}
+	return value
+}
+
+func encodeSnapshot(t *testing.T, dbs []snapshotDB) []byte {
+	var buf bytes.Buffer
+	if err := newSnapshotEncoder(&buf).encode(dbs); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	dbs := testDatabases()
+	data := encodeSnapshot(t, dbs)
+
+	got := map[int]map[string]record{}
+	err := newSnapshotDecoder(bytes.NewReader(data)).decode(func(db int, key string, rec record) error {
+		if got[db] == nil {
+			got[db] = map[string]record{}
+		}
+		got[db][key] = rec
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := got[1]; ok {
+		t.Errorf("Expected the empty database to be skipped.")
+	}
+	for _, db := range dbs {
+		for key, want := range db.dict {
+			rec, ok := got[db.id][key]
+			if !ok {
+				t.Errorf("Missing '%s' in db %d.", key, db.id)
+				continue
+			}
+			if rec.expiryTimestamp != want.expiryTimestamp {
+				t.Errorf("Got expiry '%d' for '%s' but expected '%d'.", rec.expiryTimestamp, key, want.expiryTimestamp)
+			}
+			if !reflect.DeepEqual(comparable(rec.value), comparable(want.value)) {
+				t.Errorf("Got '%v' for '%s' but expected '%v'.", comparable(rec.value), key, comparable(want.value))
+			}
+		}
+	}
+	if s := got[3]["intset"].value.(*set); !s.isIntset() {
+		t.Errorf("Expected the integer set to keep its encoding.")
+	}
+}
+
+func TestSnapshotCorruption(t *testing.T) {
+	data := encodeSnapshot(t, testDatabases())
+	header := len(snapshotMagic + snapshotVersion)
+
+	flipped := bytes.Clone(data)
+	flipped[len(flipped)-10] ^= 0x01
+	badSum := bytes.Clone(data)
+	badSum[len(badSum)-1] ^= 0x01
+
+	var tests = []struct {
+		name string
+		data []byte
+		// The error, or nil for any snapshotError.
+		want error
+	}{
+		// the table itself
+		{"Should reject a changed byte", flipped, nil},
+		{"Should reject a wrong checksum", badSum, errSnapshotChecksum},
+		{"Should reject a missing checksum", data[:len(data)-8], io.ErrUnexpectedEOF},
+		{"Should reject a truncated record", data[:header+5], io.ErrUnexpectedEOF},
+		{"Should reject an empty file", nil, io.ErrUnexpectedEOF},
+		{"Should reject another format", []byte("REDIS0011\xff"), nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := newSnapshotDecoder(bytes.NewReader(test.data)).decode(func(int, string, record) error {
+				return nil
+			})
+			var snapErr *snapshotError
+			if !errors.As(err, &snapErr) {
+				t.Fatalf("Expected a snapshot error but got '%v'.", err)
+			}
+			if test.want != nil && !errors.Is(err, test.want) {
+				t.Errorf("Got '%v' but expected '%v'.", err, test.want)
+			}
+			if snapErr.offset < 0 || snapErr.offset > int64(len(test.data)) {
+				t.Errorf("Got offset '%d' outside the snapshot.", snapErr.offset)
+			}
+		})
+	}
+
+	// The checksum error points at the EOF opcode.
+	err := newSnapshotDecoder(bytes.NewReader(badSum)).decode(func(int, string, record) error {
+		return nil
+	})
+	var snapErr *snapshotError
+	if errors.As(err, &snapErr) && snapErr.offset != int64(len(data)-9) {
+		t.Errorf("Got offset '%d' but expected '%d'.", snapErr.offset, len(data)-9)
+	}
+}
+
+func TestDirtyCount(t *testing.T) {
+	srv := newServer(defaultConfig())
+	conn := newClient(nil, srv.dbs[0])
+
+	var tests = []struct {
+		name string
+		args []string
+		want int64
+	}{
+		// the table itself
+		{"Should count every key of MSET", []string{"mset", "k1", "v1", "k2", "v2", "k3", "v3"}, 3},
+		{"Should not count a failing write", []string{"lpush", "k1", "x"}, 0},
+		{"Should not count a write that does nothing", []string{"setnx", "k1", "x"}, 0},
+		{"Should not count deleting a missing key", []string{"del", "missing"}, 0},
+		{"Should count deleted keys", []string{"del", "k1", "k2", "missing"}, 2},
+		{"Should count a new set once", []string{"sadd", "set", "a", "b"}, 1},
+		{"Should count a set changed in place", []string{"sadd", "set", "c"}, 1},
+		{"Should not count adding an existing member", []string{"sadd", "set", "a"}, 0},
+		{"Should count a new hash once", []string{"hset", "hash", "f", "v"}, 1},
+		{"Should not count removing a missing field", []string{"hdel", "hash", "g"}, 0},
+		{"Should count a list push", []string{"rpush", "list", "a", "b"}, 1},
+		{"Should count every key flushed", []string{"flushdb"}, 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := srv.persistence.dirty
+			runCommand(conn, test.args...)
+			if got := srv.persistence.dirty - before; got != test.want {
+				t.Errorf("Expected '%d' changes but got '%d'", test.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var errStringTooBig = errors.New("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+
+// getString returns the string stored at key. ok is false when the key doesn't
+// exist.
+// Must be called with store.mu held.
+func getString(store *dictionary, key string) (val string, ok bool, err error) {
+	return lookupValue[string](store, key)
+}
+
+// setStringKeepTTL overwrites the string at key without touching its expiry,
+// the way commands modifying a value in place behave.
+// Must be called with store.mu held.
+func setStringKeepTTL(store *dictionary, key string, val string) {
+	rec, ok := store.dict[key]
+	if !ok {
+		rec.expiryTimestamp = -1
+	}
+	setRecord(store, key, record{value: val, expiryTimestamp: rec.expiryTimestamp})
+}
+
+func handleAppend(arr []interface{}, conn *client, store *dictionary) {
+	key := arr[1].(string)
+
+	val, _, err := getString(store, key)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	suffix := arr[2].(string)
+	if len(val)+len(suffix) > maxBulkLength {
+		sendErrorToClient(conn, errStringTooBig.Error())
+		return
+	}
+	val += suffix
+	setStringKeepTTL(store, key, val)
+
+	msg, _, _ := serializeInteger(int64(len(val)))
+	sendMsgToClient(conn, msg)
+}
+
+func handleStrLen(arr []interface{}, conn *client, store *dictionary) {
+	val, _, err := getString(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	msg, _, _ := serializeInteger(int64(len(val)))
+	sendMsgToClient(conn, msg)
+}
+
+// handleGetRange also serves SUBSTR, its deprecated name.
+func handleGetRange(arr []interface{}, conn *client, store *dictionary) {
+	start, err := parseInteger(arr[2])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	end, err := parseInteger(arr[3])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	val, _, err := getString(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	length := int64(len(val))
+	if start < 0 && end < 0 && start > end {
+		sendMsgToClient(conn, serializeBulkString(""))
+		return
+	}
+	if start < 0 {
+		start = max(start+length, 0)
+	}
+	if end < 0 {
+		end = max(end+length, 0)
+	}
+	end = min(end, length-1)
+	if length == 0 || start > end {
+		sendMsgToClient(conn, serializeBulkString(""))
+		return
+	}
+	sendMsgToClient(conn, serializeBulkString(val[start:end+1]))
+}
+
+func handleSetRange(arr []interface{}, conn *client, store *dictionary) {
+	key := arr[1].(string)
+	offset, err := parseInteger(arr[2])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if offset < 0 {
+		sendErrorToClient(conn, "ERR offset is out of range")
+		return
+	}
+	patch := arr[3].(string)
+
+	val, ok, err := getString(store, key)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	// An empty patch never creates or grows the key.
+	if len(patch) == 0 {
+		msg, _, _ := serializeInteger(int64(len(val)))
+		sendMsgToClient(conn, msg)
+		return
+	}
+	// Subtracting, as adding to a huge offset would overflow.
+	if offset > maxBulkLength-int64(len(patch)) {
+		sendErrorToClient(conn, errStringTooBig.Error())
+		return
+	}
+
+	buf := []byte(val)
+	if end := int(offset) + len(patch); end > len(buf) {
+		buf = append(buf, make([]byte, end-len(buf))...)
+	}
+	copy(buf[offset:], patch)
+	if ok {
+		setStringKeepTTL(store, key, string(buf))
+	} else {
+		setRecord(store, key, record{value: string(buf), expiryTimestamp: -1})
+	}
+
+	msg, _, _ := serializeInteger(int64(len(buf)))
+	sendMsgToClient(conn, msg)
+}
+
+func handleMGet(arr []interface{}, conn *client, store *dictionary) {
+	items := make([]string, 0, len(arr)-1)
+	for _, key := range arr[1:] {
+		// Keys holding other types read as missing.
+		val, ok, err := getString(store, key.(string))
+		if !ok || err != nil {
+			items = append(items, conn.serializeNull())
+			continue
+		}
+		items = append(items, serializeBulkString(val))
+	}
+	sendMsgToClient(conn, serializeArray(items))
+}
+
+func handleMSet(arr []interface{}, conn *client, store *dictionary) {
+	if len(arr)%2 != 1 {
+		sendArityError(conn, strings.ToLower(arr[0].(string)))
+		return
+	}
+
+	for i := 1; i < len(arr); i += 2 {
+		setRecord(store, arr[i].(string), record{value: arr[i+1].(string), expiryTimestamp: -1})
+	}
+
+	msg, _ := serializeSimpleString("OK")
+	sendMsgToClient(conn, msg)
+}
+
+func handleMSetNX(arr []interface{}, conn *client, store *dictionary) {
+	if len(arr)%2 != 1 {
+		sendArityError(conn, strings.ToLower(arr[0].(string)))
+		return
+	}
+
+	// Either all keys are set or none.
+	for i := 1; i < len(arr); i += 2 {
+		if _, ok := lookupRecord(store, arr[i].(string)); ok {
+			msg, _, _ := serializeInteger(0)
+			sendMsgToClient(conn, msg)
+			return
+		}
+	}
+	for i := 1; i < len(arr); i += 2 {
+		setRecord(store, arr[i].(string), record{value: arr[i+1].(string), expiryTimestamp: -1})
+	}
+
+	msg, _, _ := serializeInteger(1)
+	sendMsgToClient(conn, msg)
+}
+
+func handleIncrBy(arr []interface{}, conn *client, store *dictionary) {
+	incr, err := parseInteger(arr[2])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	incrDecrGeneric(arr, conn, store, incr)
+}
+
+func handleDecrBy(arr []interface{}, conn *client, store *dictionary) {
+	decr, err := parseInteger(arr[2])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if decr == math.MinInt64 {
+		sendErrorToClient(conn, "ERR decrement would overflow")
+		return
+	}
+	incrDecrGeneric(arr, conn, store, -decr)
+}
+
+// incrDecrGeneric implements INCR, DECR, INCRBY and DECRBY by adding incr to
+// the integer stored at arr[1], which keeps its expiry.
+func incrDecrGeneric(arr []interface{}, conn *client, store *dictionary, incr int64) {
+	key := arr[1].(string)
+
+	val, ok, err := getString(store, key)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	num := int64(0)
+	if ok {
+		num, err = strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			sendErrorToClient(conn, errNotInteger.Error())
+			return
+		}
+	}
+	if (incr > 0 && num > math.MaxInt64-incr) || (incr < 0 && num < math.MinInt64-incr) {
+		sendErrorToClient(conn, "ERR increment or decrement would overflow")
+		return
+	}
+	num += incr
+	setStringKeepTTL(store, key, strconv.FormatInt(num, 10))
+
+	msg, _, _ := serializeInteger(num)
+	sendMsgToClient(conn, msg)
+}
+
+func handleIncrByFloat(arr []interface{}, conn *client, store *dictionary) {
+	key := arr[1].(string)
+	incr, err := parseFloat(arr[2])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	val, ok, err := getString(store, key)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	num := 0.0
+	if ok {
+		num, err = strconv.ParseFloat(val, 64)
+		if err != nil || math.IsNaN(num) {
+			sendErrorToClient(conn, errNotFloat.Error())
+			return
+		}
+	}
+	num += incr
+	if math.IsNaN(num) || math.IsInf(num, 0) {
+		sendErrorToClient(conn, "ERR increment would produce NaN or Infinity")
+		return
+	}
+	val = strconv.FormatFloat(num, 'f', -1, 64)
+	setStringKeepTTL(store, key, val)
+
+	sendMsgToClient(conn, serializeBulkString(val))
+}
+
+func handleGetSet(arr []interface{}, conn *client, store *dictionary) {
+	key := arr[1].(string)
+
+	old, ok, err := getString(store, key)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	setRecord(store, key, record{value: arr[2].(string), expiryTimestamp: -1})
+
+	if !ok {
+		sendMsgToClient(conn, conn.serializeNull())
+		return
+	}
+	sendMsgToClient(conn, serializeBulkString(old))
+}
+
+func handleGetDel(arr []interface{}, conn *client, store *dictionary) {
+	key := arr[1].(string)
+
+	val, ok, err := getString(store, key)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if !ok {
+		sendMsgToClient(conn, conn.serializeNull())
+		return
+	}
+	deleteKey(store, key)
+	sendMsgToClient(conn, serializeBulkString(val))
+}
+
+func handleGetEx(arr []interface{}, conn *client, store *dictionary) {
+	key := arr[1].(string)
+
+	// Zero leaves the expiry alone, -1 removes it.
+	var expiryTimestamp int64
+	switch opts := arr[2:]; {
+	case len(opts) == 0:
+	case len(opts) == 1 && strings.ToLower(opts[0].(string)) == "persist":
+		expiryTimestamp = -1
+	case len(opts) == 2:
+		switch strings.ToLower(opts[0].(string)) {
+		case "ex", "px", "exat", "pxat":
+		default:
+			sendErrorToClient(conn, "ERR syntax error")
+			return
+		}
+		var err error
+		expiryTimestamp, err = parseExpiryTimestamp(arr[0].(string), opts[0].(string), opts[1].(string))
+		if err != nil {
+			sendErrorToClient(conn, err.Error())
+			return
+		}
+	default:
+		sendErrorToClient(conn, "ERR syntax error")
+		return
+	}
+
+	val, ok, err := getString(store, key)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if !ok {
+		sendMsgToClient(conn, conn.serializeNull())
+		return
+	}
+	switch expiryTimestamp {
+	case 0:
+		rewriteCommand(conn)
+	case -1:
+		setRecord(store, key, record{value: val, expiryTimestamp: -1})
+		rewriteCommand(conn, []string{"persist", key})
+	default:
+		setRecord(store, key, record{value: val, expiryTimestamp: expiryTimestamp})
+		rewriteCommand(conn, []string{"pexpireat", key, strconv.FormatInt(expiryTimestamp, 10)})
+	}
+	sendMsgToClient(conn, serializeBulkString(val))
+}
+
+func handleSetNX(arr []interface{}, conn *client, store *dictionary) {
+	key := arr[1].(string)
+
+	if _, ok := lookupRecord(store, key); ok {
+		msg, _, _ := serializeInteger(0)
+		sendMsgToClient(conn, msg)
+		return
+	}
+	setRecord(store, key, record{value: arr[2].(string), expiryTimestamp: -1})
+
+	msg, _, _ := serializeInteger(1)
+	sendMsgToClient(conn, msg)
+}
+
+func handleSetEx(arr []interface{}, conn *client, store *dictionary) {
+	setExpiringGeneric(arr, conn, store, "ex")
+}
+
+func handlePSetEx(arr []interface{}, conn *client, store *dictionary) {
+	setExpiringGeneric(arr, conn, store, "px")
+}
+
+// setExpiringGeneric implements SETEX and PSETEX, which take "key ttl value".
+func setExpiringGeneric(arr []interface{}, conn *client, store *dictionary, unit string) {
+	expiryTimestamp, err := parseExpiryTimestamp(arr[0].(string), unit, arr[2].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	setRecord(store, arr[1].(string), record{value: arr[3].(string), expiryTimestamp: expiryTimestamp})
+	rewriteCommand(conn, []string{"set", arr[1].(string), arr[3].(string), "pxat", strconv.FormatInt(expiryTimestamp, 10)})
+
+	msg, _ := serializeSimpleString("OK")
+	sendMsgToClient(conn, msg)
+}
+
+func handleLCS(arr []interface{}, conn *client, store *dictionary) {
+	var getLen, getIdx, withMatchLen bool
+	minMatchLen := int64(0)
+	for i := 3; i < len(arr); i++ {
+		opt := strings.ToLower(arr[i].(string))
+		switch {
+		case opt == "len":
+			getLen = true
+		case opt == "idx":
+			getIdx = true
+		case opt == "withmatchlen":
+			withMatchLen = true
+		case opt == "minmatchlen" && i+1 < len(arr):
+			var err error
+			minMatchLen, err = parseInteger(arr[i+1])
+			if err != nil {
+				sendErrorToClient(conn, err.Error())
+				return
+			}
+			minMatchLen = max(minMatchLen, 0)
+			i++
+		default:
+			sendErrorToClient(conn, "ERR syntax error")
+			return
+		}
+	}
+	if getLen && getIdx {
+		sendErrorToClient(conn, "ERR If you want both the length and indexes, please just use IDX.")
+		return
+	}
+
+	a, _, errA := getString(store, arr[1].(string))
+	b, _, errB := getString(store, arr[2].(string))
+	if errA != nil || errB != nil {
+		sendErrorToClient(conn, "ERR The specified keys must contain string values")
+		return
+	}
+	if int64(len(a)+1)*int64(len(b)+1)*4 > maxBulkLength {
+		sendErrorToClient(conn, "ERR Insufficient memory, transient memory for LCS exceeds proto-max-bulk-len")
+		return
+	}
+
+	// lcs[i][j] is the length of the LCS of a[:i] and b[:j].
+	cols := len(b) + 1
+	lcs := make([]uint32, (len(a)+1)*cols)
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				lcs[i*cols+j] = lcs[(i-1)*cols+j-1] + 1
+			} else {
+				lcs[i*cols+j] = max(lcs[(i-1)*cols+j], lcs[i*cols+j-1])
+			}
+		}
+	}
+	length := int(lcs[len(a)*cols+len(b)])
+
+	if getLen {
+		msg, _, _ := serializeInteger(int64(length))
+		sendMsgToClient(conn, msg)
+		return
+	}
+
+	// Walk back from the end of both strings, collecting the common string and
+	// the ranges where both strings match.
+	result := make([]byte, length)
+	var matches []string
+	idx := length
+	aStart, aEnd, bStart, bEnd := -1, -1, -1, -1
+	for i, j := len(a), len(b); i > 0 && j > 0; {
+		emit := false
+		if a[i-1] == b[j-1] {
+			result[idx-1] = a[i-1]
+			if aStart == -1 {
+				aStart, aEnd, bStart, bEnd = i-1, i-1, j-1, j-1
+			} else {
+				// Extend the range backwards, the match is contiguous.
+				aStart--
+				bStart--
+			}
+			if aStart == 0 || bStart == 0 {
+				emit = true
+			}
+			idx--
+			i--
+			j--
+		} else {
+			if lcs[(i-1)*cols+j] > lcs[i*cols+j-1] {
+				i--
+			} else {
+				j--
+			}
+			if aStart != -1 {
+				emit = true
+			}
+		}
+
+		if emit {
+			matchLen := aEnd - aStart + 1
+			if getIdx && int64(matchLen) >= minMatchLen {
+				match := []string{serializeLCSRange(aStart, aEnd), serializeLCSRange(bStart, bEnd)}
+				if withMatchLen {
+					msg, _, _ := serializeInteger(int64(matchLen))
+					match = append(match, msg)
+				}
+				matches = append(matches, serializeArray(match))
+			}
+			aStart = -1
+		}
+	}
+
+	if getIdx {
+		lenMsg, _, _ := serializeInteger(int64(length))
+		sendMsgToClient(conn, conn.serializeMap([]string{
+			serializeBulkString("matches"), serializeArray(matches),
+			serializeBulkString("len"), lenMsg,
+		}))
+		return
+	}
+	sendMsgToClient(conn, serializeBulkString(string(result)))
+}
+
+func serializeLCSRange(start int, end int) string {
+	startMsg, _, _ := serializeInteger(int64(start))
+	endMsg, _, _ := serializeInteger(int64(end))
+	return serializeArray([]string{startMsg, endMsg})
+}
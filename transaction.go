@@ -0,0 +1,187 @@
+package main
+
+import "fmt"
+
+// transaction is the state of a client between MULTI and EXEC.
+type transaction struct {
+	queued []queuedCommand
+	// Set when a command failed to queue, EXEC then discards the transaction.
+	aborted bool
+}
+
+type queuedCommand struct {
+	spec *commandSpec
+	arr  []interface{}
+}
+
+// Commands that run right away inside MULTI instead of being queued.
+var multiImmediateCommands = map[string]bool{
+	"multi":   true,
+	"exec":    true,
+	"discard": true,
+	"watch":   true,
+}
+
+// watchedKey counts the writes to a key that clients are watching, so EXEC
+// can tell whether it changed since WATCH.
+type watchedKey struct {
+	version uint64
+	// Number of clients watching the key.
+	watchers int
+}
+
+// watch is a key watched by a client, with the version it had at WATCH time.
+type watch struct {
+	store   *dictionary
+	key     string
+	version uint64
+}
+
+// flagTransaction makes EXEC fail when a command couldn't be queued.
+func (c *client) flagTransaction() {
+	if c.multi != nil {
+		c.multi.aborted = true
+	}
+}
+
+// touchWatchedKey marks key as modified for the clients watching it.
+// Must be called with store.mu held.
+func touchWatchedKey(store *dictionary, key string) {
+	if w, ok := store.watched[key]; ok {
+		w.version++
+	}
+}
+
+// touchAllWatchedKeys marks every watched key of the database as modified.
+// Must be called with store.mu held.
+func touchAllWatchedKeys(store *dictionary) {
+	for _, w := range store.watched {
+		w.version++
+	}
+}
+
+// unwatchAllKeys forgets all keys the client watches.
+// Must be called with store.mu held.
+func unwatchAllKeys(conn *client) {
+	for _, w := range conn.watching {
+		wk := w.store.watched[w.key]
+		wk.watchers--
+		if wk.watchers == 0 {
+			delete(w.store.watched, w.key)
+		}
+	}
+	conn.watching = nil
+}
+
+// watchedKeysChanged reports whether a key the client watches was written
+// since WATCH, expired keys included.
+// Must be called with store.mu held.
+func watchedKeysChanged(conn *client) bool {
+	for _, w := range conn.watching {
+		// Deleting a key that expired in the meantime touches it.
+		lookupRecord(w.store, w.key)
+		if w.store.watched[w.key].version != w.version {
+			return true
+		}
+	}
+	return false
+}
+
+func handleMulti(arr []interface{}, conn *client, store *dictionary) {
+	if conn.multi != nil {
+		sendErrorToClient(conn, "ERR MULTI calls can not be nested")
+		return
+	}
+	conn.multi = &transaction{}
+
+	msg, _ := serializeSimpleString("OK")
+	sendMsgToClient(conn, msg)
+}
+
+func handleDiscard(arr []interface{}, conn *client, store *dictionary) {
+	if conn.multi == nil {
+		sendErrorToClient(conn, "ERR DISCARD without MULTI")
+		return
+	}
+	conn.multi = nil
+	unwatchAllKeys(conn)
+
+	msg, _ := serializeSimpleString("OK")
+	sendMsgToClient(conn, msg)
+}
+
+// handleExec runs the queued commands back to back. The dispatcher holds
+// store.mu all along, so no other client sees the transaction half done.
+func handleExec(arr []interface{}, conn *client, store *dictionary) {
+	tx := conn.multi
+	if tx == nil {
+		sendErrorToClient(conn, "ERR EXEC without MULTI")
+		return
+	}
+	conn.multi = nil
+
+	if tx.aborted {
+		unwatchAllKeys(conn)
+		sendErrorToClient(conn, "EXECABORT Transaction discarded because of previous errors.")
+		return
+	}
+	if watchedKeysChanged(conn) {
+		unwatchAllKeys(conn)
+		sendMsgToClient(conn, conn.serializeNullArray())
+		return
+	}
+	unwatchAllKeys(conn)
+
+	// The replies of the commands follow the array header as they are
+	// written.
+	sendMsgToClient(conn, fmt.Sprintf("*%d\r\n", len(tx.queued)))
+	conn.denyBlocking = true
+	beginLoggedExec(store.srv)
+	for _, cmd := range tx.queued {
+		call(cmd.spec, cmd.arr, conn)
+	}
+	endLoggedExec(store.srv)
+	conn.denyBlocking = false
+}
+
+func handleWatch(arr []interface{}, conn *client, store *dictionary) {
+	if conn.multi != nil {
+		sendErrorToClient(conn, "ERR WATCH inside MULTI is not allowed")
+		return
+	}
+	for _, arg := range arr[1:] {
+		key := arg.(string)
+		if conn.isWatching(store, key) {
+			continue
+		}
+		// Delete the key first if it expired, so that doesn't count as a
+		// change later.
+		lookupRecord(store, key)
+		wk, ok := store.watched[key]
+		if !ok {
+			wk = &watchedKey{}
+			store.watched[key] = wk
+		}
+		wk.watchers++
+		conn.watching = append(conn.watching, watch{store: store, key: key, version: wk.version})
+	}
+
+	msg, _ := serializeSimpleString("OK")
+	sendMsgToClient(conn, msg)
+}
+
+func (c *client) isWatching(store *dictionary, key string) bool {
+	for _, w := range c.watching {
+		if w.store == store && w.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func handleUnwatch(arr []interface{}, conn *client, store *dictionary) {
+	unwatchAllKeys(conn)
+
+	msg, _ := serializeSimpleString("OK")
+	sendMsgToClient(conn, msg)
+}
@@ -1,6 +1,12 @@
 package main
 
-import "sync"
+import (
+	"math/rand"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
 
 type node struct {
 	value string
@@ -14,19 +20,499 @@ type linkedList struct {
 	tail   *node
 }
 
+// hash maps fields to values. The fields are kept in a keyTable as well, which
+// HSCAN iterates and HRANDFIELD picks from.
+type hash struct {
+	fields map[string]string
+	table  *keyTable
+}
+
+func newHash() *hash {
+	return &hash{fields: map[string]string{}, table: newKeyTable()}
+}
+
+func (h *hash) size() int {
+	return len(h.fields)
+}
+
+// set sets field to val and reports whether field was added.
+func (h *hash) set(field, val string) bool {
+	_, ok := h.fields[field]
+	h.fields[field] = val
+	if !ok {
+		h.table.add(field)
+	}
+	return !ok
+}
+
+// remove deletes field and reports whether it was there.
+func (h *hash) remove(field string) bool {
+	if _, ok := h.fields[field]; !ok {
+		return false
+	}
+	delete(h.fields, field)
+	h.table.remove(field)
+	return true
+}
+
+// random returns a random field of a hash that mustn't be empty.
+func (h *hash) random() string {
+	field, _ := h.table.random()
+	return field
+}
+
+// list returns all fields in no particular order.
+func (h *hash) list() []string {
+	fields := make([]string, 0, len(h.fields))
+	for field := range h.fields {
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func (h *hash) clone() *hash {
+	c := newHash()
+	for field, val := range h.fields {
+		c.set(field, val)
+	}
+	return c
+}
+
 type record struct {
-	value           interface{} // string or LinkedList
+	value           interface{} // string, linkedList, *hash, *set or *sortedSet
 	expiryTimestamp int64
+	// Estimated bytes of memory, counted against maxmemory.
+	size int64
+	// The lruClock of the last access, and the logarithmic access counter
+	// of the LFU policies, see evict.go.
+	lru uint32
+	lfu uint8
 }
 
+// typeName returns the name TYPE reports for a record value.
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case linkedList:
+		return "list"
+	case *hash:
+		return "hash"
+	case *set:
+		return "set"
+	case *sortedSet:
+		return "zset"
+	}
+	return "none"
+}
+
+// dictionary is one logical database.
 type dictionary struct {
-	mu   sync.Mutex
+	// Shared by all databases of a server, see newServer.
+	mu *sync.Mutex
+	// The server the database belongs to, and its index there.
+	srv  *server
+	id   int
 	dict map[string]record
+	// The keys of dict again, in the order SCAN visits them.
+	keys *keyTable
+	// Volatile keys by expiry time.
+	expires     *expiryIndex
+	expireStats expireStats
+	// The sum of the estimated sizes of the records.
+	usedMemory int64
+	// Clients waiting in blocking list commands, per key.
+	blocked map[string][]*listWaiter
+	// Keys clients are watching with WATCH.
+	watched map[string]*watchedKey
 }
 
+// newStore returns a database with a lock of its own, not part of a server.
 func newStore() *dictionary {
 	store := &dictionary{
-		dict: map[string]record{},
+		mu:      &sync.Mutex{},
+		dict:    map[string]record{},
+		keys:    newKeyTable(),
+		expires: newExpiryIndex(),
+		blocked: map[string][]*listWaiter{},
+		watched: map[string]*watchedKey{},
 	}
 	return store
 }
+
+// flush deletes every key.
+// Must be called with store.mu held.
+func (store *dictionary) flush() {
+	touchAllWatchedKeys(store)
+	if store.srv != nil {
+		store.srv.persistence.dirty += int64(len(store.dict))
+	}
+	store.dict = map[string]record{}
+	store.keys = newKeyTable()
+	store.expires = newExpiryIndex()
+	store.usedMemory = 0
+}
+
+// server holds the logical databases. They share one lock, so commands that
+// touch several databases, like MOVE and SWAPDB, are atomic as well.
+type server struct {
+	mu          sync.Mutex
+	cfg         config
+	dbs         []*dictionary
+	pubsub      *pubsub
+	persistence persistence
+	aof         appendOnlyFile
+	// Set while the append-only file is replayed at startup.
+	loading bool
+	// Keys deleted to stay below maxmemory.
+	evictedKeys int64
+}
+
+func newServer(cfg config) *server {
+	srv := &server{
+		cfg:         cfg,
+		dbs:         make([]*dictionary, cfg.databases),
+		pubsub:      newPubsub(),
+		persistence: persistence{lastSave: time.Now(), lastBgsaveOK: true},
+		aof:         appendOnlyFile{db: -1, lastRewriteOK: true},
+	}
+	for i := range srv.dbs {
+		store := newStore()
+		store.mu = &srv.mu
+		store.srv = srv
+		store.id = i
+		srv.dbs[i] = store
+	}
+	return srv
+}
+
+func (ll *linkedList) pushFront(val string) {
+	n := &node{value: val, next: ll.head}
+	if ll.head != nil {
+		ll.head.prev = n
+	} else {
+		ll.tail = n
+	}
+	ll.head = n
+	ll.length++
+}
+
+func (ll *linkedList) pushBack(val string) {
+	n := &node{value: val, prev: ll.tail}
+	if ll.tail != nil {
+		ll.tail.next = n
+	} else {
+		ll.head = n
+	}
+	ll.tail = n
+	ll.length++
+}
+
+// popFront removes the first element, the list must not be empty.
+func (ll *linkedList) popFront() string {
+	n := ll.head
+	ll.remove(n)
+	return n.value
+}
+
+// popBack removes the last element, the list must not be empty.
+func (ll *linkedList) popBack() string {
+	n := ll.tail
+	ll.remove(n)
+	return n.value
+}
+
+// insertBefore inserts val in front of mark, which must belong to the list.
+func (ll *linkedList) insertBefore(mark *node, val string) {
+	if mark.prev == nil {
+		ll.pushFront(val)
+		return
+	}
+	n := &node{value: val, prev: mark.prev, next: mark}
+	mark.prev.next = n
+	mark.prev = n
+	ll.length++
+}
+
+// insertAfter inserts val behind mark, which must belong to the list.
+func (ll *linkedList) insertAfter(mark *node, val string) {
+	if mark.next == nil {
+		ll.pushBack(val)
+		return
+	}
+	n := &node{value: val, prev: mark, next: mark.next}
+	mark.next.prev = n
+	mark.next = n
+	ll.length++
+}
+
+func (ll *linkedList) remove(n *node) {
+	if n.prev != nil {
+		n.prev.next = n.next
+	} else {
+		ll.head = n.next
+	}
+	if n.next != nil {
+		n.next.prev = n.prev
+	} else {
+		ll.tail = n.prev
+	}
+	n.prev, n.next = nil, nil
+	ll.length--
+}
+
+// nodeAt returns the node at index, negative indexes count from the tail.
+// Returns nil when the index is out of range.
+func (ll *linkedList) nodeAt(index int) *node {
+	if index < 0 {
+		index += int(ll.length)
+	}
+	if index < 0 || index >= int(ll.length) {
+		return nil
+	}
+
+	// Walk from whichever end is closer.
+	if index < int(ll.length)/2 {
+		n := ll.head
+		for ; index > 0; index-- {
+			n = n.next
+		}
+		return n
+	}
+	n := ll.tail
+	for i := int(ll.length) - 1; i > index; i-- {
+		n = n.prev
+	}
+	return n
+}
+
+// clone returns a copy of the list with nodes of its own. Copying the
+// linkedList struct alone would share them.
+func (ll *linkedList) clone() linkedList {
+	var c linkedList
+	for n := ll.head; n != nil; n = n.next {
+		c.pushBack(n.value)
+	}
+	return c
+}
+
+// Sets with more integer members than this are converted to a map, the same
+// threshold as Redis's set-max-intset-entries.
+const maxIntsetEntries = 512
+
+// set is an unordered collection of unique strings. As long as every member is
+// an integer it is stored compactly as a sorted slice of integers, like the
+// Redis intset encoding.
+type set struct {
+	// Used while members is nil.
+	ints    []int64
+	members map[string]struct{}
+	// The members again once they are in the map, which SSCAN iterates and
+	// SPOP and SRANDMEMBER pick from.
+	table *keyTable
+}
+
+func newSet() *set {
+	return &set{}
+}
+
+// parseSetInt reports whether member is an integer in canonical form, which is
+// what allows it to be stored in the integer encoding.
+func parseSetInt(member string) (int64, bool) {
+	num, err := strconv.ParseInt(member, 10, 64)
+	if err != nil || strconv.FormatInt(num, 10) != member {
+		return 0, false
+	}
+	return num, true
+}
+
+// isIntset reports whether the set still uses the integer encoding.
+func (s *set) isIntset() bool {
+	return s.members == nil
+}
+
+func (s *set) size() int {
+	if s.isIntset() {
+		return len(s.ints)
+	}
+	return len(s.members)
+}
+
+func (s *set) contains(member string) bool {
+	if s.isIntset() {
+		num, ok := parseSetInt(member)
+		if !ok {
+			return false
+		}
+		_, found := slices.BinarySearch(s.ints, num)
+		return found
+	}
+	_, ok := s.members[member]
+	return ok
+}
+
+// add inserts member and reports whether it wasn't there yet.
+func (s *set) add(member string) bool {
+	if s.isIntset() {
+		if num, ok := parseSetInt(member); ok {
+			i, found := slices.BinarySearch(s.ints, num)
+			if found {
+				return false
+			}
+			if len(s.ints) < maxIntsetEntries {
+				s.ints = slices.Insert(s.ints, i, num)
+				return true
+			}
+		}
+		s.convertToMap()
+	}
+	if _, ok := s.members[member]; ok {
+		return false
+	}
+	s.members[member] = struct{}{}
+	s.table.add(member)
+	return true
+}
+
+// remove deletes member and reports whether it was there.
+func (s *set) remove(member string) bool {
+	if s.isIntset() {
+		num, ok := parseSetInt(member)
+		if !ok {
+			return false
+		}
+		i, found := slices.BinarySearch(s.ints, num)
+		if found {
+			s.ints = slices.Delete(s.ints, i, i+1)
+		}
+		return found
+	}
+	if _, ok := s.members[member]; !ok {
+		return false
+	}
+	delete(s.members, member)
+	s.table.remove(member)
+	return true
+}
+
+// list returns all members. Integer sets are returned in ascending order.
+func (s *set) list() []string {
+	members := make([]string, 0, s.size())
+	if s.isIntset() {
+		for _, num := range s.ints {
+			members = append(members, strconv.FormatInt(num, 10))
+		}
+		return members
+	}
+	for member := range s.members {
+		members = append(members, member)
+	}
+	return members
+}
+
+// random returns a random member of a set that mustn't be empty.
+func (s *set) random() string {
+	if s.isIntset() {
+		return strconv.FormatInt(s.ints[rand.Intn(len(s.ints))], 10)
+	}
+	member, _ := s.table.random()
+	return member
+}
+
+func (s *set) convertToMap() {
+	s.members = make(map[string]struct{}, len(s.ints)+1)
+	s.table = newKeyTable()
+	for _, num := range s.ints {
+		member := strconv.FormatInt(num, 10)
+		s.members[member] = struct{}{}
+		s.table.add(member)
+	}
+	s.ints = nil
+}
+
+func (s *set) clone() *set {
+	if s.isIntset() {
+		return &set{ints: slices.Clone(s.ints)}
+	}
+	c := &set{members: make(map[string]struct{}, len(s.members)), table: newKeyTable()}
+	for member := range s.members {
+		c.members[member] = struct{}{}
+		c.table.add(member)
+	}
+	return c
+}
+
+// sortedSet orders unique members by score. The skiplist serves ranges and
+// ranks, while the map gives constant time score lookups. ZSCAN iterates the
+// members in the keyTable.
+type sortedSet struct {
+	dict  map[string]float64
+	zsl   *skiplist
+	table *keyTable
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{dict: map[string]float64{}, zsl: newSkiplist(), table: newKeyTable()}
+}
+
+func (z *sortedSet) size() int {
+	return len(z.dict)
+}
+
+func (z *sortedSet) score(member string) (float64, bool) {
+	score, ok := z.dict[member]
+	return score, ok
+}
+
+// set adds member with score, or moves it to score if it already exists.
+// Reports whether member was added.
+func (z *sortedSet) set(member string, score float64) bool {
+	cur, ok := z.dict[member]
+	if !ok {
+		z.dict[member] = score
+		z.zsl.insert(score, member)
+		z.table.add(member)
+		return true
+	}
+	if cur != score {
+		z.zsl.updateScore(cur, member, score)
+		z.dict[member] = score
+	}
+	return false
+}
+
+// remove deletes member and reports whether it was there.
+func (z *sortedSet) remove(member string) bool {
+	score, ok := z.dict[member]
+	if !ok {
+		return false
+	}
+	delete(z.dict, member)
+	z.zsl.delete(score, member)
+	z.table.remove(member)
+	return true
+}
+
+func (z *sortedSet) clone() *sortedSet {
+	c := newSortedSet()
+	for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		c.set(x.member, x.score)
+	}
+	return c
+}
+
+// cloneValue returns a deep copy of a record value.
+func cloneValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case linkedList:
+		return v.clone()
+	case *hash:
+		return v.clone()
+	case *set:
+		return v.clone()
+	case *sortedSet:
+		return v.clone()
+	}
+	// Strings are immutable.
+	return value
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func listValues(ll *linkedList) []string {
+	var values []string
+	for n := ll.head; n != nil; n = n.next {
+		values = append(values, n.value)
+	}
+	// Walk back as well to make sure the prev pointers agree.
+	i := len(values) - 1
+	for n := ll.tail; n != nil; n = n.prev {
+		if i < 0 || n.value != values[i] {
+			return nil
+		}
+		i--
+	}
+	return values
+}
+
+func TestLinkedList(t *testing.T) {
+	ll := linkedList{}
+	ll.pushBack("b")
+	ll.pushFront("a")
+	ll.pushBack("d")
+	ll.insertBefore(ll.nodeAt(-1), "c")
+	ll.insertAfter(ll.nodeAt(-1), "e")
+
+	if got := listValues(&ll); len(got) != 5 || got[0] != "a" || got[2] != "c" || got[4] != "e" {
+		t.Fatalf("Got '%v' but expected '[a b c d e]'.", got)
+	}
+	if ll.length != 5 {
+		t.Errorf("Got length '%d' but expected '5'.", ll.length)
+	}
+
+	if val := ll.popFront(); val != "a" {
+		t.Errorf("Got '%s' but expected 'a'.", val)
+	}
+	if val := ll.popBack(); val != "e" {
+		t.Errorf("Got '%s' but expected 'e'.", val)
+	}
+	ll.remove(ll.nodeAt(1))
+	if got := listValues(&ll); len(got) != 2 || got[0] != "b" || got[1] != "d" {
+		t.Fatalf("Got '%v' but expected '[b d]'.", got)
+	}
+
+	ll.popFront()
+	ll.popFront()
+	if ll.head != nil || ll.tail != nil || ll.length != 0 {
+		t.Errorf("Expected an empty list.")
+	}
+}
+
+func TestNodeAt(t *testing.T) {
+	ll := linkedList{}
+	for _, val := range []string{"a", "b", "c", "d", "e"} {
+		ll.pushBack(val)
+	}
+
+	var tests = []struct {
+		name  string
+		index int
+		want  string
+	}{
+		// the table itself
+		{"Should find the head", 0, "a"},
+		{"Should find a node close to the tail", 3, "d"},
+		{"Should find the tail by negative index", -1, "e"},
+		{"Should find a node by negative index", -5, "a"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n := ll.nodeAt(test.index)
+			if n == nil || n.value != test.want {
+				t.Errorf("Got '%v' but expected '%s'.", n, test.want)
+			}
+		})
+	}
+
+	if ll.nodeAt(5) != nil || ll.nodeAt(-6) != nil {
+		t.Errorf("Expected nil for out of range indexes.")
+	}
+}
+
+func TestSetIntsetEncoding(t *testing.T) {
+	s := newSet()
+	for _, member := range []string{"3", "1", "2", "1"} {
+		s.add(member)
+	}
+	if !s.isIntset() {
+		t.Fatalf("Expected integer members to use the intset encoding.")
+	}
+	if got := s.list(); len(got) != 3 || got[0] != "1" || got[2] != "3" {
+		t.Errorf("Got '%v' but expected '[1 2 3]'.", got)
+	}
+
+	// Non-canonical integers must keep their exact spelling.
+	if s.contains("01") {
+		t.Errorf("Expected '01' not to match the integer 1.")
+	}
+	s.add("01")
+	if s.isIntset() {
+		t.Errorf("Expected a non-canonical integer to convert the set.")
+	}
+	if !s.contains("1") || !s.contains("01") || s.size() != 4 {
+		t.Errorf("Expected all members to survive the conversion but got '%v'.", s.list())
+	}
+}
+
+func TestSetIntsetLimit(t *testing.T) {
+	s := newSet()
+	for i := 0; i <= maxIntsetEntries; i++ {
+		s.add(strconv.Itoa(i))
+	}
+	if s.isIntset() {
+		t.Errorf("Expected the set to convert after '%d' entries.", maxIntsetEntries)
+	}
+	if s.size() != maxIntsetEntries+1 {
+		t.Errorf("Got size '%d' but expected '%d'.", s.size(), maxIntsetEntries+1)
+	}
+	if !s.remove("0") || s.remove("0") {
+		t.Errorf("Expected remove to report whether the member existed.")
+	}
+}
+
+func TestCloneValue(t *testing.T) {
+	ll := linkedList{}
+	ll.pushBack("a")
+	ll.pushBack("b")
+	llCopy := cloneValue(ll).(linkedList)
+	llCopy.pushBack("c")
+	llCopy.head.value = "z"
+	if got := listValues(&ll); len(got) != 2 || got[0] != "a" {
+		t.Errorf("Got '%v' but expected '[a b]'.", got)
+	}
+
+	s := newSet()
+	s.add("1")
+	sCopy := cloneValue(s).(*set)
+	sCopy.add("x")
+	if s.size() != 1 || !s.isIntset() {
+		t.Errorf("Expected the original set to be unchanged.")
+	}
+
+	z := newSortedSet()
+	z.set("a", 1)
+	zCopy := cloneValue(z).(*sortedSet)
+	zCopy.set("a", 5)
+	if score, _ := z.score("a"); score != 1 || zCopy.zsl.length != 1 {
+		t.Errorf("Got score '%g' but expected '1'.", score)
+	}
+
+	h := newHash()
+	h.set("f", "v")
+	cloneValue(h).(*hash).set("f", "changed")
+	if h.fields["f"] != "v" {
+		t.Errorf("Got '%s' but expected 'v'.", h.fields["f"])
+	}
+}
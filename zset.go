@@ -0,0 +1,852 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// getSortedSet returns the sorted set stored at key. ok is false when the key
+// doesn't exist, in which case the returned sorted set is nil.
+// Must be called with store.mu held.
+func getSortedSet(store *dictionary, key string) (z *sortedSet, ok bool, err error) {
+	return lookupValue[*sortedSet](store, key)
+}
+
+// storeSortedSet replaces whatever is stored at key with z, deleting the key
+// when z is empty.
+// Must be called with store.mu held.
+func storeSortedSet(store *dictionary, key string, z *sortedSet) {
+	if z.size() == 0 {
+		deleteKey(store, key)
+		return
+	}
+	setRecord(store, key, record{value: z, expiryTimestamp: -1})
+}
+
+// serializeScoredMembers replies with the members of nodes, followed by their
+// scores when withScores is set. RESP3 clients get [member, score] pairs.
+func serializeScoredMembers(conn *client, nodes []*skiplistNode, withScores bool) string {
+	items := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		switch {
+		case !withScores:
+			items = append(items, serializeBulkString(n.member))
+		case conn.protocol == 3:
+			items = append(items, serializeArray([]string{serializeBulkString(n.member), serializeDouble(n.score)}))
+		default:
+			items = append(items, serializeBulkString(n.member), serializeBulkString(formatDouble(n.score)))
+		}
+	}
+	return serializeArray(items)
+}
+
+const (
+	zaddNX = 1 << iota
+	zaddXX
+	zaddGT
+	zaddLT
+	zaddCH
+	zaddIncr
+)
+
+func handleZAdd(arr []interface{}, conn *client, store *dictionary) {
+	key := arr[1].(string)
+
+	flags := 0
+	i := 2
+flagLoop:
+	for ; i < len(arr); i++ {
+		switch strings.ToLower(arr[i].(string)) {
+		case "nx":
+			flags |= zaddNX
+		case "xx":
+			flags |= zaddXX
+		case "gt":
+			flags |= zaddGT
+		case "lt":
+			flags |= zaddLT
+		case "ch":
+			flags |= zaddCH
+		case "incr":
+			flags |= zaddIncr
+		default:
+			break flagLoop
+		}
+	}
+
+	pairs := arr[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		sendErrorToClient(conn, "ERR syntax error")
+		return
+	}
+	if flags&zaddNX != 0 && flags&zaddXX != 0 {
+		sendErrorToClient(conn, "ERR XX and NX options at the same time are not compatible")
+		return
+	}
+	if (flags&zaddGT != 0 && flags&zaddNX != 0) || (flags&zaddLT != 0 && flags&zaddNX != 0) ||
+		(flags&zaddGT != 0 && flags&zaddLT != 0) {
+		sendErrorToClient(conn, "ERR GT, LT, and/or NX options at the same time are not compatible")
+		return
+	}
+	if flags&zaddIncr != 0 && len(pairs) > 2 {
+		sendErrorToClient(conn, "ERR INCR option supports a single increment-element pair")
+		return
+	}
+
+	// Parse every score before touching the key, so a bad one changes nothing.
+	scores := make([]float64, len(pairs)/2)
+	for j := range scores {
+		score, err := parseFloat(pairs[2*j])
+		if err != nil {
+			sendErrorToClient(conn, err.Error())
+			return
+		}
+		scores[j] = score
+	}
+
+	z, ok, err := getSortedSet(store, key)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if !ok {
+		if flags&zaddXX != 0 {
+			if flags&zaddIncr != 0 {
+				sendMsgToClient(conn, conn.serializeNull())
+				return
+			}
+			msg, _, _ := serializeInteger(0)
+			sendMsgToClient(conn, msg)
+			return
+		}
+		z = newSortedSet()
+	}
+
+	added, changed := 0, 0
+	var lastScore float64
+	aborted := false
+	for j, score := range scores {
+		member := pairs[2*j+1].(string)
+		cur, exists := z.score(member)
+		if (exists && flags&zaddNX != 0) || (!exists && flags&zaddXX != 0) {
+			aborted = true
+			continue
+		}
+		if exists {
+			if flags&zaddIncr != 0 {
+				score += cur
+				if math.IsNaN(score) {
+					sendErrorToClient(conn, "ERR resulting score is not a number (NaN)")
+					return
+				}
+			}
+			if (flags&zaddGT != 0 && score <= cur) || (flags&zaddLT != 0 && score >= cur) {
+				aborted = true
+				continue
+			}
+			if score != cur {
+				z.set(member, score)
+				changed++
+			}
+		} else {
+			z.set(member, score)
+			added++
+		}
+		lastScore = score
+		aborted = false
+	}
+	if !ok && z.size() > 0 {
+		storeSortedSet(store, key, z)
+	} else if ok && added+changed > 0 {
+		signalModifiedKey(store, key)
+	}
+
+	if flags&zaddIncr != 0 {
+		if aborted {
+			sendMsgToClient(conn, conn.serializeNull())
+			return
+		}
+		sendMsgToClient(conn, conn.serializeDouble(lastScore))
+		return
+	}
+	if flags&zaddCH != 0 {
+		added += changed
+	}
+	msg, _, _ := serializeInteger(int64(added))
+	sendMsgToClient(conn, msg)
+}
+
+func handleZIncrBy(arr []interface{}, conn *client, store *dictionary) {
+	key := arr[1].(string)
+	incr, err := parseFloat(arr[2])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	z, ok, err := getSortedSet(store, key)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if !ok {
+		z = newSortedSet()
+	}
+	member := arr[3].(string)
+	score, _ := z.score(member)
+	score += incr
+	if math.IsNaN(score) {
+		sendErrorToClient(conn, "ERR resulting score is not a number (NaN)")
+		return
+	}
+	z.set(member, score)
+	if !ok {
+		storeSortedSet(store, key, z)
+	} else {
+		signalModifiedKey(store, key)
+	}
+
+	sendMsgToClient(conn, conn.serializeDouble(score))
+}
+
+func handleZRem(arr []interface{}, conn *client, store *dictionary) {
+	key := arr[1].(string)
+
+	z, ok, err := getSortedSet(store, key)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	removed := 0
+	if ok {
+		for _, member := range arr[2:] {
+			if z.remove(member.(string)) {
+				removed++
+			}
+		}
+		if z.size() == 0 {
+			deleteKey(store, key)
+		} else if removed > 0 {
+			signalModifiedKey(store, key)
+		}
+	}
+
+	msg, _, _ := serializeInteger(int64(removed))
+	sendMsgToClient(conn, msg)
+}
+
+func handleZScore(arr []interface{}, conn *client, store *dictionary) {
+	z, ok, err := getSortedSet(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if !ok {
+		sendMsgToClient(conn, conn.serializeNull())
+		return
+	}
+	score, ok := z.score(arr[2].(string))
+	if !ok {
+		sendMsgToClient(conn, conn.serializeNull())
+		return
+	}
+	sendMsgToClient(conn, conn.serializeDouble(score))
+}
+
+func handleZMScore(arr []interface{}, conn *client, store *dictionary) {
+	z, ok, err := getSortedSet(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if !ok {
+		z = newSortedSet()
+	}
+	items := make([]string, 0, len(arr)-2)
+	for _, member := range arr[2:] {
+		if score, ok := z.score(member.(string)); ok {
+			items = append(items, conn.serializeDouble(score))
+		} else {
+			items = append(items, conn.serializeNull())
+		}
+	}
+	sendMsgToClient(conn, serializeArray(items))
+}
+
+func handleZCard(arr []interface{}, conn *client, store *dictionary) {
+	z, ok, err := getSortedSet(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	size := 0
+	if ok {
+		size = z.size()
+	}
+	msg, _, _ := serializeInteger(int64(size))
+	sendMsgToClient(conn, msg)
+}
+
+func handleZCount(arr []interface{}, conn *client, store *dictionary) {
+	r, err := parseScoreRange(arr[2].(string), arr[3].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	z, ok, err := getSortedSet(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	count := 0
+	if ok {
+		// The difference of the ranks of both ends, without walking the range.
+		if first := z.zsl.firstInScoreRange(r); first != nil {
+			last := z.zsl.lastInScoreRange(r)
+			count = z.zsl.rank(last.score, last.member) - z.zsl.rank(first.score, first.member) + 1
+		}
+	}
+	msg, _, _ := serializeInteger(int64(count))
+	sendMsgToClient(conn, msg)
+}
+
+func handleZRank(arr []interface{}, conn *client, store *dictionary) {
+	zrankGeneric(arr, conn, store, false)
+}
+
+func handleZRevRank(arr []interface{}, conn *client, store *dictionary) {
+	zrankGeneric(arr, conn, store, true)
+}
+
+// zrankGeneric implements ZRANK and ZREVRANK, with the optional WITHSCORE
+// argument.
+func zrankGeneric(arr []interface{}, conn *client, store *dictionary, reverse bool) {
+	withScore := false
+	if len(arr) == 4 {
+		if strings.ToLower(arr[3].(string)) != "withscore" {
+			sendErrorToClient(conn, "ERR syntax error")
+			return
+		}
+		withScore = true
+	}
+	if len(arr) > 4 {
+		sendErrorToClient(conn, "ERR syntax error")
+		return
+	}
+	notFound := conn.serializeNull()
+	if withScore {
+		notFound = conn.serializeNullArray()
+	}
+
+	z, ok, err := getSortedSet(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if !ok {
+		sendMsgToClient(conn, notFound)
+		return
+	}
+	member := arr[2].(string)
+	score, ok := z.score(member)
+	if !ok {
+		sendMsgToClient(conn, notFound)
+		return
+	}
+	rank := z.zsl.rank(score, member) - 1
+	if reverse {
+		rank = z.size() - 1 - rank
+	}
+
+	msg, _, _ := serializeInteger(int64(rank))
+	if withScore {
+		msg = serializeArray([]string{msg, conn.serializeDouble(score)})
+	}
+	sendMsgToClient(conn, msg)
+}
+
+type zrangeKind int
+
+const (
+	zrangeByRank zrangeKind = iota
+	zrangeByScore
+	zrangeByLex
+)
+
+// zrangeSpec describes the elements selected by the ZRANGE family.
+type zrangeSpec struct {
+	kind       zrangeKind
+	reverse    bool
+	start      int64
+	stop       int64
+	scores     scoreRange
+	lex        lexRange
+	offset     int64
+	limit      int64 // Negative for no limit.
+	withScores bool
+}
+
+// parseZRangeSpec parses "min max [options]". When auto is set, as for ZRANGE
+// and ZRANGESTORE, the options may pick the kind of range and its direction,
+// otherwise kind and reverse are fixed by the legacy command. store disallows
+// WITHSCORES.
+func parseZRangeSpec(args []interface{}, kind zrangeKind, reverse bool, auto bool, store bool) (zrangeSpec, error) {
+	spec := zrangeSpec{kind: kind, reverse: reverse, limit: -1}
+	hasLimit := false
+	for i := 2; i < len(args); i++ {
+		opt := strings.ToLower(args[i].(string))
+		switch {
+		case opt == "withscores" && !store:
+			spec.withScores = true
+		case opt == "limit" && i+2 < len(args):
+			offset, err := parseInteger(args[i+1])
+			if err != nil {
+				return spec, err
+			}
+			limit, err := parseInteger(args[i+2])
+			if err != nil {
+				return spec, err
+			}
+			spec.offset, spec.limit = offset, limit
+			hasLimit = true
+			i += 2
+		case opt == "byscore" && auto && spec.kind == zrangeByRank:
+			spec.kind = zrangeByScore
+		case opt == "bylex" && auto && spec.kind == zrangeByRank:
+			spec.kind = zrangeByLex
+		case opt == "rev" && auto:
+			spec.reverse = true
+		default:
+			return spec, errors.New("ERR syntax error")
+		}
+	}
+	if hasLimit && spec.kind == zrangeByRank {
+		return spec, errors.New("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if spec.withScores && spec.kind == zrangeByLex {
+		return spec, errors.New("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+
+	// Reversed score and lex ranges are given as max, then min.
+	min, max := args[0].(string), args[1].(string)
+	if spec.reverse && spec.kind != zrangeByRank {
+		min, max = max, min
+	}
+	var err error
+	switch spec.kind {
+	case zrangeByRank:
+		if spec.start, err = parseInteger(min); err != nil {
+			return spec, err
+		}
+		if spec.stop, err = parseInteger(max); err != nil {
+			return spec, err
+		}
+	case zrangeByScore:
+		spec.scores, err = parseScoreRange(min, max)
+	case zrangeByLex:
+		spec.lex, err = parseLexRange(min, max)
+	}
+	return spec, err
+}
+
+// zrangeNodes returns the nodes of z selected by spec, in reply order.
+func zrangeNodes(z *sortedSet, spec zrangeSpec) []*skiplistNode {
+	var nodes []*skiplistNode
+	if spec.kind == zrangeByRank {
+		length := int64(z.size())
+		start, stop := spec.start, spec.stop
+		if start < 0 {
+			start += length
+		}
+		if stop < 0 {
+			stop += length
+		}
+		start = max(start, 0)
+		if start > stop || start >= length {
+			return nil
+		}
+		stop = min(stop, length-1)
+
+		var x *skiplistNode
+		if spec.reverse {
+			x = z.zsl.byRank(int(length - start))
+		} else {
+			x = z.zsl.byRank(int(start + 1))
+		}
+		for i := start; i <= stop; i++ {
+			nodes = append(nodes, x)
+			if spec.reverse {
+				x = x.backward
+			} else {
+				x = x.level[0].forward
+			}
+		}
+		return nodes
+	}
+
+	if spec.offset < 0 {
+		return nil
+	}
+	var x *skiplistNode
+	var inRange func(*skiplistNode) bool
+	switch {
+	case spec.kind == zrangeByScore && spec.reverse:
+		x = z.zsl.lastInScoreRange(spec.scores)
+		inRange = func(n *skiplistNode) bool { return spec.scores.aboveMin(n.score) }
+	case spec.kind == zrangeByScore:
+		x = z.zsl.firstInScoreRange(spec.scores)
+		inRange = func(n *skiplistNode) bool { return spec.scores.belowMax(n.score) }
+	case spec.reverse:
+		x = z.zsl.lastInLexRange(spec.lex)
+		inRange = func(n *skiplistNode) bool { return spec.lex.aboveMin(n.member) }
+	default:
+		x = z.zsl.firstInLexRange(spec.lex)
+		inRange = func(n *skiplistNode) bool { return spec.lex.belowMax(n.member) }
+	}
+	next := func(n *skiplistNode) *skiplistNode {
+		if spec.reverse {
+			return n.backward
+		}
+		return n.level[0].forward
+	}
+
+	for offset := spec.offset; x != nil && offset > 0; offset-- {
+		x = next(x)
+	}
+	for x != nil && spec.limit != 0 && inRange(x) {
+		nodes = append(nodes, x)
+		spec.limit--
+		x = next(x)
+	}
+	return nodes
+}
+
+func handleZRange(arr []interface{}, conn *client, store *dictionary) {
+	zrangeGeneric(arr, conn, store, zrangeByRank, false, true)
+}
+
+func handleZRevRange(arr []interface{}, conn *client, store *dictionary) {
+	zrangeGeneric(arr, conn, store, zrangeByRank, true, false)
+}
+
+func handleZRangeByScore(arr []interface{}, conn *client, store *dictionary) {
+	zrangeGeneric(arr, conn, store, zrangeByScore, false, false)
+}
+
+func handleZRevRangeByScore(arr []interface{}, conn *client, store *dictionary) {
+	zrangeGeneric(arr, conn, store, zrangeByScore, true, false)
+}
+
+func handleZRangeByLex(arr []interface{}, conn *client, store *dictionary) {
+	zrangeGeneric(arr, conn, store, zrangeByLex, false, false)
+}
+
+func handleZRevRangeByLex(arr []interface{}, conn *client, store *dictionary) {
+	zrangeGeneric(arr, conn, store, zrangeByLex, true, false)
+}
+
+// zrangeGeneric implements ZRANGE and its legacy variants.
+func zrangeGeneric(arr []interface{}, conn *client, store *dictionary, kind zrangeKind, reverse bool, auto bool) {
+	spec, err := parseZRangeSpec(arr[2:], kind, reverse, auto, false)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	z, ok, err := getSortedSet(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if !ok {
+		z = newSortedSet()
+	}
+	sendMsgToClient(conn, serializeScoredMembers(conn, zrangeNodes(z, spec), spec.withScores))
+}
+
+func handleZRangeStore(arr []interface{}, conn *client, store *dictionary) {
+	spec, err := parseZRangeSpec(arr[3:], zrangeByRank, false, true, true)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	src, ok, err := getSortedSet(store, arr[2].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if !ok {
+		src = newSortedSet()
+	}
+	result := newSortedSet()
+	for _, n := range zrangeNodes(src, spec) {
+		result.set(n.member, n.score)
+	}
+	storeSortedSet(store, arr[1].(string), result)
+
+	msg, _, _ := serializeInteger(int64(result.size()))
+	sendMsgToClient(conn, msg)
+}
+
+func handleZPopMin(arr []interface{}, conn *client, store *dictionary) {
+	zpopGeneric(arr, conn, store, false)
+}
+
+func handleZPopMax(arr []interface{}, conn *client, store *dictionary) {
+	zpopGeneric(arr, conn, store, true)
+}
+
+// zpopGeneric implements ZPOPMIN and ZPOPMAX. Without a count the reply is a
+// flat member and score, with a count RESP3 clients get a pair per member.
+func zpopGeneric(arr []interface{}, conn *client, store *dictionary, highest bool) {
+	if len(arr) > 3 {
+		sendErrorToClient(conn, "ERR syntax error")
+		return
+	}
+	key := arr[1].(string)
+	count := int64(1)
+	if len(arr) == 3 {
+		var err error
+		count, err = parseInteger(arr[2])
+		if err != nil || count < 0 {
+			sendErrorToClient(conn, "ERR value is out of range, must be positive")
+			return
+		}
+	}
+
+	z, ok, err := getSortedSet(store, key)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if !ok {
+		sendMsgToClient(conn, serializeArray(nil))
+		return
+	}
+
+	var popped []*skiplistNode
+	for ; count > 0 && z.size() > 0; count-- {
+		x := z.zsl.byRank(1)
+		if highest {
+			x = z.zsl.tail
+		}
+		z.remove(x.member)
+		popped = append(popped, x)
+	}
+	if z.size() == 0 {
+		deleteKey(store, key)
+	} else if len(popped) > 0 {
+		signalModifiedKey(store, key)
+	}
+
+	if len(arr) == 2 && len(popped) == 1 {
+		sendMsgToClient(conn, serializeArray([]string{
+			serializeBulkString(popped[0].member), conn.serializeDouble(popped[0].score)}))
+		return
+	}
+	sendMsgToClient(conn, serializeScoredMembers(conn, popped, true))
+}
+
+func handleZRemRangeByRank(arr []interface{}, conn *client, store *dictionary) {
+	zremRangeGeneric(arr, conn, store, zrangeByRank)
+}
+
+func handleZRemRangeByScore(arr []interface{}, conn *client, store *dictionary) {
+	zremRangeGeneric(arr, conn, store, zrangeByScore)
+}
+
+func handleZRemRangeByLex(arr []interface{}, conn *client, store *dictionary) {
+	zremRangeGeneric(arr, conn, store, zrangeByLex)
+}
+
+// zremRangeGeneric implements ZREMRANGEBYRANK, ZREMRANGEBYSCORE and
+// ZREMRANGEBYLEX.
+func zremRangeGeneric(arr []interface{}, conn *client, store *dictionary, kind zrangeKind) {
+	key := arr[1].(string)
+	spec, err := parseZRangeSpec(arr[2:], kind, false, false, true)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	z, ok, err := getSortedSet(store, key)
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	removed := 0
+	if ok {
+		for _, n := range zrangeNodes(z, spec) {
+			z.remove(n.member)
+			removed++
+		}
+		if z.size() == 0 {
+			deleteKey(store, key)
+		} else if removed > 0 {
+			signalModifiedKey(store, key)
+		}
+	}
+
+	msg, _, _ := serializeInteger(int64(removed))
+	sendMsgToClient(conn, msg)
+}
+
+func handleZUnionStore(arr []interface{}, conn *client, store *dictionary) {
+	zsetStoreGeneric(arr, conn, store, false)
+}
+
+func handleZInterStore(arr []interface{}, conn *client, store *dictionary) {
+	zsetStoreGeneric(arr, conn, store, true)
+}
+
+// zsetStoreGeneric implements ZUNIONSTORE and ZINTERSTORE, which take
+// "destination numkeys key [key ...] [WEIGHTS weight [weight ...]]
+// [AGGREGATE SUM|MIN|MAX]". Plain sets count as sorted sets with all scores 1.
+func zsetStoreGeneric(arr []interface{}, conn *client, store *dictionary, inter bool) {
+	numKeys, err := parseInteger(arr[2])
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if numKeys < 1 {
+		sendErrorToClient(conn, "ERR at least 1 input key is needed for '"+strings.ToLower(arr[0].(string))+"' command")
+		return
+	}
+	if numKeys > int64(len(arr)-3) {
+		sendErrorToClient(conn, "ERR syntax error")
+		return
+	}
+	keys := arr[3 : 3+numKeys]
+
+	weights := make([]float64, numKeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	aggregate := "sum"
+	rest := arr[3+numKeys:]
+	for i := 0; i < len(rest); i++ {
+		opt := strings.ToLower(rest[i].(string))
+		switch {
+		case opt == "weights" && int64(len(rest)-i-1) >= numKeys:
+			for j := range weights {
+				weights[j], err = strconv.ParseFloat(rest[i+1+j].(string), 64)
+				if err != nil || math.IsNaN(weights[j]) {
+					sendErrorToClient(conn, "ERR weight value is not a float")
+					return
+				}
+			}
+			i += int(numKeys)
+		case opt == "aggregate" && i+1 < len(rest):
+			aggregate = strings.ToLower(rest[i+1].(string))
+			if aggregate != "sum" && aggregate != "min" && aggregate != "max" {
+				sendErrorToClient(conn, "ERR syntax error")
+				return
+			}
+			i++
+		default:
+			sendErrorToClient(conn, "ERR syntax error")
+			return
+		}
+	}
+
+	inputs := make([]map[string]float64, numKeys)
+	for i, key := range keys {
+		rec, ok := lookupRecord(store, key.(string))
+		if !ok {
+			inputs[i] = map[string]float64{}
+			continue
+		}
+		switch val := rec.value.(type) {
+		case *sortedSet:
+			inputs[i] = val.dict
+		case *set:
+			inputs[i] = make(map[string]float64, val.size())
+			for _, member := range val.list() {
+				inputs[i][member] = 1
+			}
+		default:
+			sendErrorToClient(conn, errWrongType.Error())
+			return
+		}
+	}
+
+	combine := func(acc, score float64) float64 {
+		switch aggregate {
+		case "min":
+			return min(acc, score)
+		case "max":
+			return max(acc, score)
+		}
+		// inf + -inf is NaN, which isn't a valid score.
+		if sum := acc + score; !math.IsNaN(sum) {
+			return sum
+		}
+		return 0
+	}
+	weighted := func(score, weight float64) float64 {
+		if product := score * weight; !math.IsNaN(product) {
+			return product
+		}
+		return 0
+	}
+
+	scores := map[string]float64{}
+	for i, input := range inputs {
+		for member, score := range input {
+			score = weighted(score, weights[i])
+			if acc, ok := scores[member]; ok {
+				scores[member] = combine(acc, score)
+			} else if !inter || i == 0 {
+				scores[member] = score
+			}
+		}
+		if inter && i > 0 {
+			for member := range scores {
+				if _, ok := input[member]; !ok {
+					delete(scores, member)
+				}
+			}
+		}
+	}
+
+	result := newSortedSet()
+	for member, score := range scores {
+		result.set(member, score)
+	}
+	storeSortedSet(store, arr[1].(string), result)
+
+	msg, _, _ := serializeInteger(int64(result.size()))
+	sendMsgToClient(conn, msg)
+}
+
+// handleZScan walks the members of the sorted set a few buckets per call, like
+// SCAN.
+func handleZScan(arr []interface{}, conn *client, store *dictionary) {
+	opts, err := parseScanOptions(arr[2:], "")
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+
+	z, ok, err := getSortedSet(store, arr[1].(string))
+	if err != nil {
+		sendErrorToClient(conn, err.Error())
+		return
+	}
+	if !ok {
+		sendMsgToClient(conn, serializeScanReply(0, nil))
+		return
+	}
+	cursor, members := scanTable(z.table, opts)
+	var items []string
+	for _, member := range members {
+		if opts.matches(member) {
+			items = append(items, member, formatDouble(z.dict[member]))
+		}
+	}
+	sendMsgToClient(conn, serializeScanReply(cursor, items))
+}